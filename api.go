@@ -1,4 +1,4 @@
-package main
+package kafkabiller
 
 import "github.com/gorilla/mux"
 
@@ -8,5 +8,11 @@ func server() *mux.Router {
 	// create new handler instance
 	router := mux.NewRouter()
 
+	router.HandleFunc("/metrics", getMetrics).Methods("GET")
+	router.HandleFunc("/struk/decode", postStrukDecode).Methods("POST")
+	router.HandleFunc("/suspects", getSuspects).Methods("GET")
+	router.HandleFunc("/breakers", getBreakers).Methods("GET")
+	router.HandleFunc("/quotas", getQuotas).Methods("GET")
+
 	return router
 }
@@ -0,0 +1,372 @@
+package kafkabiller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-yaml/yaml"
+)
+
+// Biller environment, loaded from biller.yml at startup
+var billerEnv *BillerEnvironment
+
+// Environment variable that overrides the Environment in biller.yml
+const billerEnvironmentVariable = "CHIPSAKTI_BILLER_ENV"
+
+// Biller calls with a configurable path
+var billerCalls = []string{"inquiry", "payment", "status", "buy", "check"}
+
+// Struct for biller.yml
+type BillerConfig struct {
+	Environment  string                        `yaml:"Environment"`
+	Environments map[string]*BillerEnvironment `yaml:"Environments"`
+}
+
+// Billers of a single environment and which of them serves a request
+type BillerEnvironment struct {
+	Name     string                       `yaml:"-"`
+	Default  string                       `yaml:"Default"`
+	Partners map[string]string            `yaml:"Partners"`
+	Products map[string]string            `yaml:"Products"`
+	Billers  map[string]*BillerConnection `yaml:"Billers"`
+
+	// Suppliers of Topup Buy per product code
+	TopupRoutes map[string]*TopupRoute `yaml:"TopupRoutes"`
+
+	// Client of every Biller by name
+	billers map[string]Biller
+}
+
+// Connection to a single Biller
+type BillerConnection struct {
+	Name            string            `yaml:"-"`
+	Type            string            `yaml:"Type"`
+	BaseURL         string            `yaml:"BaseURL"`
+	Endpoints       map[string]string `yaml:"Endpoints"`
+	ConnectTimeout  time.Duration     `yaml:"ConnectTimeout"`
+	ReadTimeout     time.Duration     `yaml:"ReadTimeout"`
+	MaxIdleConns    int               `yaml:"MaxIdleConns"`
+	IdleConnTimeout time.Duration     `yaml:"IdleConnTimeout"`
+	TLS             BillerTLS         `yaml:"TLS"`
+	Breaker         BreakerConfig     `yaml:"Breaker"`
+	Signature       SignatureScheme   `yaml:"Signature"`
+	Verify          BillerVerify      `yaml:"Verify"`
+	Limits          BillerLimits      `yaml:"Limits"`
+
+	// Shared by every call to this Biller so keep-alive connections are reused
+	client *http.Client
+
+	// Circuit breaker per endpoint
+	breakers *breakerSet
+
+	// Rate limits and daily quotas
+	limiter *billerLimiter
+}
+
+// TLS settings for the Biller connection
+type BillerTLS struct {
+	CAFile             string `yaml:"CAFile"`
+	CertFile           string `yaml:"CertFile"`
+	KeyFile            string `yaml:"KeyFile"`
+	ServerName         string `yaml:"ServerName"`
+	MinVersion         string `yaml:"MinVersion"`
+	InsecureSkipVerify bool   `yaml:"InsecureSkipVerify"`
+}
+
+// Supported TLS MinVersion values
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Return Biller environment from a yaml file, name "" selects the file's Environment
+func billerEnvironmentFromFile(filename string, name string) (*BillerEnvironment, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var config BillerConfig
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if name == "" {
+		name = config.Environment
+	}
+
+	env, ok := config.Environments[name]
+	if !ok || env == nil {
+		names := make([]string, 0, len(config.Environments))
+		for envName := range config.Environments {
+			names = append(names, envName)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%s: unknown environment %q, expected one of %s", filename, name, strings.Join(names, ", "))
+	}
+	env.Name = name
+	if err := env.validate(); err != nil {
+		return nil, fmt.Errorf("%s: environment %s: %v", filename, name, err)
+	}
+	if err := env.connect(); err != nil {
+		return nil, fmt.Errorf("%s: environment %s: %v", filename, name, err)
+	}
+	return env, nil
+}
+
+// Check that every Biller of the environment can be called and every routing rule names one of them
+func (e *BillerEnvironment) validate() error {
+	if len(e.Billers) == 0 {
+		return fmt.Errorf("no Biller defined")
+	}
+	for name, conn := range e.Billers {
+		if conn == nil {
+			return fmt.Errorf("biller %s: no settings", name)
+		}
+		conn.Name = name
+		if err := conn.validate(e.Name); err != nil {
+			return fmt.Errorf("biller %s: %v", name, err)
+		}
+	}
+
+	if _, ok := e.Billers[e.Default]; !ok {
+		return fmt.Errorf("default Biller %q is not defined", e.Default)
+	}
+	for partner, name := range e.Partners {
+		if _, ok := e.Billers[name]; !ok {
+			return fmt.Errorf("partner %s: Biller %q is not defined", partner, name)
+		}
+	}
+	for product, name := range e.Products {
+		if _, ok := e.Billers[name]; !ok {
+			return fmt.Errorf("product %s: Biller %q is not defined", product, name)
+		}
+	}
+	for product, route := range e.TopupRoutes {
+		if route == nil {
+			return fmt.Errorf("topup route %s: no settings", product)
+		}
+		if err := route.validate(e.Billers); err != nil {
+			return fmt.Errorf("topup route %s: %v", product, err)
+		}
+	}
+	return nil
+}
+
+// Create the client of every Biller
+func (e *BillerEnvironment) connect() error {
+	e.billers = make(map[string]Biller, len(e.Billers))
+	for name, conn := range e.Billers {
+		if err := conn.connect(); err != nil {
+			return fmt.Errorf("biller %s: %v", name, err)
+		}
+		e.billers[name] = billerTypes[conn.Type](conn)
+	}
+	return nil
+}
+
+// Return Biller for a request, a partner rule takes precedence over a product rule and both
+// over the Biller of the route
+func (e *BillerEnvironment) biller(routeBiller string, productCode string, partnerID string) Biller {
+	if name, ok := e.Partners[partnerID]; ok {
+		return e.billers[name]
+	}
+	if name, ok := e.Products[productCode]; ok {
+		return e.billers[name]
+	}
+	if biller, ok := e.billers[routeBiller]; ok {
+		return biller
+	}
+	return e.billers[e.Default]
+}
+
+// Return Billers a Topup Buy is sent to in order with the product's topup route, a partner rule
+// pins the partner to a single Biller
+func (e *BillerEnvironment) topupBillers(routeBiller string, productCode string, partnerID string) (*TopupRoute, []Biller) {
+	route, ok := e.TopupRoutes[productCode]
+	if _, pinned := e.Partners[partnerID]; pinned || !ok {
+		return &TopupRoute{}, []Biller{e.biller(routeBiller, productCode, partnerID)}
+	}
+
+	billers := make([]Biller, 0, len(route.Suppliers))
+	for _, supplier := range route.Suppliers {
+		billers = append(billers, e.billers[supplier.Biller])
+	}
+	return route, billers
+}
+
+// Return connection of a Biller by name, "" is the default Biller
+func (e *BillerEnvironment) connection(name string) (*BillerConnection, bool) {
+	if name == "" {
+		name = e.Default
+	}
+	conn, ok := e.Billers[name]
+	return conn, ok
+}
+
+// Return Biller by name
+func (e *BillerEnvironment) billerNamed(name string) (Biller, bool) {
+	biller, ok := e.billers[name]
+	return biller, ok
+}
+
+// Return circuit breaker state of every Biller endpoint, sorted by Biller and endpoint
+func (e *BillerEnvironment) breakerStatus() []BreakerStatus {
+	names := make([]string, 0, len(e.Billers))
+	for name := range e.Billers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]BreakerStatus, 0)
+	for _, name := range names {
+		result = append(result, e.Billers[name].breakers.status()...)
+	}
+	return result
+}
+
+// Return daily quotas of every Biller, sorted by Biller
+func (e *BillerEnvironment) quotaStatus() []QuotaStatus {
+	names := make([]string, 0, len(e.Billers))
+	for name := range e.Billers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]QuotaStatus, 0)
+	for _, name := range names {
+		result = append(result, e.Billers[name].limiter.status()...)
+	}
+	return result
+}
+
+func (e *BillerEnvironment) String() string {
+	billers := make([]string, 0, len(e.Billers))
+	for _, conn := range e.Billers {
+		billers = append(billers, conn.String())
+	}
+	sort.Strings(billers)
+	return fmt.Sprintf("%s, default %s, billers: %s", e.Name, e.Default, strings.Join(billers, "; "))
+}
+
+// Check that the Biller can be called with this connection, missing endpoints get their default path
+func (c *BillerConnection) validate(environment string) error {
+	if c.Type == "" {
+		c.Type = "chipsakti"
+	}
+	if _, ok := billerTypes[c.Type]; !ok {
+		return fmt.Errorf("unknown type %q", c.Type)
+	}
+
+	if !strings.HasPrefix(c.BaseURL, "https://") && !strings.HasPrefix(c.BaseURL, "http://") {
+		return fmt.Errorf("base URL must start with http:// or https://")
+	}
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+
+	if c.Endpoints == nil {
+		c.Endpoints = make(map[string]string, len(billerCalls))
+	}
+	for _, call := range billerCalls {
+		if c.Endpoints[call] == "" {
+			c.Endpoints[call] = "/" + call
+		}
+	}
+	for call, path := range c.Endpoints {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("endpoint %s must start with /", call)
+		}
+	}
+
+	if c.ConnectTimeout <= 0 || c.ReadTimeout <= 0 {
+		return fmt.Errorf("connect and read timeout must be set")
+	}
+	if c.MaxIdleConns < 0 || c.IdleConnTimeout < 0 {
+		return fmt.Errorf("connection pool settings can't be negative")
+	}
+
+	if _, ok := tlsVersions[c.TLS.MinVersion]; !ok {
+		return fmt.Errorf("unsupported TLS version %q", c.TLS.MinVersion)
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("TLS needs both CertFile and KeyFile")
+	}
+	if c.TLS.InsecureSkipVerify && environment == "production" {
+		return fmt.Errorf("TLS verification can't be skipped in production")
+	}
+	if err := c.Signature.validate(); err != nil {
+		return fmt.Errorf("signature: %v", err)
+	}
+	if err := c.Verify.validate(&c.Signature); err != nil {
+		return fmt.Errorf("verify: %v", err)
+	}
+	if err := c.Limits.validate(); err != nil {
+		return fmt.Errorf("limits: %v", err)
+	}
+	return c.Breaker.validate()
+}
+
+// Read the signature secrets and create the shared HTTP client, circuit breakers and limits of this connection
+func (c *BillerConnection) connect() error {
+	if err := c.Signature.load(); err != nil {
+		return fmt.Errorf("signature: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tlsVersions[c.TLS.MinVersion],
+		ServerName:         c.TLS.ServerName,
+		InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+	}
+	if c.TLS.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificate found", c.TLS.CAFile)
+		}
+	}
+	if c.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// Every call goes to the same host, so all idle connections may be kept for it
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: c.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   c.ConnectTimeout,
+		ResponseHeaderTimeout: c.ReadTimeout,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConns,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		ForceAttemptHTTP2:     true,
+	}
+	c.client = &http.Client{
+		Transport: transport,
+		Timeout:   c.ConnectTimeout + c.ReadTimeout,
+	}
+	c.breakers = newBreakerSet(c.Name, c.Breaker)
+	c.limiter = newBillerLimiter(c.Name, c.Limits, dailyQuotas)
+	return nil
+}
+
+// Return URL of a Biller call
+func (c *BillerConnection) url(path string) string {
+	return c.BaseURL + path
+}
+
+func (c *BillerConnection) String() string {
+	return fmt.Sprintf("%s (%s %s, connect %v, read %v)", c.Name, c.Type, c.BaseURL, c.ConnectTimeout, c.ReadTimeout)
+}
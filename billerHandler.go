@@ -1,69 +1,227 @@
-package main
+package kafkabiller
 
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
-	"strconv"
+	"time"
 )
 
-// Return PPOB Inquiry response in JSON
-func responseJsonPPOBInquiry(jsonIso PPOBInquiryRequest) PPOBInquiryResponse {
-	var response PPOBInquiryResponse
+// Biller is an aggregator that serves PPOB and topup products
+type Biller interface {
+	Name() string
+	Inquiry(request PPOBInquiryRequest) (PPOBInquiryResponse, error)
+	Payment(request PPOBPaymentRequest) (PPOBPaymentResponse, error)
+	Status(request PPOBStatusRequest) (PPOBStatusResponse, error)
+	TopupBuy(request TopupBuyRequest) (TopupBuyResponse, error)
+	TopupCheck(request TopupCheckRequest) (TopupCheckResponse, error)
+}
 
-	// Client setup for custom http request
-	client := &http.Client{}
-	var baseURL = "https://chipsakti-mock.herokuapp.com"
+// Biller implementations that can be used as Type in biller.yml, an aggregator with a new API
+// is added by implementing Biller and registering it here
+var billerTypes = map[string]func(conn *BillerConnection) Biller{
+	"chipsakti": func(conn *BillerConnection) Biller { return &chipsaktiBiller{conn: conn} },
+	"json":      func(conn *BillerConnection) Biller { return &jsonBiller{conn: conn} },
+}
 
-	// Set data to be encoded
-	var param = url.Values{}
-	param.Set("transaction_id", jsonIso.TransactionID)
-	param.Set("partner_id", jsonIso.PartnerID)
-	param.Set("product_code", jsonIso.ProductCode)
-	param.Set("customer_no", jsonIso.CustomerNo)
-	param.Set("periode", jsonIso.Periode)
-	param.Set("merchant_code", jsonIso.MerchantCode)
-	param.Set("request_time", jsonIso.RequestTime)
-	param.Set("signature", jsonIso.Signature)
+// Classes of failed Biller calls
+const (
+	billerRequestFailed = "request"        // request can't be built
+	billerConnectFailed = "connect"        // Biller can't be reached or dropped the connection
+	billerTimedOut      = "timeout"        // Biller didn't answer in time
+	billerBadStatus     = "status"         // Biller answered with a non-2xx status
+	billerInvalidJSON   = "invalid"        // response body isn't the expected JSON
+	billerMissingRC     = "missing_rc"     // response has no rc
+	billerCircuitOpen   = "circuit_open"   // endpoint's circuit breaker refused the call
+	billerBulkheadFull  = "bulkhead_full"  // endpoint already has its maximum calls in flight
+	billerUnverified    = "unverified"     // response signature or echoed fields don't match
+	billerRateLimited   = "rate_limited"   // rate limit of the endpoint or partner didn't allow the call in time
+	billerQuotaExceeded = "quota_exceeded" // daily quota of the endpoint or partner is used up
+)
+
+// ISO8583 response code (field 39) sent back for each class of failed Biller call
+var billerErrorResponseCodes = map[string]string{
+	billerRequestFailed: "96", // System malfunction
+	billerConnectFailed: "91", // Issuer or switch inoperative
+	billerTimedOut:      "68", // Response received too late
+	billerBadStatus:     "91",
+	billerInvalidJSON:   "96",
+	billerMissingRC:     "96",
+	billerCircuitOpen:   "91",
+	billerBulkheadFull:  "91",
+	billerUnverified:    "96",
+	billerRateLimited:   "91",
+	billerQuotaExceeded: "65", // Exceeds withdrawal frequency limit
+}
+
+// BillerError describes a failed Biller call
+type BillerError struct {
+	Kind       string
+	Biller     string
+	Endpoint   string
+	StatusCode int
+	Err        error
+}
+
+func (e *BillerError) Error() string {
+	if e.Kind == billerBadStatus {
+		return fmt.Sprintf("biller %s %s: unexpected status %d", e.Biller, e.Endpoint, e.StatusCode)
+	}
+	return fmt.Sprintf("biller %s %s: %s: %v", e.Biller, e.Endpoint, e.Kind, e.Err)
+}
+
+func (e *BillerError) Unwrap() error {
+	return e.Err
+}
+
+// Return ISO8583 response code for a failed Biller call, 96 for any other error
+func billerResponseCode(err error) string {
+	var billerErr *BillerError
+	if errors.As(err, &billerErr) {
+		if rc, ok := billerErrorResponseCodes[billerErr.Kind]; ok {
+			return rc
+		}
+	}
+	return "96"
+}
+
+// Return class of an error returned by the HTTP client, a dial that failed or timed out never
+// sent the request so it isn't a timeout of the call
+func billerErrorKind(err error) string {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return billerConnectFailed
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return billerTimedOut
+	}
+	return billerConnectFailed
+}
+
+// postForm sends form data to a Biller endpoint and decodes its JSON response into response
+func (c *BillerConnection) postForm(endpoint string, param url.Values, response interface{}) error {
+	request := make(map[string]string, len(param))
+	for name := range param {
+		request[name] = param.Get(name)
+	}
+	return c.send(endpoint, request, "application/x-www-form-urlencoded", []byte(param.Encode()), response)
+}
+
+// postJSON sends request as JSON to a Biller endpoint and decodes its JSON response into response
+func (c *BillerConnection) postJSON(endpoint string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return &BillerError{Kind: billerRequestFailed, Biller: c.Name, Endpoint: endpoint, Err: err}
+	}
+	values, err := jsonValues(body)
+	if err != nil {
+		return &BillerError{Kind: billerRequestFailed, Biller: c.Name, Endpoint: endpoint, Err: err}
+	}
+	return c.send(endpoint, values, "application/json", body, response)
+}
+
+// send posts body to a Biller endpoint and decodes its JSON response into response,
+// the rate limits, daily quotas and the endpoint's circuit breaker may refuse the call without
+// sending it. request holds the fields of body the response is checked against.
+func (c *BillerConnection) send(endpoint string, request map[string]string, contentType string, body []byte, response interface{}) error {
+	release, err := c.limiter.acquire(endpoint, request["partner_id"])
+	if err != nil {
+		log.Printf("Call to %s refused. Error: %v\n", c.url(endpoint), err)
+		return err
+	}
+	done, err := c.breakers.get(endpoint).acquire()
+	if err != nil {
+		release()
+		log.Printf("Call to %s refused. Error: %v\n", c.url(endpoint), err)
+		return err
+	}
 
-	log.Printf("Send request to https://chipsakti-mock.herokuapp.com/inquiry\n")
+	start := time.Now()
+	err = c.call(endpoint, request, contentType, body, response)
+	done(err != nil, time.Since(start))
+
+	// A call that never left the service doesn't use up the quotas and rate limits
+	if billerNotSent(err) {
+		release()
+	}
+	return err
+}
+
+// call posts body to a Biller endpoint and decodes its JSON response into response
+func (c *BillerConnection) call(endpoint string, request map[string]string, contentType string, body []byte, response interface{}) error {
+
+	log.Printf("Send request to %s\n", c.url(endpoint))
 
 	// Request to Biller
-	var payload = bytes.NewBufferString(param.Encode())
-	req, err := http.NewRequest("POST", baseURL+"/inquiry", payload)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req, err := http.NewRequest("POST", c.url(endpoint), bytes.NewReader(body))
 	if err != nil {
-		log.Fatalf("Failed to sent request to https://chipsakti-mock.herokuapp.com/inquiry. Error: %v\n", err)
+		return &BillerError{Kind: billerRequestFailed, Biller: c.Name, Endpoint: endpoint, Err: err}
 	}
+	req.Header.Set("Content-Type", contentType)
 
 	// Check response from Biller
-	resp, err := client.Do(req)
+	resp, err := c.client.Do(req)
 	if err != nil {
-		log.Fatalf("Failed to get response from https://chipsakti-mock.herokuapp.com/inquiry. Error: %v\n", err)
+		return &BillerError{Kind: billerErrorKind(err), Biller: c.Name, Endpoint: endpoint, Err: err}
 	}
 
 	defer resp.Body.Close()
 
-	log.Printf("Receive response from https://chipsakti-mock.herokuapp.com/inquiry\n")
+	log.Printf("Receive response from %s (status %d)\n", c.url(endpoint), resp.StatusCode)
 
 	// Read response from Biller
-	body, _ := ioutil.ReadAll(resp.Body)
-	json.Unmarshal(body, &response)
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &BillerError{Kind: billerErrorKind(err), Biller: c.Name, Endpoint: endpoint, Err: err}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &BillerError{Kind: billerBadStatus, Biller: c.Name, Endpoint: endpoint, StatusCode: resp.StatusCode}
+	}
+
+	// Every Biller response carries rc, a response without it can't be answered
+	var fields map[string]interface{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return &BillerError{Kind: billerInvalidJSON, Biller: c.Name, Endpoint: endpoint, Err: err}
+	}
+	if rc, ok := fields["rc"].(string); !ok || rc == "" {
+		return &BillerError{Kind: billerMissingRC, Biller: c.Name, Endpoint: endpoint, Err: fmt.Errorf("response has no rc")}
+	}
+	if err := c.verify(endpoint, request, resp.Header, content); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, response); err != nil {
+		return &BillerError{Kind: billerInvalidJSON, Biller: c.Name, Endpoint: endpoint, Err: err}
+	}
 
-	return response
+	return nil
 }
 
-// Return PPOB Payment response in JSON
-func responsePPOBPayment(jsonIso PPOBPaymentRequest) PPOBPaymentResponse {
-	var response PPOBPaymentResponse
+// ChipSakti API, requests are sent as form data
+type chipsaktiBiller struct {
+	conn *BillerConnection
+}
 
-	// Client setup for custom http request
-	client := &http.Client{}
-	var baseURL = "https://chipsakti-mock.herokuapp.com"
-	amount := strconv.Itoa(jsonIso.Amount)
+func (b *chipsaktiBiller) Name() string {
+	return b.conn.Name
+}
+
+// Return PPOB Inquiry response in JSON
+func (b *chipsaktiBiller) Inquiry(jsonIso PPOBInquiryRequest) (PPOBInquiryResponse, error) {
+	var response PPOBInquiryResponse
+
+	// Sign request with the secret shared with this Biller
+	signature, err := b.conn.sign("inquiry", jsonIso.PartnerID, jsonIso)
+	if err != nil {
+		return response, err
+	}
+	jsonIso.Signature = signature
 
 	// Set data to be encoded
 	var param = url.Values{}
@@ -71,47 +229,52 @@ func responsePPOBPayment(jsonIso PPOBPaymentRequest) PPOBPaymentResponse {
 	param.Set("partner_id", jsonIso.PartnerID)
 	param.Set("product_code", jsonIso.ProductCode)
 	param.Set("customer_no", jsonIso.CustomerNo)
-	param.Set("reff_id", jsonIso.ReffID)
-	param.Set("amount", amount)
+	param.Set("periode", jsonIso.Periode)
 	param.Set("merchant_code", jsonIso.MerchantCode)
 	param.Set("request_time", jsonIso.RequestTime)
 	param.Set("signature", jsonIso.Signature)
 
-	log.Printf("Send request to https://chipsakti-mock.herokuapp.com/payment\n")
+	err = b.conn.postForm(b.conn.Endpoints["inquiry"], param, &response)
+	return response, err
+}
 
-	// Request to Biller
-	var payload = bytes.NewBufferString(param.Encode())
-	req, err := http.NewRequest("POST", baseURL+"/payment", payload)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	if err != nil {
-		log.Fatalf("Failed to sent request to https://chipsakti-mock.herokuapp.com/payment. Error: %v\n", err)
-	}
+// Return PPOB Payment response in JSON
+func (b *chipsaktiBiller) Payment(jsonIso PPOBPaymentRequest) (PPOBPaymentResponse, error) {
+	var response PPOBPaymentResponse
 
-	// Check response from Biller
-	resp, err := client.Do(req)
+	// Sign request with the secret shared with this Biller
+	signature, err := b.conn.sign("payment", jsonIso.PartnerID, jsonIso)
 	if err != nil {
-		log.Fatalf("Failed to get response from https://chipsakti-mock.herokuapp.com/payment. Error: %v\n", err)
+		return response, err
 	}
+	jsonIso.Signature = signature
 
-	defer resp.Body.Close()
-
-	log.Printf("Receive response from https://chipsakti-mock.herokuapp.com/payment\n")
-
-	// Read response from Biller
-	body, _ := ioutil.ReadAll(resp.Body)
-	json.Unmarshal(body, &response)
+	// Set data to be encoded
+	var param = url.Values{}
+	param.Set("transaction_id", jsonIso.TransactionID)
+	param.Set("partner_id", jsonIso.PartnerID)
+	param.Set("product_code", jsonIso.ProductCode)
+	param.Set("customer_no", jsonIso.CustomerNo)
+	param.Set("reff_id", jsonIso.ReffID)
+	param.Set("amount", jsonIso.Amount.String())
+	param.Set("merchant_code", jsonIso.MerchantCode)
+	param.Set("request_time", jsonIso.RequestTime)
+	param.Set("signature", jsonIso.Signature)
 
-	return response
+	err = b.conn.postForm(b.conn.Endpoints["payment"], param, &response)
+	return response, err
 }
 
 // Return PPOB Status response in JSON
-func responsePPOBStatus(jsonIso PPOBStatusRequest) PPOBStatusResponse {
+func (b *chipsaktiBiller) Status(jsonIso PPOBStatusRequest) (PPOBStatusResponse, error) {
 	var response PPOBStatusResponse
 
-	// Client setup for custom http request
-	client := &http.Client{}
-	var baseURL = "https://chipsakti-mock.herokuapp.com"
-	amount := strconv.Itoa(jsonIso.Amount)
+	// Sign request with the secret shared with this Biller
+	signature, err := b.conn.sign("status", jsonIso.PartnerID, jsonIso)
+	if err != nil {
+		return response, err
+	}
+	jsonIso.Signature = signature
 
 	// Set data to be encoded
 	var param = url.Values{}
@@ -120,45 +283,50 @@ func responsePPOBStatus(jsonIso PPOBStatusRequest) PPOBStatusResponse {
 	param.Set("product_code", jsonIso.ProductCode)
 	param.Set("customer_no", jsonIso.CustomerNo)
 	param.Set("reff_id", jsonIso.ReffID)
-	param.Set("amount", amount)
+	param.Set("amount", jsonIso.Amount.String())
 	param.Set("merchant_code", jsonIso.MerchantCode)
 	param.Set("request_time", jsonIso.RequestTime)
 	param.Set("signature", jsonIso.Signature)
 
-	log.Printf("Send request to https://chipsakti-mock.herokuapp.com/status\n")
+	err = b.conn.postForm(b.conn.Endpoints["status"], param, &response)
+	return response, err
+}
 
-	// Request to Biller
-	var payload = bytes.NewBufferString(param.Encode())
-	req, err := http.NewRequest("POST", baseURL+"/status", payload)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	if err != nil {
-		log.Fatalf("Failed to sent request to https://chipsakti-mock.herokuapp.com/status. Error: %v\n", err)
-	}
+// Return Topup Buy response in JSON
+func (b *chipsaktiBiller) TopupBuy(jsonIso TopupBuyRequest) (TopupBuyResponse, error) {
+	var response TopupBuyResponse
 
-	// Check response from Biller
-	resp, err := client.Do(req)
+	// Sign request with the secret shared with this Biller
+	signature, err := b.conn.sign("buy", jsonIso.PartnerID, jsonIso)
 	if err != nil {
-		log.Fatalf("Failed to get response from https://chipsakti-mock.herokuapp.com/status. Error: %v\n", err)
+		return response, err
 	}
+	jsonIso.Signature = signature
 
-	defer resp.Body.Close()
-
-	log.Printf("Receive response from https://chipsakti-mock.herokuapp.com/status\n")
-
-	// Read response from Biller
-	body, _ := ioutil.ReadAll(resp.Body)
-	json.Unmarshal(body, &response)
+	// Set data to be encoded
+	var param = url.Values{}
+	param.Set("transaction_id", jsonIso.TransactionID)
+	param.Set("partner_id", jsonIso.PartnerID)
+	param.Set("product_code", jsonIso.ProductCode)
+	param.Set("customer_no", jsonIso.CustomerNo)
+	param.Set("merchant_code", jsonIso.MerchantCode)
+	param.Set("request_time", jsonIso.RequestTime)
+	param.Set("signature", jsonIso.Signature)
 
-	return response
+	err = b.conn.postForm(b.conn.Endpoints["buy"], param, &response)
+	return response, err
 }
 
-// Return Topup Buy response in JSON
-func responseTopupBuy(jsonIso TopupBuyRequest) TopupBuyResponse {
-	var response TopupBuyResponse
+// Return Topup Check response in JSON
+func (b *chipsaktiBiller) TopupCheck(jsonIso TopupCheckRequest) (TopupCheckResponse, error) {
+	var response TopupCheckResponse
 
-	// Client setup for custom http request
-	client := &http.Client{}
-	var baseURL = "https://chipsakti-mock.herokuapp.com"
+	// Sign request with the secret shared with this Biller
+	signature, err := b.conn.sign("check", jsonIso.PartnerID, jsonIso)
+	if err != nil {
+		return response, err
+	}
+	jsonIso.Signature = signature
 
 	// Set data to be encoded
 	var param = url.Values{}
@@ -170,74 +338,106 @@ func responseTopupBuy(jsonIso TopupBuyRequest) TopupBuyResponse {
 	param.Set("request_time", jsonIso.RequestTime)
 	param.Set("signature", jsonIso.Signature)
 
-	log.Printf("Send request to https://chipsakti-mock.herokuapp.com/buy\n")
+	err = b.conn.postForm(b.conn.Endpoints["check"], param, &response)
+	return response, err
+}
 
-	// Request to Biller
-	var payload = bytes.NewBufferString(param.Encode())
-	req, err := http.NewRequest("POST", baseURL+"/buy", payload)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	if err != nil {
-		log.Fatalf("Failed to sent request to https://chipsakti-mock.herokuapp.com/buy. Error: %v\n", err)
-	}
+// Aggregator with the ChipSakti request and response fields sent as a JSON body
+type jsonBiller struct {
+	conn *BillerConnection
+}
 
-	// Check response from Biller
-	resp, err := client.Do(req)
+func (b *jsonBiller) Name() string {
+	return b.conn.Name
+}
+
+func (b *jsonBiller) Inquiry(request PPOBInquiryRequest) (PPOBInquiryResponse, error) {
+	var response PPOBInquiryResponse
+
+	signature, err := b.conn.sign("inquiry", request.PartnerID, request)
 	if err != nil {
-		log.Fatalf("Failed to get response from https://chipsakti-mock.herokuapp.com/buy. Error: %v\n", err)
+		return response, err
 	}
+	request.Signature = signature
 
-	defer resp.Body.Close()
+	err = b.conn.postJSON(b.conn.Endpoints["inquiry"], request, &response)
+	return response, err
+}
 
-	log.Printf("Receive response from https://chipsakti-mock.herokuapp.com/buy\n")
+func (b *jsonBiller) Payment(request PPOBPaymentRequest) (PPOBPaymentResponse, error) {
+	var response PPOBPaymentResponse
 
-	// Read response from Biller
-	body, _ := ioutil.ReadAll(resp.Body)
-	json.Unmarshal(body, &response)
+	signature, err := b.conn.sign("payment", request.PartnerID, request)
+	if err != nil {
+		return response, err
+	}
+	request.Signature = signature
 
-	return response
+	err = b.conn.postJSON(b.conn.Endpoints["payment"], request, &response)
+	return response, err
 }
 
-// Return Topup Check response in JSON
-func responseTopupCheck(jsonIso TopupCheckRequest) TopupCheckResponse {
-	var response TopupCheckResponse
+func (b *jsonBiller) Status(request PPOBStatusRequest) (PPOBStatusResponse, error) {
+	var response PPOBStatusResponse
 
-	// Client setup for custom http request
-	client := &http.Client{}
-	var baseURL = "https://chipsakti-mock.herokuapp.com"
+	signature, err := b.conn.sign("status", request.PartnerID, request)
+	if err != nil {
+		return response, err
+	}
+	request.Signature = signature
 
-	// Set data to be encoded
-	var param = url.Values{}
-	param.Set("transaction_id", jsonIso.TransactionID)
-	param.Set("partner_id", jsonIso.PartnerID)
-	param.Set("product_code", jsonIso.ProductCode)
-	param.Set("customer_no", jsonIso.CustomerNo)
-	param.Set("merchant_code", jsonIso.MerchantCode)
-	param.Set("request_time", jsonIso.RequestTime)
-	param.Set("signature", jsonIso.Signature)
+	err = b.conn.postJSON(b.conn.Endpoints["status"], request, &response)
+	return response, err
+}
 
-	log.Printf("Request to https://chipsakti-mock.herokuapp.com/check\n")
+func (b *jsonBiller) TopupBuy(request TopupBuyRequest) (TopupBuyResponse, error) {
+	var response TopupBuyResponse
 
-	// Request to Biller
-	var payload = bytes.NewBufferString(param.Encode())
-	req, err := http.NewRequest("POST", baseURL+"/check", payload)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signature, err := b.conn.sign("buy", request.PartnerID, request)
 	if err != nil {
-		log.Fatalf("Failed to sent request to https://chipsakti-mock.herokuapp.com/check. Error: %v\n", err)
+		return response, err
 	}
+	request.Signature = signature
 
-	// Check response from Biller
-	resp, err := client.Do(req)
+	err = b.conn.postJSON(b.conn.Endpoints["buy"], request, &response)
+	return response, err
+}
+
+func (b *jsonBiller) TopupCheck(request TopupCheckRequest) (TopupCheckResponse, error) {
+	var response TopupCheckResponse
+
+	signature, err := b.conn.sign("check", request.PartnerID, request)
 	if err != nil {
-		log.Fatalf("Failed to get response from https://chipsakti-mock.herokuapp.com/check. Error: %v\n", err)
+		return response, err
 	}
+	request.Signature = signature
 
-	defer resp.Body.Close()
+	err = b.conn.postJSON(b.conn.Endpoints["check"], request, &response)
+	return response, err
+}
 
-	log.Printf("Receive response from https://chipsakti-mock.herokuapp.com/check\n")
+// Return response in JSON for a route without built-in conversion, sent to the route's Biller
+func responseRoute(route Route, param url.Values) (map[string]interface{}, error) {
+	var response map[string]interface{}
 
-	// Read response from Biller
-	body, _ := ioutil.ReadAll(resp.Body)
-	json.Unmarshal(body, &response)
+	conn, ok := billerEnv.connection(route.Biller)
+	if !ok {
+		return nil, &BillerError{Kind: billerRequestFailed, Biller: route.Biller, Endpoint: route.Endpoint, Err: fmt.Errorf("biller is not defined")}
+	}
+
+	// Sign request with the route's template and the secret shared with this Biller
+	if route.Signature != "" {
+		values := make(map[string]string, len(param))
+		for name := range param {
+			values[name] = param.Get(name)
+		}
+		signature, err := conn.signTemplate(route.Signature, param.Get("partner_id"), values)
+		if err != nil {
+			return nil, &BillerError{Kind: billerRequestFailed, Biller: conn.Name, Endpoint: route.Endpoint, Err: err}
+		}
+		param.Set("signature", signature)
+	}
 
-	return response
+	err := conn.postForm(route.Endpoint, param, &response)
+	return response, err
 }
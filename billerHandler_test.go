@@ -0,0 +1,216 @@
+package kafkabiller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Return signature settings with the mock secret
+func testSignature() SignatureScheme {
+	return SignatureScheme{Secrets: []*SignatureSecret{{ID: "mock", Env: "CHIPSAKTI_MOCK_SECRET"}}}
+}
+
+// Return Biller of a type for a test server
+func testBiller(t *testing.T, name string, billerType string, baseURL string, readTimeout time.Duration) Biller {
+	conn := &BillerConnection{Name: name, Type: billerType, BaseURL: baseURL, ConnectTimeout: time.Second, ReadTimeout: readTimeout,
+		Signature: testSignature()}
+	if err := conn.validate("test"); err != nil {
+		t.Fatalf("BillerConnection.validate() failed. Error: %v", err)
+	}
+	if err := conn.connect(); err != nil {
+		t.Fatalf("BillerConnection.connect() failed. Error: %v", err)
+	}
+	return billerTypes[billerType](conn)
+}
+
+func TestBillerResponseCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		handler http.HandlerFunc
+		rc      string
+	}{
+		{"status", time.Second, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "91"},
+		{"invalid", time.Second, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "<html>maintenance</html>")
+		}, "96"},
+		{"missing_rc", time.Second, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"msg":"SUKSES"}`)
+		}, "96"},
+		{"timeout", 50 * time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			fmt.Fprint(w, `{"rc":"00"}`)
+		}, "68"},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(test.handler)
+		biller := testBiller(t, "chipsakti", "chipsakti", server.URL, test.timeout)
+
+		_, err := biller.TopupCheck(TopupCheckRequest{TransactionID: "1"})
+		if rc := billerResponseCode(err); err == nil || rc != test.rc {
+			t.Errorf("%v: billerResponseCode() failed. Expected: %v. Got: %v (%v)", test.name, test.rc, rc, err)
+		} else {
+			t.Logf("%v: billerResponseCode() success", test.name)
+		}
+		server.Close()
+	}
+
+	// Biller that can't be reached
+	biller := testBiller(t, "chipsakti", "chipsakti", "http://127.0.0.1:1", time.Second)
+	_, err := biller.TopupCheck(TopupCheckRequest{TransactionID: "1"})
+	if rc := billerResponseCode(err); rc != "91" {
+		t.Errorf("connect: billerResponseCode() failed. Expected: 91. Got: %v (%v)", rc, err)
+	} else {
+		t.Log("connect: billerResponseCode() success")
+	}
+}
+
+func TestBillerTypes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		customerNo := r.FormValue("customer_no")
+		if r.Header.Get("Content-Type") == "application/json" {
+			var request TopupBuyRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			customerNo = request.CustomerNo
+		}
+		if r.URL.Path != "/buy" || customerNo != "081234567890" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"rc":"00","msg":"SUKSES","sn":"123","price":"5000"}`)
+	}))
+	defer server.Close()
+
+	for billerType := range billerTypes {
+		biller := testBiller(t, billerType, billerType, server.URL, time.Second)
+		response, err := biller.TopupBuy(TopupBuyRequest{CustomerNo: "081234567890"})
+		if err != nil || response.Rc != "00" || response.Price != 5000 {
+			t.Errorf("%v: Biller.TopupBuy() failed. Expected: rc 00, price 5000. Got: %+v (%v)", billerType, response, err)
+		} else {
+			t.Logf("%v: Biller.TopupBuy() success", billerType)
+		}
+	}
+}
+
+func TestBillerEnvironmentRouting(t *testing.T) {
+	env := &BillerEnvironment{
+		Name:     "test",
+		Default:  "chipsakti",
+		Partners: map[string]string{"P2": "supplierb"},
+		Products: map[string]string{"PLNPRA": "supplierb"},
+		Billers: map[string]*BillerConnection{
+			"chipsakti": {BaseURL: "http://127.0.0.1:1", ConnectTimeout: time.Second, ReadTimeout: time.Second, Signature: testSignature()},
+			"supplierb": {Type: "json", BaseURL: "http://127.0.0.1:2", ConnectTimeout: time.Second, ReadTimeout: time.Second, Signature: testSignature()},
+			"supplierc": {BaseURL: "http://127.0.0.1:3", ConnectTimeout: time.Second, ReadTimeout: time.Second, Signature: testSignature()},
+		},
+	}
+	if err := env.validate(); err != nil {
+		t.Fatalf("BillerEnvironment.validate() failed. Error: %v", err)
+	}
+	if err := env.connect(); err != nil {
+		t.Fatalf("BillerEnvironment.connect() failed. Error: %v", err)
+	}
+
+	tests := []struct {
+		route, product, partner string
+		expected                string
+	}{
+		{"", "TSEL10", "P1", "chipsakti"},
+		{"", "PLNPRA", "P1", "supplierb"},
+		{"", "TSEL10", "P2", "supplierb"},
+		{"supplierc", "TSEL10", "P1", "supplierc"},
+		{"supplierc", "PLNPRA", "P1", "supplierb"},
+	}
+	for _, test := range tests {
+		if result := env.biller(test.route, test.product, test.partner).Name(); result != test.expected {
+			t.Errorf("BillerEnvironment.biller(%q, %q, %q) failed. Expected: %v. Got: %v", test.route, test.product, test.partner, test.expected, result)
+		}
+	}
+	t.Log("BillerEnvironment.biller() success")
+
+	env.Products["PLNPASCA"] = "unknown"
+	if err := env.validate(); err == nil {
+		t.Errorf("BillerEnvironment.validate() failed. Expected: error for unknown Biller. Got: nil")
+	}
+}
+
+func TestBillerEnvironmentFromFile(t *testing.T) {
+	os.Setenv("CHIPSAKTI_SANDBOX_SECRET", "sandbox")
+	os.Setenv("CHIPSAKTI_PRODUCTION_SECRET", "production")
+	defer os.Unsetenv("CHIPSAKTI_SANDBOX_SECRET")
+	defer os.Unsetenv("CHIPSAKTI_PRODUCTION_SECRET")
+
+	for _, name := range []string{"", "mock", "local", "sandbox", "production"} {
+		env, err := billerEnvironmentFromFile("biller.yml", name)
+		if err != nil {
+			t.Errorf("billerEnvironmentFromFile(%q) failed. Error: %v", name, err)
+			continue
+		}
+		conn, _ := env.connection("")
+		if conn.Endpoints["inquiry"] != "/inquiry" || conn.client == nil {
+			t.Errorf("billerEnvironmentFromFile(%q) failed. Expected: /inquiry and a client. Got: %v %v", name, conn.Endpoints["inquiry"], conn.client)
+		}
+	}
+
+	if _, err := billerEnvironmentFromFile("biller.yml", "staging"); err == nil {
+		t.Errorf("billerEnvironmentFromFile() failed. Expected: error for unknown environment. Got: nil")
+	}
+
+	dir, _ := ioutil.TempDir("", "biller")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "biller.yml")
+	ioutil.WriteFile(filename, []byte(`Environment: production
+Environments:
+  production:
+    Default: chipsakti
+    Billers:
+      chipsakti:
+        BaseURL: https://api.biller.invalid
+        ConnectTimeout: 3s
+        ReadTimeout: 25s
+        Signature:
+          Secrets:
+            - ID: production
+              Env: CHIPSAKTI_PRODUCTION_SECRET
+        TLS:
+          InsecureSkipVerify: true
+`), 0644)
+	if _, err := billerEnvironmentFromFile(filename, ""); err == nil {
+		t.Errorf("billerEnvironmentFromFile() failed. Expected: error for skipped TLS verification in production. Got: nil")
+	} else {
+		t.Log("billerEnvironmentFromFile() success")
+	}
+}
+
+func TestBillerDialTimeout(t *testing.T) {
+	// TEST-NET-1 isn't routed, the dial times out or fails before the request is sent
+	conn := &BillerConnection{Name: "test", Type: "chipsakti", BaseURL: "http://192.0.2.1:6030", ConnectTimeout: 100 * time.Millisecond,
+		ReadTimeout: time.Second, Signature: testSignature()}
+	if err := conn.validate("test"); err != nil {
+		t.Fatalf("BillerConnection.validate() failed. Error: %v", err)
+	}
+	conn.connect()
+
+	_, err := (&chipsaktiBiller{conn: conn}).TopupBuy(TopupBuyRequest{TransactionID: "1", CustomerNo: "081234567890"})
+	if !billerNotSent(err) || suspectPolicy.reason(err, "") != "" || billerResponseCode(err) != "91" {
+		t.Errorf("chipsaktiBiller.TopupBuy() failed. Expected: not sent, not suspect, rc 91. Got: %v", err)
+	}
+
+	timeout := &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{IsTimeout: true}}
+	if kind := billerErrorKind(timeout); kind != billerConnectFailed {
+		t.Errorf("billerErrorKind() failed. Expected: %v for dial timeout. Got: %v", billerConnectFailed, kind)
+	} else {
+		t.Log("Biller dial timeout success")
+	}
+}
@@ -0,0 +1,307 @@
+package kafkabiller
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of a circuit breaker
+const (
+	breakerClosed   = "closed"    // calls go to the Biller
+	breakerOpen     = "open"      // calls fail fast until OpenFor has passed
+	breakerHalfOpen = "half-open" // a few probe calls decide whether to close again
+)
+
+// Circuit breaker and concurrency cap settings in biller.yml, a zero Window turns the breaker off
+type BreakerConfig struct {
+	Window        int            `yaml:"Window"`
+	MinCalls      int            `yaml:"MinCalls"`
+	ErrorRate     int            `yaml:"ErrorRate"`
+	SlowCall      time.Duration  `yaml:"SlowCall"`
+	SlowRate      int            `yaml:"SlowRate"`
+	OpenFor       time.Duration  `yaml:"OpenFor"`
+	HalfOpenCalls int            `yaml:"HalfOpenCalls"`
+	MaxConcurrent map[string]int `yaml:"MaxConcurrent"`
+}
+
+// Current state of an endpoint's circuit breaker, returned by GET /breakers
+type BreakerStatus struct {
+	Biller        string     `json:"biller"`
+	Endpoint      string     `json:"endpoint"`
+	State         string     `json:"state"`
+	Calls         int        `json:"calls"`
+	Failures      int        `json:"failures"`
+	Slow          int        `json:"slow"`
+	InFlight      int        `json:"in_flight"`
+	MaxConcurrent int        `json:"max_concurrent,omitempty"`
+	OpenedAt      *time.Time `json:"opened_at,omitempty"`
+}
+
+// Outcome of a finished call in the breaker window
+type callOutcome struct {
+	failed bool
+	slow   bool
+}
+
+// circuitBreaker guards a single Biller endpoint, safe for concurrent use
+type circuitBreaker struct {
+	biller   string
+	endpoint string
+	config   BreakerConfig
+
+	// Free slots of the concurrency cap, nil when there is no cap
+	slots chan struct{}
+
+	mu       sync.Mutex
+	state    string
+	window   []callOutcome
+	next     int
+	openedAt time.Time
+	probes   int
+	passed   int
+	inFlight int
+}
+
+// breakerSet holds a circuit breaker per endpoint of a Biller, safe for concurrent use
+type breakerSet struct {
+	biller string
+	config BreakerConfig
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// Check that the breaker settings can be used
+func (c BreakerConfig) validate() error {
+	for endpoint, limit := range c.MaxConcurrent {
+		if endpoint != "default" && !strings.HasPrefix(endpoint, "/") {
+			return fmt.Errorf("max concurrent %s: must be default or an endpoint path", endpoint)
+		}
+		if limit <= 0 {
+			return fmt.Errorf("max concurrent %s: must be positive", endpoint)
+		}
+	}
+
+	if c.Window == 0 {
+		return nil
+	}
+	if c.Window < 0 || c.MinCalls <= 0 || c.MinCalls > c.Window {
+		return fmt.Errorf("breaker needs 0 < MinCalls <= Window")
+	}
+	if c.ErrorRate <= 0 || c.ErrorRate > 100 || c.SlowRate < 0 || c.SlowRate > 100 {
+		return fmt.Errorf("breaker rates are percentages")
+	}
+	if c.SlowRate > 0 && c.SlowCall <= 0 {
+		return fmt.Errorf("breaker SlowRate needs SlowCall")
+	}
+	if c.OpenFor <= 0 || c.HalfOpenCalls <= 0 {
+		return fmt.Errorf("breaker needs OpenFor and HalfOpenCalls")
+	}
+	return nil
+}
+
+// Return new set of circuit breakers of a Biller sharing the same settings
+func newBreakerSet(biller string, config BreakerConfig) *breakerSet {
+	return &breakerSet{biller: biller, config: config, breakers: make(map[string]*circuitBreaker)}
+}
+
+// Return circuit breaker of an endpoint, created on its first call
+func (s *breakerSet) get(endpoint string) *circuitBreaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if breaker, ok := s.breakers[endpoint]; ok {
+		return breaker
+	}
+	breaker := &circuitBreaker{biller: s.biller, endpoint: endpoint, config: s.config, state: breakerClosed}
+	limit, ok := s.config.MaxConcurrent[endpoint]
+	if !ok {
+		limit = s.config.MaxConcurrent["default"]
+	}
+	if limit > 0 {
+		breaker.slots = make(chan struct{}, limit)
+	}
+	s.breakers[endpoint] = breaker
+	return breaker
+}
+
+// status returns current state of every endpoint that has been called, sorted by endpoint
+func (s *breakerSet) status() []BreakerStatus {
+	s.mu.Lock()
+	breakers := make([]*circuitBreaker, 0, len(s.breakers))
+	for _, breaker := range s.breakers {
+		breakers = append(breakers, breaker)
+	}
+	s.mu.Unlock()
+
+	result := make([]BreakerStatus, 0, len(breakers))
+	for _, breaker := range breakers {
+		result = append(result, breaker.status())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Endpoint < result[j].Endpoint
+	})
+	return result
+}
+
+// acquire reserves a call to the endpoint, done must be called with the outcome once the call is finished.
+// A call is refused with a BillerError when the breaker is open or the concurrency cap is reached.
+func (b *circuitBreaker) acquire() (done func(failed bool, elapsed time.Duration), err error) {
+	if b.slots != nil {
+		select {
+		case b.slots <- struct{}{}:
+		default:
+			metrics.inc("biller_rejected", "biller", b.biller, "endpoint", b.endpoint, "reason", "bulkhead")
+			return nil, &BillerError{Kind: billerBulkheadFull, Biller: b.biller, Endpoint: b.endpoint, Err: fmt.Errorf("%d calls in flight", cap(b.slots))}
+		}
+	}
+
+	probe, err := b.allow()
+	if err != nil {
+		if b.slots != nil {
+			<-b.slots
+		}
+		metrics.inc("biller_rejected", "biller", b.biller, "endpoint", b.endpoint, "reason", "open")
+		return nil, err
+	}
+
+	return func(failed bool, elapsed time.Duration) {
+		b.record(probe, failed, elapsed)
+		if b.slots != nil {
+			<-b.slots
+		}
+	}, nil
+}
+
+// allow checks the breaker state, probe is true for a half-open probe call
+func (b *circuitBreaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.config.OpenFor {
+		b.setState(breakerHalfOpen)
+	}
+	switch {
+	case b.state == breakerOpen:
+		return false, &BillerError{Kind: billerCircuitOpen, Biller: b.biller, Endpoint: b.endpoint, Err: fmt.Errorf("circuit open since %s", b.openedAt.Format("15:04:05"))}
+	case b.state == breakerHalfOpen && b.probes >= b.config.HalfOpenCalls:
+		return false, &BillerError{Kind: billerCircuitOpen, Biller: b.biller, Endpoint: b.endpoint, Err: fmt.Errorf("circuit half-open, probes in flight")}
+	case b.state == breakerHalfOpen:
+		b.probes++
+		probe = true
+	}
+	b.inFlight++
+	return probe, nil
+}
+
+// record adds outcome of a finished call and opens or closes the breaker
+func (b *circuitBreaker) record(probe bool, failed bool, elapsed time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inFlight--
+	if b.config.Window == 0 {
+		return
+	}
+	outcome := callOutcome{failed: failed, slow: b.config.SlowRate > 0 && elapsed > b.config.SlowCall}
+
+	// Probes decide on their own, one bad probe opens the breaker again
+	if probe {
+		if b.probes > 0 {
+			b.probes--
+		}
+		if b.state != breakerHalfOpen {
+			return
+		}
+		if outcome.failed || outcome.slow {
+			b.setState(breakerOpen)
+			return
+		}
+		b.passed++
+		if b.passed >= b.config.HalfOpenCalls {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+
+	// Calls started before the breaker opened don't count
+	if b.state != breakerClosed {
+		return
+	}
+	if len(b.window) < b.config.Window {
+		b.window = append(b.window, outcome)
+	} else {
+		b.window[b.next] = outcome
+		b.next = (b.next + 1) % b.config.Window
+	}
+	if len(b.window) < b.config.MinCalls {
+		return
+	}
+
+	failures, slow := b.counts()
+	if failures*100 >= b.config.ErrorRate*len(b.window) ||
+		(b.config.SlowRate > 0 && slow*100 >= b.config.SlowRate*len(b.window)) {
+		b.setState(breakerOpen)
+	}
+}
+
+// Change breaker state, must be called with the lock held
+func (b *circuitBreaker) setState(state string) {
+	log.Printf("Circuit breaker %s %s: %s -> %s\n", b.biller, b.endpoint, b.state, state)
+	metrics.inc("breaker_state_change", "biller", b.biller, "endpoint", b.endpoint, "state", state)
+
+	b.state = state
+	b.probes, b.passed = 0, 0
+	switch state {
+	case breakerOpen:
+		b.openedAt = time.Now()
+	case breakerClosed:
+		b.window, b.next = nil, 0
+	}
+}
+
+// Return failed and slow calls in the window, must be called with the lock held
+func (b *circuitBreaker) counts() (failures int, slow int) {
+	for _, outcome := range b.window {
+		if outcome.failed {
+			failures++
+		}
+		if outcome.slow {
+			slow++
+		}
+	}
+	return failures, slow
+}
+
+// status returns current state of the breaker
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failures, slow := b.counts()
+	status := BreakerStatus{
+		Biller:        b.biller,
+		Endpoint:      b.endpoint,
+		State:         b.state,
+		Calls:         len(b.window),
+		Failures:      failures,
+		Slow:          slow,
+		InFlight:      b.inFlight,
+		MaxConcurrent: cap(b.slots),
+	}
+	if b.state != breakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// Return circuit breaker state of every Biller endpoint
+func getBreakers(w http.ResponseWriter, r *http.Request) {
+	jsonFormatter(w, billerEnv.breakerStatus(), http.StatusOK)
+}
@@ -0,0 +1,97 @@
+package kafkabiller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breakers := newBreakerSet("test", BreakerConfig{Window: 4, MinCalls: 4, ErrorRate: 50, OpenFor: 20 * time.Millisecond, HalfOpenCalls: 1})
+	inquiry := breakers.get("/inquiry")
+
+	// Two failures out of four calls open the breaker
+	for _, failed := range []bool{false, true, false, true} {
+		done, err := inquiry.acquire()
+		if err != nil {
+			t.Fatalf("circuitBreaker.acquire() failed. Error: %v", err)
+		}
+		done(failed, time.Millisecond)
+	}
+	if _, err := inquiry.acquire(); billerResponseCode(err) != "91" {
+		t.Errorf("circuitBreaker.acquire() failed. Expected: open breaker refuses with RC 91. Got: %v", err)
+	}
+
+	// Other endpoints keep working
+	if done, err := breakers.get("/payment").acquire(); err != nil {
+		t.Errorf("circuitBreaker.acquire() failed. Expected: /payment is not affected. Got: %v", err)
+	} else {
+		done(false, time.Millisecond)
+	}
+
+	// A successful probe after OpenFor closes the breaker
+	time.Sleep(25 * time.Millisecond)
+	done, err := inquiry.acquire()
+	if err != nil {
+		t.Fatalf("circuitBreaker.acquire() failed. Expected: probe call. Got: %v", err)
+	}
+	if _, err := inquiry.acquire(); err == nil {
+		t.Errorf("circuitBreaker.acquire() failed. Expected: only one probe while half-open. Got: nil")
+	}
+	done(false, time.Millisecond)
+
+	status := breakers.status()
+	if len(status) != 2 || status[0].Endpoint != "/inquiry" || status[0].State != breakerClosed {
+		t.Errorf("breakerSet.status() failed. Expected: /inquiry closed. Got: %+v", status)
+	} else {
+		t.Log("circuitBreaker success")
+	}
+}
+
+func TestCircuitBreakerSlowProbe(t *testing.T) {
+	breaker := newBreakerSet("test", BreakerConfig{Window: 2, MinCalls: 2, ErrorRate: 100, SlowCall: 10 * time.Millisecond, SlowRate: 50,
+		OpenFor: time.Millisecond, HalfOpenCalls: 1}).get("/status")
+
+	// One slow call out of two opens the breaker
+	for _, elapsed := range []time.Duration{time.Millisecond, 50 * time.Millisecond} {
+		done, _ := breaker.acquire()
+		done(false, elapsed)
+	}
+	if breaker.status().State != breakerOpen {
+		t.Fatalf("circuitBreaker.record() failed. Expected: %v. Got: %v", breakerOpen, breaker.status().State)
+	}
+
+	// A slow probe opens it again
+	time.Sleep(2 * time.Millisecond)
+	done, err := breaker.acquire()
+	if err != nil {
+		t.Fatalf("circuitBreaker.acquire() failed. Expected: probe call. Got: %v", err)
+	}
+	done(false, 50*time.Millisecond)
+	if breaker.status().State != breakerOpen {
+		t.Errorf("circuitBreaker.record() failed. Expected: %v after slow probe. Got: %v", breakerOpen, breaker.status().State)
+	} else {
+		t.Log("circuitBreaker slow call success")
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	breakers := newBreakerSet("test", BreakerConfig{MaxConcurrent: map[string]int{"default": 2, "/inquiry": 1}})
+
+	done, err := breakers.get("/inquiry").acquire()
+	if err != nil {
+		t.Fatalf("circuitBreaker.acquire() failed. Error: %v", err)
+	}
+	if _, err := breakers.get("/inquiry").acquire(); billerResponseCode(err) != "91" {
+		t.Errorf("circuitBreaker.acquire() failed. Expected: full endpoint refuses with RC 91. Got: %v", err)
+	}
+	if _, err := breakers.get("/payment").acquire(); err != nil {
+		t.Errorf("circuitBreaker.acquire() failed. Expected: /payment has its own cap. Got: %v", err)
+	}
+
+	done(true, time.Millisecond)
+	if _, err := breakers.get("/inquiry").acquire(); err != nil {
+		t.Errorf("circuitBreaker.acquire() failed. Expected: slot is free again. Got: %v", err)
+	} else {
+		t.Log("bulkhead success")
+	}
+}
@@ -0,0 +1,9 @@
+// Command ChipSakti-KafkaBiller runs the Kafka to Biller service, run it from the service
+// directory so it finds its configs
+package main
+
+import kafkabiller "github.com/j03hanafi/ChipSakti-KafkaBiller"
+
+func main() {
+	kafkabiller.Run()
+}
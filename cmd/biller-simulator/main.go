@@ -0,0 +1,17 @@
+// Command biller-simulator runs a local Biller playing the scenarios of simulator.yml, run it
+// from the service directory so it finds the scenario file
+package main
+
+import (
+	"fmt"
+	"os"
+
+	kafkabiller "github.com/j03hanafi/ChipSakti-KafkaBiller"
+)
+
+func main() {
+	if err := kafkabiller.RunSimulator(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,17 @@
+// Command iso-tool decodes, encodes, converts and lints ISO8583 messages and specs, run it
+// from the service directory so it finds the specs
+package main
+
+import (
+	"fmt"
+	"os"
+
+	kafkabiller "github.com/j03hanafi/ChipSakti-KafkaBiller"
+)
+
+func main() {
+	if err := kafkabiller.RunTool(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
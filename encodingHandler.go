@@ -0,0 +1,379 @@
+package kafkabiller
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mofax/iso8583"
+)
+
+// Struct for channel encoding in kafkaConfig.json. Empty values keep the ASCII/hex
+// representation the iso8583 library works with
+type Encoding struct {
+	Binary  string `json:"binary"`  // "hex" or "raw": bitmap and b fields as hex characters or raw bytes
+	Numeric string `json:"numeric"` // "ascii" or "bcd": MTI, n fields and length prefixes as characters or packed BCD
+	Charset string `json:"charset"` // "ascii" or "ebcdic": character data
+}
+
+// Check that every encoding option is supported
+func (e Encoding) validate() error {
+	if e.Binary != "" && e.Binary != "hex" && e.Binary != "raw" {
+		return fmt.Errorf("invalid binary encoding %q", e.Binary)
+	}
+	if e.Numeric != "" && e.Numeric != "ascii" && e.Numeric != "bcd" {
+		return fmt.Errorf("invalid numeric encoding %q", e.Numeric)
+	}
+	if e.Charset != "" && e.Charset != "ascii" && e.Charset != "ebcdic" {
+		return fmt.Errorf("invalid charset %q", e.Charset)
+	}
+	return nil
+}
+
+// Return true if messages are already in the representation of the iso8583 library
+func (e Encoding) isLibrary() bool {
+	return (e.Binary == "" || e.Binary == "hex") && (e.Numeric == "" || e.Numeric == "ascii") &&
+		(e.Charset == "" || e.Charset == "ascii")
+}
+
+// decode converts a message from the channel encoding to the ASCII/hex message parsed by spec
+func (e Encoding) decode(spec *Spec, wire string) (message string, err error) {
+	if e.isLibrary() {
+		return wire, nil
+	}
+
+	r := &wireReader{data: wire}
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("malformed %s message: %v", e, rec)
+		}
+	}()
+
+	// MTI
+	mti, err := e.readNumber(r, 4)
+	if err != nil {
+		return "", fmt.Errorf("MTI: %v", err)
+	}
+
+	// Bitmap, secondary bitmap is present if the first bit is set
+	bitmapHex, err := e.readBinary(r, 16)
+	if err != nil {
+		return "", fmt.Errorf("bitmap: %v", err)
+	}
+	if first, _ := strconv.ParseUint(bitmapHex[:1], 16, 8); first&0x8 != 0 {
+		secondary, err := e.readBinary(r, 16)
+		if err != nil {
+			return "", fmt.Errorf("secondary bitmap: %v", err)
+		}
+		bitmapHex += secondary
+	}
+	bitmap, err := iso8583.HexToBitmapArray(bitmapHex)
+	if err != nil {
+		return "", fmt.Errorf("bitmap: %v", err)
+	}
+
+	var b strings.Builder
+	b.WriteString(mti + bitmapHex)
+
+	// Fields in bitmap order, the first bit only flags the secondary bitmap
+	for index := 1; index < len(bitmap); index++ {
+		if bitmap[index] != 1 {
+			continue
+		}
+		field := index + 1
+		description, ok := spec.fields[field]
+		if !ok {
+			return "", fmt.Errorf("field %d: not defined in spec", field)
+		}
+
+		length := description.MaxLen
+		prefix := prefixDigits(description.LenType)
+		if prefix > 0 {
+			declared, err := e.readNumber(r, prefix)
+			if err != nil {
+				return "", fmt.Errorf("field %d length: %v", field, err)
+			}
+			length, _ = strconv.Atoi(declared)
+			if description.ContentType == "b" && e.Binary == "raw" {
+				// Raw binary length counts bytes, message keeps hex characters
+				length *= 2
+			}
+		}
+
+		value, err := e.readField(r, description.ContentType, length)
+		if err != nil {
+			return "", fmt.Errorf("field %d: %v", field, err)
+		}
+		if prefix > 0 {
+			b.WriteString(leftPad(strconv.Itoa(len(value)), prefix, "0"))
+		}
+		b.WriteString(value)
+	}
+
+	if r.pos != len(r.data) {
+		return "", fmt.Errorf("%d unexpected bytes after last field", len(r.data)-r.pos)
+	}
+	return b.String(), nil
+}
+
+// encode converts a message to the channel encoding
+func (e Encoding) encode(spec *Spec, iso iso8583.IsoStruct) (string, error) {
+	if e.isLibrary() {
+		return iso.ToString()
+	}
+
+	var b strings.Builder
+	mti, err := e.writeNumber(iso.Mti.String(), 4)
+	if err != nil {
+		return "", fmt.Errorf("MTI: %v", err)
+	}
+	b.WriteString(mti)
+
+	bitmapHex, err := iso8583.BitMapArrayToHex(iso.Bitmap)
+	if err != nil {
+		return "", err
+	}
+	bitmap, err := e.writeBinary(bitmapHex)
+	if err != nil {
+		return "", fmt.Errorf("bitmap: %v", err)
+	}
+	b.WriteString(bitmap)
+
+	elements := iso.Elements.GetElements()
+	for index := 1; index < len(iso.Bitmap); index++ {
+		if iso.Bitmap[index] != 1 {
+			continue
+		}
+		field := index + 1
+		description := spec.fields[field]
+		value := elements[int64(field)]
+
+		if prefix := prefixDigits(description.LenType); prefix > 0 {
+			length := len(value)
+			if description.ContentType == "b" && e.Binary == "raw" {
+				length /= 2
+			}
+			declared, err := e.writeNumber(leftPad(strconv.Itoa(length), prefix, "0"), prefix)
+			if err != nil {
+				return "", fmt.Errorf("field %d length: %v", field, err)
+			}
+			b.WriteString(declared)
+		}
+
+		encoded, err := e.writeField(description.ContentType, value)
+		if err != nil {
+			return "", fmt.Errorf("field %d: %v", field, err)
+		}
+		b.WriteString(encoded)
+	}
+
+	return b.String(), nil
+}
+
+func (e Encoding) String() string {
+	return fmt.Sprintf("binary=%s numeric=%s charset=%s", e.Binary, e.Numeric, e.Charset)
+}
+
+// Return number of length digits for a LenType, 0 for fixed fields
+func prefixDigits(lenType string) int {
+	switch lenType {
+	case "llvar":
+		return 2
+	case "lllvar":
+		return 3
+	case "llllvar":
+		return 4
+	}
+	return 0
+}
+
+// wireReader reads a message from the start
+type wireReader struct {
+	data string
+	pos  int
+}
+
+// next returns the next n bytes
+func (r *wireReader) next(n int) (string, error) {
+	if r.pos+n > len(r.data) {
+		return "", fmt.Errorf("expected %d bytes at offset %d, found %d", n, r.pos, len(r.data)-r.pos)
+	}
+	value := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return value, nil
+}
+
+// Read a number of digits as BCD or characters
+func (e Encoding) readNumber(r *wireReader, digits int) (string, error) {
+	if e.Numeric == "bcd" {
+		packed, err := r.next((digits + 1) / 2)
+		if err != nil {
+			return "", err
+		}
+		return unpackBCD(packed, digits)
+	}
+	return e.readText(r, digits)
+}
+
+// Read hex characters as raw bytes or characters
+func (e Encoding) readBinary(r *wireReader, hexLength int) (string, error) {
+	if e.Binary == "raw" {
+		raw, err := r.next(hexLength / 2)
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString([]byte(raw)), nil
+	}
+	return e.readText(r, hexLength)
+}
+
+// Read characters in the channel charset
+func (e Encoding) readText(r *wireReader, length int) (string, error) {
+	text, err := r.next(length)
+	if err != nil {
+		return "", err
+	}
+	if e.Charset == "ebcdic" {
+		return ebcdicToASCII(text)
+	}
+	return text, nil
+}
+
+// Read a field value of a content type
+func (e Encoding) readField(r *wireReader, contentType string, length int) (string, error) {
+	switch contentType {
+	case "n":
+		return e.readNumber(r, length)
+	case "b":
+		return e.readBinary(r, length)
+	default:
+		return e.readText(r, length)
+	}
+}
+
+// Write a number as BCD or characters
+func (e Encoding) writeNumber(value string, digits int) (string, error) {
+	if e.Numeric == "bcd" {
+		return packBCD(value, digits)
+	}
+	return e.writeText(value)
+}
+
+// Write hex characters as raw bytes or characters
+func (e Encoding) writeBinary(value string) (string, error) {
+	if e.Binary == "raw" {
+		raw, err := hex.DecodeString(value)
+		if err != nil {
+			return "", err
+		}
+		return string(raw), nil
+	}
+	return e.writeText(value)
+}
+
+// Write characters in the channel charset
+func (e Encoding) writeText(value string) (string, error) {
+	if e.Charset == "ebcdic" {
+		return asciiToEBCDIC(value)
+	}
+	return value, nil
+}
+
+// Write a field value of a content type
+func (e Encoding) writeField(contentType string, value string) (string, error) {
+	switch contentType {
+	case "n":
+		return e.writeNumber(value, len(value))
+	case "b":
+		return e.writeBinary(value)
+	default:
+		return e.writeText(value)
+	}
+}
+
+// Return digits packed two per byte, an odd number of digits is padded with a leading 0
+func packBCD(digits string, length int) (string, error) {
+	if len(digits) != length {
+		return "", fmt.Errorf("expected %d digits, found %d", length, len(digits))
+	}
+	if length%2 == 1 {
+		digits = "0" + digits
+	}
+	for _, r := range digits {
+		if !isDigit(r) {
+			return "", fmt.Errorf("%q is not numeric", digits)
+		}
+	}
+	packed, _ := hex.DecodeString(digits)
+	return string(packed), nil
+}
+
+// Return digits of packed BCD, without the leading pad of an odd number of digits
+func unpackBCD(packed string, length int) (string, error) {
+	digits := hex.EncodeToString([]byte(packed))
+	for _, r := range digits {
+		if !isDigit(r) {
+			return "", fmt.Errorf("invalid BCD % x", packed)
+		}
+	}
+	return digits[len(digits)-length:], nil
+}
+
+// EBCDIC (code page 037) for printable ASCII characters
+var asciiEBCDIC = map[byte]byte{
+	' ': 0x40, '.': 0x4B, '<': 0x4C, '(': 0x4D, '+': 0x4E, '|': 0x4F, '&': 0x50, '!': 0x5A,
+	'$': 0x5B, '*': 0x5C, ')': 0x5D, ';': 0x5E, '-': 0x60, '/': 0x61, ',': 0x6B, '%': 0x6C,
+	'_': 0x6D, '>': 0x6E, '?': 0x6F, '`': 0x79, ':': 0x7A, '#': 0x7B, '@': 0x7C, '\'': 0x7D,
+	'=': 0x7E, '"': 0x7F, '~': 0xA1, '^': 0xB0, '[': 0xBA, ']': 0xBB, '{': 0xC0, '}': 0xD0,
+	'\\': 0xE0,
+}
+
+// EBCDIC to ASCII, built from asciiEBCDIC
+var ebcdicASCII = make(map[byte]byte)
+
+func init() {
+	// Letters and digits are in contiguous runs
+	runs := []struct {
+		from, to byte
+		start    byte
+	}{
+		{'a', 'i', 0x81}, {'j', 'r', 0x91}, {'s', 'z', 0xA2},
+		{'A', 'I', 0xC1}, {'J', 'R', 0xD1}, {'S', 'Z', 0xE2},
+		{'0', '9', 0xF0},
+	}
+	for _, run := range runs {
+		for c := run.from; c <= run.to; c++ {
+			asciiEBCDIC[c] = run.start + (c - run.from)
+		}
+	}
+	for a, e := range asciiEBCDIC {
+		ebcdicASCII[e] = a
+	}
+}
+
+// Return ASCII text of EBCDIC text
+func ebcdicToASCII(text string) (string, error) {
+	result := make([]byte, len(text))
+	for i := 0; i < len(text); i++ {
+		c, ok := ebcdicASCII[text[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported EBCDIC byte %02x", text[i])
+		}
+		result[i] = c
+	}
+	return string(result), nil
+}
+
+// Return EBCDIC text of ASCII text
+func asciiToEBCDIC(text string) (string, error) {
+	result := make([]byte, len(text))
+	for i := 0; i < len(text); i++ {
+		c, ok := asciiEBCDIC[text[i]]
+		if !ok {
+			return "", fmt.Errorf("character %q has no EBCDIC equivalent", text[i])
+		}
+		result[i] = c
+	}
+	return string(result), nil
+}
@@ -0,0 +1,70 @@
+package kafkabiller
+
+import "testing"
+
+func TestEncodingRoundTrip(t *testing.T) {
+	encoding := Encoding{Binary: "raw", Numeric: "bcd", Charset: "ebcdic"}
+	iso := getIso(map[int]string{3: "380001", 4: "1500", 43: "HANAFI", 48: "2021 USER01"}, "0200")
+
+	wire, err := encoding.encode(isoSpec, iso)
+	if err != nil {
+		t.Errorf("encode() failed. Error: %v", err)
+	}
+
+	// MTI is 2 BCD bytes, bitmap is 16 raw bytes, processing code is 3 BCD bytes
+	if wire[:2] != "\x02\x00" || wire[18:21] != "\x38\x00\x01" {
+		t.Errorf("encode() failed. Got: % x", wire)
+	}
+
+	decoded, err := encoding.decode(isoSpec, wire)
+	if err != nil {
+		t.Errorf("decode() failed. Error: %v", err)
+	}
+	expected, _ := iso.ToString()
+	if decoded != expected {
+		t.Errorf("decode() failed, \nexpected\t: %v, \ngot\t\t\t: %v", expected, decoded)
+	} else {
+		t.Log("Encoding round trip success")
+	}
+}
+
+func TestEncodingLibrary(t *testing.T) {
+	message := "0200a00000000001000000000000000000003800011302021"
+	if decoded, err := (Encoding{}).decode(isoSpec, message); err != nil || decoded != message {
+		t.Errorf("decode() failed. Expected message unchanged. Got: %v (%v)", decoded, err)
+	} else {
+		t.Log("decode() success")
+	}
+}
+
+func TestBCD(t *testing.T) {
+	packed, err := packBCD("123", 3)
+	if err != nil || packed != "\x01\x23" {
+		t.Errorf("packBCD() failed. Got: % x (%v)", packed, err)
+	}
+
+	digits, err := unpackBCD("\x01\x23", 3)
+	if err != nil || digits != "123" {
+		t.Errorf("unpackBCD() failed. Got: %v (%v)", digits, err)
+	}
+
+	if _, err := unpackBCD("\x1a", 2); err == nil {
+		t.Errorf("unpackBCD() failed. Expected error for invalid nibble")
+	} else {
+		t.Log("BCD success")
+	}
+}
+
+func TestEBCDIC(t *testing.T) {
+	ebcdic, err := asciiToEBCDIC("Az09 -:")
+	if err != nil || ebcdic != "\xc1\xa9\xf0\xf9\x40\x60\x7a" {
+		t.Errorf("asciiToEBCDIC() failed. Got: % x (%v)", ebcdic, err)
+	}
+
+	text, err := ebcdicToASCII(ebcdic)
+	if err != nil || text != "Az09 -:" {
+		t.Errorf("ebcdicToASCII() failed. Got: %v (%v)", text, err)
+	} else {
+		t.Log("EBCDIC success")
+	}
+}
@@ -0,0 +1,179 @@
+package kafkabiller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+)
+
+// Field 48 layouts per processing code, loaded from field48.yml at startup
+var field48Layouts map[string][]subFieldDescription
+
+// Field48Error describes why a field 48 value doesn't match its layout
+type Field48Error struct {
+	Pcode    string
+	SubField string
+	Reason   string
+}
+
+func (e *Field48Error) Error() string {
+	if e.SubField == "" {
+		return fmt.Sprintf("field 48 (%s): %s", e.Pcode, e.Reason)
+	}
+	return fmt.Sprintf("field 48 (%s): %s: %s", e.Pcode, e.SubField, e.Reason)
+}
+
+// Return field 48 layouts from a yaml file
+func layoutsFromFile(filename string) (map[string][]subFieldDescription, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var layouts map[string][]subFieldDescription
+	if err := yaml.Unmarshal(content, &layouts); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	// Validate every layout so a broken file is reported before any message is parsed
+	for pcode, layout := range layouts {
+		seen := make(map[string]bool)
+		for i, sub := range layout {
+			if sub.Name == "" {
+				return nil, fmt.Errorf("%s: %s: sub-field #%d has no name", filename, pcode, i+1)
+			}
+			if seen[sub.Name] {
+				return nil, fmt.Errorf("%s: %s: duplicate sub-field %s", filename, pcode, sub.Name)
+			}
+			seen[sub.Name] = true
+			if sub.Length < 0 || (sub.Offset != nil && *sub.Offset < 0) {
+				return nil, fmt.Errorf("%s: %s: %s has negative offset/length", filename, pcode, sub.Name)
+			}
+			if sub.Length > 0 && sub.Delimiter != "" {
+				return nil, fmt.Errorf("%s: %s: %s has both length and delimiter", filename, pcode, sub.Name)
+			}
+			if sub.Pad != "" && sub.Pad != "left" && sub.Pad != "right" {
+				return nil, fmt.Errorf("%s: %s: %s has invalid pad %q", filename, pcode, sub.Name, sub.Pad)
+			}
+		}
+	}
+
+	return layouts, nil
+}
+
+// Return field 48 layout for processing code
+func getField48Layout(pcode string) ([]subFieldDescription, error) {
+	layout, ok := field48Layouts[pcode]
+	if !ok {
+		return nil, &Field48Error{Pcode: pcode, Reason: "no layout defined for processing code"}
+	}
+	return layout, nil
+}
+
+// Return named sub-fields of field 48 for processing code
+func parseField48(pcode string, data string) (map[string]string, error) {
+	layout, err := getField48Layout(pcode)
+	if err != nil {
+		return nil, err
+	}
+	return parseSubFields(pcode, layout, data)
+}
+
+// Return named sub-fields of data using layout
+func parseSubFields(pcode string, layout []subFieldDescription, data string) (map[string]string, error) {
+	result := make(map[string]string, len(layout))
+	cursor := 0
+
+	for _, sub := range layout {
+		start := cursor
+		if sub.Offset != nil {
+			start = *sub.Offset
+		}
+
+		var value string
+		switch {
+		case start > len(data):
+			// Sub-field is absent, only allowed if it's optional
+			if sub.Required {
+				return nil, &Field48Error{pcode, sub.Name,
+					fmt.Sprintf("missing, field is %d characters but sub-field starts at offset %d", len(data), start)}
+			}
+			cursor = start
+
+		case sub.Length > 0:
+			end := start + sub.Length
+			if end > len(data) {
+				if sub.Required {
+					return nil, &Field48Error{pcode, sub.Name,
+						fmt.Sprintf("expected %d characters at offset %d, found %d", sub.Length, start, len(data)-start)}
+				}
+				end = len(data)
+			}
+			value = data[start:end]
+			cursor = end
+
+		case sub.Delimiter != "":
+			end := strings.Index(data[start:], sub.Delimiter)
+			if end < 0 {
+				value = data[start:]
+				cursor = len(data)
+			} else {
+				value = data[start : start+end]
+				cursor = start + end + len(sub.Delimiter)
+			}
+
+		default:
+			value = data[start:]
+			cursor = len(data)
+		}
+
+		if sub.Trim != "" {
+			value = strings.Trim(value, sub.Trim)
+		}
+		if sub.Required && value == "" {
+			return nil, &Field48Error{pcode, sub.Name, "required but empty"}
+		}
+		result[sub.Name] = value
+	}
+
+	return result, nil
+}
+
+// Return field 48 built from named sub-fields for processing code
+func buildField48(pcode string, values map[string]string) (string, error) {
+	layout, err := getField48Layout(pcode)
+	if err != nil {
+		return "", err
+	}
+
+	var data string
+	for _, sub := range layout {
+		value := values[sub.Name]
+		if sub.Required && value == "" {
+			return "", &Field48Error{pcode, sub.Name, "required but empty"}
+		}
+		if sub.Offset != nil && *sub.Offset != len(data) {
+			return "", &Field48Error{pcode, sub.Name, fmt.Sprintf("expected at offset %d, built %d characters before it", *sub.Offset, len(data))}
+		}
+
+		if sub.Length > 0 {
+			if len(value) > sub.Length {
+				return "", &Field48Error{pcode, sub.Name, fmt.Sprintf("longer than %d characters", sub.Length)}
+			}
+			padChar := sub.PadChar
+			if padChar == "" {
+				padChar = " "
+			}
+			if sub.Pad == "left" {
+				value = leftPad(value, sub.Length, padChar)
+			} else {
+				value = rightPad(value, sub.Length, padChar)
+			}
+		}
+		data += value + sub.Delimiter
+	}
+
+	return data, nil
+}
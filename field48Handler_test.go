@@ -0,0 +1,51 @@
+package kafkabiller
+
+import "testing"
+
+func TestParseSubFields(t *testing.T) {
+	offset := 4
+	layout := []subFieldDescription{
+		{Name: "code", Length: 4, Trim: " ", Required: true},
+		{Name: "name", Offset: &offset, Delimiter: "|", Required: true},
+		{Name: "note"},
+	}
+
+	result, err := parseSubFields("000000", layout, "AB  HANAFI|rest of data")
+	if err != nil {
+		t.Errorf("parseSubFields() failed. Error: %v", err)
+	}
+
+	expected := map[string]string{"code": "AB", "name": "HANAFI", "note": "rest of data"}
+	for name, value := range expected {
+		if result[name] != value {
+			t.Errorf("parseSubFields() failed at %v. Expected: %v. Got: %v", name, value, result[name])
+		}
+	}
+
+	_, err = parseSubFields("000000", layout, "AB  |")
+	if err == nil || err.Error() != "field 48 (000000): name: required but empty" {
+		t.Errorf("parseSubFields() failed. Expected required error. Got: %v", err)
+	} else {
+		t.Log("parseSubFields() success")
+	}
+}
+
+func TestBuildField48(t *testing.T) {
+	values := map[string]string{
+		"transaction_id": "2021",
+		"partner_id":     "USER01",
+		"product_code":   "WOM",
+		"customer_no":    "2",
+		"merchant_code":  "KIOS01",
+		"request_time":   "2018-05-15 15:10:05",
+		"periode":        "2020",
+	}
+	result, err := buildField48("380001", values)
+	expected := "2021                     USER01          WOM             2                        KIOS01                   2018-05-15 15:10:052020"
+
+	if err != nil || result != expected {
+		t.Errorf("buildField48() failed. \nExpected\t: %v. \nGot\t\t: %v (%v)", expected, result, err)
+	} else {
+		t.Log("buildField48() success")
+	}
+}
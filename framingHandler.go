@@ -0,0 +1,137 @@
+package kafkabiller
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Framer removes and adds the length header around an ISO8583 message.
+// Lengths are always counted in bytes
+type Framer interface {
+	// Unframe returns the message inside frame and the TPDU to echo back, if the framing has one
+	Unframe(frame string) (message string, tpdu string, err error)
+
+	// Frame returns message with its header, or an error if the header can't hold its length
+	Frame(message string, tpdu string) (string, error)
+}
+
+// Return framer by name as used in kafkaConfig.json
+func framerByName(name string) (Framer, error) {
+	switch name {
+	case "", "ascii4":
+		return asciiFramer{}, nil
+	case "binary2":
+		return binaryFramer{}, nil
+	case "tpdu":
+		return tpduFramer{}, nil
+	case "none":
+		return noFramer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown framing %q", name)
+	}
+}
+
+// 4 digit ASCII length header, e.g. "0123"
+type asciiFramer struct{}
+
+func (asciiFramer) Unframe(frame string) (string, string, error) {
+	if len(frame) < 4 {
+		return "", "", fmt.Errorf("frame is %d bytes, shorter than its 4 byte header", len(frame))
+	}
+	declared, err := strconv.Atoi(frame[:4])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid length header %q", frame[:4])
+	}
+	if err := checkFrameLength(declared, len(frame)-4); err != nil {
+		return "", "", err
+	}
+	return frame[4:], "", nil
+}
+
+func (asciiFramer) Frame(message string, tpdu string) (string, error) {
+	if len(message) > 9999 {
+		return "", fmt.Errorf("message is %d bytes, longer than a 4 digit header can declare", len(message))
+	}
+	return fmt.Sprintf("%04d", len(message)) + message, nil
+}
+
+// 2 byte binary big-endian length header
+type binaryFramer struct{}
+
+func (binaryFramer) Unframe(frame string) (string, string, error) {
+	if len(frame) < 2 {
+		return "", "", fmt.Errorf("frame is %d bytes, shorter than its 2 byte header", len(frame))
+	}
+	declared := int(binary.BigEndian.Uint16([]byte(frame[:2])))
+	if err := checkFrameLength(declared, len(frame)-2); err != nil {
+		return "", "", err
+	}
+	return frame[2:], "", nil
+}
+
+func (binaryFramer) Frame(message string, tpdu string) (string, error) {
+	header, err := binaryLength(len(message))
+	if err != nil {
+		return "", err
+	}
+	return header + message, nil
+}
+
+// 2 byte binary big-endian length header followed by 5 byte TPDU,
+// the length covers TPDU and message
+type tpduFramer struct{}
+
+func (tpduFramer) Unframe(frame string) (string, string, error) {
+	if len(frame) < 7 {
+		return "", "", fmt.Errorf("frame is %d bytes, shorter than its 7 byte header", len(frame))
+	}
+	declared := int(binary.BigEndian.Uint16([]byte(frame[:2])))
+	if err := checkFrameLength(declared, len(frame)-2); err != nil {
+		return "", "", err
+	}
+	return frame[7:], frame[2:7], nil
+}
+
+func (tpduFramer) Frame(message string, tpdu string) (string, error) {
+	// Response TPDU swaps destination and source address of the request TPDU
+	response := "\x60\x00\x00\x00\x00"
+	if len(tpdu) == 5 {
+		response = tpdu[0:1] + tpdu[3:5] + tpdu[1:3]
+	}
+	header, err := binaryLength(len(response) + len(message))
+	if err != nil {
+		return "", err
+	}
+	return header + response + message, nil
+}
+
+// No header, the Kafka event is the message
+type noFramer struct{}
+
+func (noFramer) Unframe(frame string) (string, string, error) {
+	return frame, "", nil
+}
+
+func (noFramer) Frame(message string, tpdu string) (string, error) {
+	return message, nil
+}
+
+// Check that declared length matches the bytes received
+func checkFrameLength(declared int, actual int) error {
+	if declared != actual {
+		return fmt.Errorf("length header declares %d bytes, payload is %d bytes", declared, actual)
+	}
+	return nil
+}
+
+// Return 2 byte big-endian length, lengths over 65535 bytes don't fit
+func binaryLength(length int) (string, error) {
+	if length > math.MaxUint16 {
+		return "", fmt.Errorf("message is %d bytes, longer than a 2 byte header can declare", length)
+	}
+	header := make([]byte, 2)
+	binary.BigEndian.PutUint16(header, uint16(length))
+	return string(header), nil
+}
@@ -0,0 +1,86 @@
+package kafkabiller
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFramers(t *testing.T) {
+	// "É" is 2 bytes, length header must count bytes
+	message := "0210NAMÉ"
+
+	for _, name := range []string{"ascii4", "binary2", "tpdu", "none"} {
+		framer, err := framerByName(name)
+		if err != nil {
+			t.Errorf("framerByName(%v) failed. Error: %v", name, err)
+			continue
+		}
+
+		frame, err := framer.Frame(message, "")
+		if err != nil {
+			t.Errorf("%v framer failed. Error: %v", name, err)
+			continue
+		}
+		result, _, err := framer.Unframe(frame)
+		if err != nil || result != message {
+			t.Errorf("%v framer failed. Expected: %v. Got: %v (%v)", name, message, result, err)
+		}
+	}
+
+	if frame, _ := (asciiFramer{}).Frame(message, ""); frame[:4] != "0009" {
+		t.Errorf("asciiFramer.Frame() failed. Expected header: 0009. Got: %v", frame[:4])
+	} else {
+		t.Log("Framers success")
+	}
+}
+
+func TestFramerLengthMismatch(t *testing.T) {
+	_, _, err := asciiFramer{}.Unframe("00100210")
+	expected := "length header declares 10 bytes, payload is 4 bytes"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("asciiFramer.Unframe() failed. Expected: %v. Got: %v", expected, err)
+	} else {
+		t.Log("asciiFramer.Unframe() success")
+	}
+}
+
+func TestTpduFramer(t *testing.T) {
+	// TPDU: id 60, destination 0001, source 0002
+	request := "\x00\x09" + "\x60\x00\x01\x00\x02" + "0200"
+	message, tpdu, err := tpduFramer{}.Unframe(request)
+	if err != nil || message != "0200" {
+		t.Errorf("tpduFramer.Unframe() failed. Got: %q (%v)", message, err)
+	}
+
+	response, _ := tpduFramer{}.Frame("0210", tpdu)
+	expected := "\x00\x09" + "\x60\x00\x02\x00\x01" + "0210"
+	if response != expected {
+		t.Errorf("tpduFramer.Frame() failed. Expected: %q. Got: %q", expected, response)
+	} else {
+		t.Log("tpduFramer success")
+	}
+}
+
+func TestFramerTooLong(t *testing.T) {
+	tests := []struct {
+		framer  Framer
+		message string
+	}{
+		{asciiFramer{}, strings.Repeat("0", 10000)},
+		{binaryFramer{}, strings.Repeat("0", 65536)},
+		{tpduFramer{}, strings.Repeat("0", 65531)},
+	}
+	for _, test := range tests {
+		if frame, err := test.framer.Frame(test.message, ""); err == nil {
+			t.Errorf("%T.Frame() failed. Expected: error for %d bytes. Got: %d byte frame", test.framer, len(test.message), len(frame))
+		}
+	}
+
+	// Longest message that fits
+	if frame, err := (binaryFramer{}).Frame(strings.Repeat("0", 65535), ""); err != nil || frame[:2] != "\xff\xff" {
+		t.Errorf("binaryFramer.Frame() failed. Expected: header FFFF. Got: %q (%v)", frame[:2], err)
+	} else {
+		t.Log("Framers too long success")
+	}
+}
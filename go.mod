@@ -7,5 +7,4 @@ require (
 	github.com/go-yaml/yaml v2.1.0+incompatible
 	github.com/gorilla/mux v1.8.0
 	github.com/mofax/iso8583 v0.0.0-20180221163034-b7d818f6d7eb
-	github.com/rivo/uniseg v0.2.0
 )
@@ -1,13 +1,17 @@
-package main
+package kafkabiller
 
 import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // Response formatter
@@ -17,8 +21,12 @@ func jsonFormatter(w http.ResponseWriter, data interface{}, statusCode int) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// Sequence number of archived messages, keeps their file names unique when workers archive
+// messages of the same processing code at the same time
+var archiveSequence uint64
+
 // Create file for request/response
-func CreateFile(fileName string, content string) string {
+func CreateFile(fileName string, content string) (string, error) {
 
 	if !strings.Contains(fileName, ".txt") {
 		fileName += ".txt"
@@ -27,7 +35,7 @@ func CreateFile(fileName string, content string) string {
 	file, err := os.Create(fileName)
 
 	if err != nil {
-		log.Fatalf("failed creating file: %s", err)
+		return "", fmt.Errorf("failed creating file: %v", err)
 	}
 
 	defer file.Close()
@@ -35,11 +43,57 @@ func CreateFile(fileName string, content string) string {
 	_, err = file.WriteString(content)
 
 	if err != nil {
-		log.Fatalf("failed writing to file: %s", err)
+		return "", fmt.Errorf("failed writing to file: %v", err)
+	}
+
+	return fileName, nil
+
+}
+
+// Return unique file name in storage/response for an archived message, e.g.
+// Response_to_380001_000123@2021-03-18 08:03:35.123456789#42
+func archiveFileName(kind string, pcode string, reference string) string {
+	sequence := atomic.AddUint64(&archiveSequence, 1)
+	return fmt.Sprintf("storage/response/%s_to_%s_%s@%s#%d", kind, pcode, reference,
+		time.Now().Format("2006-01-02 15:04:05.000000000"), sequence)
+}
+
+// Archive a masked message to storage/response, a failure is logged so the message is still answered
+func archiveMessage(kind string, pcode string, reference string, masked string) {
+	file, err := CreateFile(archiveFileName(kind, pcode, reference), masked)
+	if err != nil {
+		log.Printf("Failed to archive %s to %s. Error: %v\n", strings.ToLower(kind), pcode, err)
+		return
 	}
+	log.Println("File created: ", file)
+}
 
-	return fileName
+// Replace a file with content at once so a crash leaves the old or the new content, every call
+// writes its own temporary file
+func replaceFile(fileName string, content []byte) error {
+	dir := filepath.Dir(fileName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
 
+	temp, err := ioutil.TempFile(dir, filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), fileName)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
 }
 
 func signatureSHA256(data string) (hash string) {
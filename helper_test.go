@@ -1,13 +1,13 @@
-package main
+package kafkabiller
 
 import "testing"
 
 func TestCreateFile(t *testing.T) {
-	createdFile := CreateFile("testFile", "testContent")
+	createdFile, err := CreateFile("testFile", "testContent")
 	expectedFileName := "testFile.txt"
 
-	if createdFile != expectedFileName {
-		t.Errorf("CreateFile() failed. Expected: %v. Got: %v", expectedFileName, createdFile)
+	if err != nil || createdFile != expectedFileName {
+		t.Errorf("CreateFile() failed. Expected: %v. Got: %v (%v)", expectedFileName, createdFile, err)
 	} else {
 		t.Log("CreateFile() success")
 	}
@@ -24,3 +24,25 @@ func TestSignatureSHA256(t *testing.T) {
 		t.Log("signatureSHA256() success")
 	}
 }
+
+func TestCreateFileError(t *testing.T) {
+	// A failed write is returned to the caller instead of stopping the service
+	if _, err := CreateFile("missing-directory/testFile", "testContent"); err == nil {
+		t.Errorf("CreateFile() failed. Expected: error for missing directory. Got: nil")
+	} else {
+		t.Log("CreateFile() error success")
+	}
+}
+
+func TestArchiveFileName(t *testing.T) {
+	// Workers archiving the same processing code at once get different files
+	names := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		names[archiveFileName("Response", "380001", "000123")] = true
+	}
+	if len(names) != 100 {
+		t.Errorf("archiveFileName() failed. Expected: 100 unique names. Got: %d", len(names))
+	} else {
+		t.Log("archiveFileName() success")
+	}
+}
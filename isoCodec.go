@@ -0,0 +1,293 @@
+package kafkabiller
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Money fields are formatted as amounts instead of plain numbers
+var moneyType = reflect.TypeOf(Money(0))
+
+// isoTag is a parsed `iso` struct tag, e.g. `iso:"4"`, `iso:"48,offset=25,len=16"`,
+// `iso:"48,sub=customer_no"`, `iso:"62,sep=|"` or `iso:"62,struk=63"`. Slices are joined with ","
+// unless sep is set, struk encodes lines with encodeStruk and continues in the given field
+type isoTag struct {
+	Field    int
+	Offset   int
+	Len      int
+	SubField string
+	Sep      string
+	Struk    int
+}
+
+// Return parsed `iso` struct tag, ok is false if the struct field isn't mapped
+func parseIsoTag(tag string) (parsed isoTag, ok bool, err error) {
+	if tag == "" || tag == "-" {
+		return parsed, false, nil
+	}
+
+	parts := strings.Split(tag, ",")
+	parsed.Field, err = strconv.Atoi(parts[0])
+	if err != nil || parsed.Field < 2 || parsed.Field > 128 {
+		return parsed, false, fmt.Errorf("invalid iso tag %q: field must be between 2 and 128", tag)
+	}
+	parsed.Sep = ","
+
+	for i := 1; i < len(parts); i++ {
+		option := strings.SplitN(parts[i], "=", 2)
+		if len(option) != 2 || option[1] == "" {
+			return parsed, false, fmt.Errorf("invalid iso tag %q: option %q", tag, parts[i])
+		}
+
+		switch option[0] {
+		case "offset":
+			parsed.Offset, err = strconv.Atoi(option[1])
+		case "len":
+			parsed.Len, err = strconv.Atoi(option[1])
+		case "sub":
+			parsed.SubField = option[1]
+		case "sep":
+			parsed.Sep = option[1]
+		case "struk":
+			parsed.Struk, err = strconv.Atoi(option[1])
+			if err == nil && (parsed.Struk < 2 || parsed.Struk > 128 || parsed.Struk == parsed.Field) {
+				err = fmt.Errorf("invalid continuation field")
+			}
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+		if err != nil {
+			return parsed, false, fmt.Errorf("invalid iso tag %q: option %q", tag, parts[i])
+		}
+	}
+
+	if parsed.SubField != "" && parsed.Field != 48 {
+		return parsed, false, fmt.Errorf("invalid iso tag %q: only field 48 has named sub-fields", tag)
+	}
+	if parsed.Offset < 0 || parsed.Len < 0 || (parsed.Offset > 0 && parsed.Len == 0) {
+		return parsed, false, fmt.Errorf("invalid iso tag %q: offset needs a positive len", tag)
+	}
+	return parsed, true, nil
+}
+
+// Return ISO fields of a struct using its `iso` tags
+func marshalIso(v interface{}) (map[int]string, error) {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("marshalIso: expected struct, got %v", value.Kind())
+	}
+
+	// Parts of fields made of several struct fields, placed by offset
+	type part struct {
+		offset int
+		data   string
+	}
+	parts := make(map[int][]part)
+	result := make(map[int]string)
+
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		tag, ok, err := parseIsoTag(structField.Tag.Get("iso"))
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", value.Type().Name(), structField.Name, err)
+		}
+		if !ok {
+			continue
+		}
+		if tag.SubField != "" {
+			return nil, fmt.Errorf("%s.%s: named sub-fields can't be marshalled, use offset and len", value.Type().Name(), structField.Name)
+		}
+
+		if tag.Struk > 0 {
+			lines, ok := value.Field(i).Interface().([]string)
+			if !ok {
+				return nil, fmt.Errorf("%s.%s: struk needs []string", value.Type().Name(), structField.Name)
+			}
+			result[tag.Field], result[tag.Struk] = encodeStruk(lines)
+			if result[tag.Struk] == "" {
+				delete(result, tag.Struk)
+			}
+			continue
+		}
+
+		data, err := isoFieldString(value.Field(i), tag)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", value.Type().Name(), structField.Name, err)
+		}
+		if value.Field(i).Type() == moneyType && amountFields[tag.Field] {
+			result[49] = currencyIDR
+		}
+
+		if tag.Len == 0 {
+			result[tag.Field] = data
+			continue
+		}
+		if len(data) > tag.Len {
+			return nil, fmt.Errorf("%s.%s: longer than %d characters", value.Type().Name(), structField.Name, tag.Len)
+		}
+		parts[tag.Field] = append(parts[tag.Field], part{tag.Offset, rightPad(data, tag.Len, " ")})
+	}
+
+	// Join sub-fields of the same ISO field in offset order
+	for field, fieldParts := range parts {
+		sort.Slice(fieldParts, func(i, j int) bool { return fieldParts[i].offset < fieldParts[j].offset })
+		var data string
+		for _, p := range fieldParts {
+			if p.offset < len(data) {
+				return nil, fmt.Errorf("%s: field %d: sub-field at offset %d overlaps", value.Type().Name(), field, p.offset)
+			}
+			data = rightPad(data, p.offset, " ") + p.data
+		}
+		result[field] = data
+	}
+
+	return result, nil
+}
+
+// Return struct field value as ISO field data
+func isoFieldString(v reflect.Value, tag isoTag) (string, error) {
+	if v.Type() == moneyType {
+		if amountFields[tag.Field] {
+			return Money(v.Int()).isoAmount()
+		}
+		return Money(v.Int()).String(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := isoFieldString(v.Index(i), tag)
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return strings.Join(items, tag.Sep), nil
+	default:
+		return "", fmt.Errorf("unsupported type %v", v.Type())
+	}
+}
+
+// Assign ISO fields to a struct pointer using its `iso` tags
+func unmarshalIso(fields map[int64]string, v interface{}) error {
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unmarshalIso: expected pointer to struct, got %v", ptr.Kind())
+	}
+	value := ptr.Elem()
+
+	// Field 48 sub-fields are only parsed when a struct field asks for them
+	var field48 map[string]string
+
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		tag, ok, err := parseIsoTag(structField.Tag.Get("iso"))
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", value.Type().Name(), structField.Name, err)
+		}
+		if !ok {
+			continue
+		}
+
+		data, present := fields[int64(tag.Field)]
+		switch {
+		case tag.Struk > 0:
+			// Receipt lines keep their spaces
+			lines, err := decodeStruk(data + fields[int64(tag.Struk)])
+			if err != nil {
+				return fmt.Errorf("field %d: %v", tag.Field, err)
+			}
+			if _, ok := value.Field(i).Interface().([]string); !ok {
+				return fmt.Errorf("%s.%s: struk needs []string", value.Type().Name(), structField.Name)
+			}
+			value.Field(i).Set(reflect.ValueOf(lines))
+			continue
+
+		case tag.SubField != "":
+			if field48 == nil {
+				field48, err = parseField48(fields[3], fields[48])
+				if err != nil {
+					return err
+				}
+			}
+			data = field48[tag.SubField]
+
+		case tag.Len > 0:
+			if !present {
+				break
+			}
+			if tag.Offset+tag.Len > len(data) {
+				return fmt.Errorf("field %d: expected %d characters at offset %d, found %d",
+					tag.Field, tag.Len, tag.Offset, len(data)-tag.Offset)
+			}
+			data = data[tag.Offset : tag.Offset+tag.Len]
+		}
+		data = strings.Trim(data, " ")
+
+		if value.Field(i).Type() == moneyType && present && amountFields[tag.Field] {
+			if currency, ok := fields[49]; ok && currency != currencyIDR {
+				return fmt.Errorf("field 49: unsupported currency %q", currency)
+			}
+		}
+		if err := setIsoField(value.Field(i), data, tag); err != nil {
+			return fmt.Errorf("field %d: %v", tag.Field, err)
+		}
+	}
+
+	return nil
+}
+
+// Assign ISO field data to struct field value
+func setIsoField(v reflect.Value, data string, tag isoTag) error {
+	if v.Type() == moneyType {
+		if data == "" {
+			v.SetInt(0)
+			return nil
+		}
+		amount, err := parseMoney(data)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(amount))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(data)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if data == "" {
+			v.SetInt(0)
+			return nil
+		}
+		number, err := strconv.ParseInt(data, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", data)
+		}
+		v.SetInt(number)
+	case reflect.Slice:
+		if data == "" {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			return nil
+		}
+		items := strings.Split(data, tag.Sep)
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i := range items {
+			if err := setIsoField(slice.Index(i), items[i], tag); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package kafkabiller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalIso(t *testing.T) {
+	type sample struct {
+		Code   string   `iso:"48,offset=0,len=4"`
+		Name   string   `iso:"48,offset=4,len=8"`
+		Amount int      `iso:"4"`
+		Lines  []string `iso:"62,sep=|"`
+		Note   string
+	}
+
+	result, err := marshalIso(sample{Code: "AB", Name: "HANAFI", Amount: 1500, Lines: []string{"a,b", "c"}, Note: "skip"})
+	if err != nil {
+		t.Errorf("marshalIso() failed. Error: %v", err)
+	}
+
+	expected := map[int]string{4: "1500", 48: "AB  HANAFI  ", 62: "a,b|c"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("marshalIso() failed. Expected: %v. Got: %v", expected, result)
+	} else {
+		t.Log("marshalIso() success")
+	}
+}
+
+func TestUnmarshalIso(t *testing.T) {
+	var result PPOBPaymentRequest
+	fields := map[int64]string{
+		3:  "810001",
+		4:  "000000873300",
+		37: "12345       ",
+		48: "2015                     USER01          WOM             2                        KIOS01                   2018-05-15 15:10:05",
+	}
+
+	if err := unmarshalIso(fields, &result); err != nil {
+		t.Errorf("unmarshalIso() failed. Error: %v", err)
+	}
+	if result.Amount != 873300 || result.ReffID != "12345" || result.CustomerNo != "2" {
+		t.Errorf("unmarshalIso() failed. Got: %+v", result)
+	}
+
+	fields[4] = "00000087330X"
+	err := unmarshalIso(fields, &result)
+	expected := `field 4: invalid amount "00000087330X"`
+	if err == nil || err.Error() != expected {
+		t.Errorf("unmarshalIso() failed. Expected: %v. Got: %v", expected, err)
+	} else {
+		t.Log("unmarshalIso() success")
+	}
+}
+
+func TestModelIsoTags(t *testing.T) {
+	models := []interface{}{
+		PPOBInquiryResponse{}, PPOBPaymentResponse{}, PPOBStatusResponse{},
+		TopupBuyResponse{}, TopupCheckResponse{}, UnsuccessfulChipsakti{},
+	}
+	for _, model := range models {
+		if _, err := marshalIso(model); err != nil {
+			t.Errorf("marshalIso() failed for %T. Error: %v", model, err)
+		}
+	}
+}
@@ -1,173 +1,331 @@
-package main
+package kafkabiller
 
 import (
-	"fmt"
-	"github.com/go-yaml/yaml"
-	"io/ioutil"
+	"errors"
 	"log"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mofax/iso8583"
-	"github.com/rivo/uniseg"
 )
 
-// Handler to new consumed request in consumerChan and send new response to billerChan
-func requestHandler() {
-
-	// loop for checking if there is any new request from Consumer (Kafka) that has been sent to consumerChan
-	for {
-		select {
-		// execute if there is a new request in consumerChan
-		case newRequest := <-consumerChan:
-
-			start := time.Now()
-			// Send new request to `Biller` and get response that ready to produce
-			msg := newRequest
-			log.Printf("[Time: %v. Elapsed: %.6fs] Received new Request\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
-			isoParsed := getResponse(msg, start)
-
-			// Send new response to billerChan
-			billerChan <- isoParsed
-
-			// Done with requestHandler
-			log.Printf("[Time: %v. Elapsed: %.6fs] Send response to consumer\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
-			log.Println("New request handled")
-
-		// keep looping if there is none new request
-		default:
-			continue
-		}
+// Handler to new consumed requests in requests and send new responses to responses. Requests are
+// handled by workers goroutines at once, so a slow Biller call or a request waiting for its rate
+// limit doesn't hold the others and the breaker's MaxConcurrent limits the calls in flight
+func requestHandler(requests <-chan ConsumedMessage, responses chan<- string, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for newRequest := range requests {
+				handleRequest(newRequest, responses)
+			}
+		}()
 	}
+	wg.Wait()
+}
 
+// Handle a consumed request and send its response to responses
+func handleRequest(newRequest ConsumedMessage, responses chan<- string) {
+	start := time.Now()
+	// Send new request to `Biller` and get response that ready to produce
+	msg := newRequest.Value
+	channel := channelFor(newRequest.Topic)
+	log.Printf("[Time: %v. Elapsed: %.6fs] Received new Request\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
+	isoParsed := getResponse(msg, channel, start)
+
+	// Send new response to billerChan
+	responses <- isoParsed
+
+	// Done with requestHandler
+	log.Printf("[Time: %v. Elapsed: %.6fs] Send response to consumer\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
+	log.Println("New request handled")
 }
 
 // Return response from `Biller` in ISO8583 Format
-func getResponse(message string, start time.Time) (isoResponse string) {
+func getResponse(message string, channel Channel, start time.Time) (isoResponse string) {
 
 	var response Iso8583
-	data := message[4:]
 
-	// Parse new ISO8583 message to ISO Struct
-	isoStruct := iso8583.NewISOStruct("spec1987.yml", true)
-	msg, err := isoStruct.Parse(data)
-	if err != nil {
-		log.Println(err)
+	// Remove length header and parse new ISO8583 message to ISO Struct
+	var msg iso8583.IsoStruct
+	wire, tpdu, err := channel.Framer.Unframe(message)
+	var data string
+	if err == nil {
+		data, err = channel.Encoding.decode(channel.Version.Spec, wire)
+	}
+	if err == nil {
+		msg, err = channel.Version.Spec.parse(data)
+	}
+	if err == nil {
+		err = channel.MAC.verify(channel.Version.Spec, channel.Encoding, wire, msg)
+	}
+
+	// Convert request to ISO8583:1987 used by routing and conversion
+	if err == nil {
+		msg, err = channel.Version.toInternal(msg)
 	}
 
 	var isoParsed iso8583.IsoStruct
 
-	// Check processing code and send request to appropriate `Biller` endpoints
+	// Check processing code and MTI in routing table and send request to appropriate `Biller` endpoints
 	pcode := msg.Elements.GetElements()[3]
-	switch pcode {
+	route, ok := findRoute(routingTable, pcode, msg.Mti.String())
+	if err == nil && ok {
+		err = isoSpec.validateMessage(msg, route.Mandatory)
+	}
+
+	var macErr *MACError
+
+	switch {
+	// Reject request with missing or invalid MAC
+	case errors.As(err, &macErr):
+		log.Printf("Rejected request on channel %v. Error: %v\n", channel.Topic, err)
+		metrics.inc("mac_rejected", "channel", channel.Topic)
+		isoParsed = getIsoError(pcode, "63", "invalid MAC")
+
+	// Reject request that can't be parsed or doesn't match the spec
+	case err != nil:
+		log.Printf("Invalid request. Error: %v\n", err)
+		isoParsed = getIsoError(pcode, "30", err.Error())
+
+	// Reject request without route
+	case !ok:
+		log.Printf("No route for processing code %v (MTI %v)\n", pcode, msg.Mti.String())
+		isoParsed = getIsoError(pcode, "12", "no route for processing code "+pcode)
+
 	// Process PPOB Inquiry request
-	case "380001":
+	case route.Handler == "ppobInquiry":
 		// Convert ISO message to JSON format
-		jsonIso := getJsonPPOBInquiry(msg)
+		jsonIso, err := getJsonPPOBInquiry(msg)
+		if err != nil {
+			log.Printf("Invalid PPOB Inquiry request. Error: %v\n", err)
+			isoParsed = getIsoError(pcode, "30", err.Error())
+			break
+		}
 		log.Printf("[Time: %v. Elapsed: %.6fs] Convert ISO message to JSON format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
-		// Send JSON data to Biller
-		serverResp := responseJsonPPOBInquiry(jsonIso)
+		// Send JSON data to the Biller serving the product and partner
+		biller := billerEnv.biller(route.Biller, jsonIso.ProductCode, jsonIso.PartnerID)
+		serverResp, err := biller.Inquiry(jsonIso)
+		if err != nil {
+			log.Printf("Failed PPOB Inquiry request to Biller. Error: %v\n", err)
+			isoParsed = getIsoError(pcode, billerResponseCode(err), err.Error())
+			break
+		}
 		log.Printf("[Time: %v. Elapsed: %.6fs] Send JSON data to Biller\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
 		// Convert response from JSON data to ISO8583 format
-		isoParsed = getIsoPPOBInquiry(serverResp)
+		isoParsed = getIsoPPOBInquiry(pcode, serverResp)
 		log.Printf("[Time: %v. Elapsed: %.6fs] Convert response from JSON data to ISO8583 format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
 	// Process PPOB Payment request
-	case "810001":
+	case route.Handler == "ppobPayment":
 		// Convert ISO message to JSON format
-		jsonIso := getJsonPPOBPayment(msg)
+		jsonIso, err := getJsonPPOBPayment(msg)
+		if err != nil {
+			log.Printf("Invalid PPOB Payment request. Error: %v\n", err)
+			isoParsed = getIsoError(pcode, "30", err.Error())
+			break
+		}
 		log.Printf("[Time: %v. Elapsed: %.6fs] Convert ISO message to JSON format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
-		// Send JSON data to Biller
-		serverResp := responsePPOBPayment(jsonIso)
+		// Send JSON data to the Biller serving the product and partner
+		biller := billerEnv.biller(route.Biller, jsonIso.ProductCode, jsonIso.PartnerID)
+		serverResp, err := biller.Payment(jsonIso)
+		if reason := suspectPolicy.reason(err, serverResp.Rc); reason != "" {
+			// Outcome is unknown, the final result follows as an advice
+			startSuspect(jsonIso.TransactionID, reason, channel, tpdu, msg, suspectResume{Biller: biller.Name(), Payment: &jsonIso})
+			isoParsed = getIsoError(pcode, suspectResponseCode(err), suspectMessage)
+			break
+		}
+		if err != nil {
+			log.Printf("Failed PPOB Payment request to Biller. Error: %v\n", err)
+			isoParsed = getIsoError(pcode, billerResponseCode(err), err.Error())
+			break
+		}
 		log.Printf("[Time: %v. Elapsed: %.6fs] Send JSON data to Biller\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
 		// Convert response from JSON data to ISO8583 format
-		isoParsed = getIsoPPOBPayment(serverResp)
+		isoParsed = getIsoPPOBPayment(pcode, serverResp)
 		log.Printf("[Time: %v. Elapsed: %.6fs] Convert response from JSON data to ISO8583 format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
 	// Process PPOB Status request
-	case "380002":
+	case route.Handler == "ppobStatus":
 		// Convert ISO message to JSON format
-		jsonIso := getJsonPPOBStatus(msg)
+		jsonIso, err := getJsonPPOBStatus(msg)
+		if err != nil {
+			log.Printf("Invalid PPOB Status request. Error: %v\n", err)
+			isoParsed = getIsoError(pcode, "30", err.Error())
+			break
+		}
 		log.Printf("[Time: %v. Elapsed: %.6fs] Convert ISO message to JSON format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
-		// Send JSON data to Biller
-		serverResp := responsePPOBStatus(jsonIso)
+		// Send JSON data to the Biller serving the product and partner
+		biller := billerEnv.biller(route.Biller, jsonIso.ProductCode, jsonIso.PartnerID)
+		serverResp, err := biller.Status(jsonIso)
+		if err != nil {
+			log.Printf("Failed PPOB Status request to Biller. Error: %v\n", err)
+			isoParsed = getIsoError(pcode, billerResponseCode(err), err.Error())
+			break
+		}
 		log.Printf("[Time: %v. Elapsed: %.6fs] Send JSON data to Biller\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
 		// Convert response from JSON data to ISO8583 format
-		isoParsed = getIsoPPOBStatus(serverResp)
+		isoParsed = getIsoPPOBStatus(pcode, serverResp)
 		log.Printf("[Time: %v. Elapsed: %.6fs] Convert response from JSON data to ISO8583 format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
 	// Process Topup Buy
-	case "810002":
+	case route.Handler == "topupBuy":
 		// Convert ISO message to JSON format
-		jsonIso := getJsonTopupBuy(msg)
+		jsonIso, err := getJsonTopupBuy(msg)
+		if err != nil {
+			log.Printf("Invalid Topup Buy request. Error: %v\n", err)
+			isoParsed = getIsoError(pcode, "30", err.Error())
+			break
+		}
 		log.Printf("[Time: %v. Elapsed: %.6fs] Convert ISO message to JSON format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
-		// Send JSON data to Biller
-		serverResp := responseTopupBuy(jsonIso)
+		// Send JSON data to the suppliers of the product until one takes it
+		topupRoute, billers := billerEnv.topupBillers(route.Biller, jsonIso.ProductCode, jsonIso.PartnerID)
+		biller, serverResp, err := topupBuy(topupRoute, billers, jsonIso)
+		if reason := suspectPolicy.reason(err, serverResp.Rc); reason != "" {
+			// Outcome is unknown, the final result follows as an advice
+			startSuspect(jsonIso.TransactionID, reason, channel, tpdu, msg, suspectResume{Biller: biller.Name(), Buy: &jsonIso})
+			isoParsed = getIsoError(pcode, suspectResponseCode(err), suspectMessage)
+			break
+		}
+		if err != nil {
+			log.Printf("Failed Topup Buy request to Biller. Error: %v\n", err)
+			isoParsed = getIsoError(pcode, billerResponseCode(err), err.Error())
+			break
+		}
 		log.Printf("[Time: %v. Elapsed: %.6fs] Send JSON data to Biller\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
 		// Convert response from JSON data to ISO8583 format
-		isoParsed = getIsoTopupBuy(serverResp)
+		isoParsed = getIsoTopupBuy(pcode, serverResp)
 		log.Printf("[Time: %v. Elapsed: %.6fs] Convert response from JSON data to ISO8583 format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
 	// Process Topup Check
-	case "380003":
+	case route.Handler == "topupCheck":
 		// Convert ISO message to JSON format
-		jsonIso := getJsonTopupCheck(msg)
+		jsonIso, err := getJsonTopupCheck(msg)
+		if err != nil {
+			log.Printf("Invalid Topup Check request. Error: %v\n", err)
+			isoParsed = getIsoError(pcode, "30", err.Error())
+			break
+		}
 		log.Printf("[Time: %v. Elapsed: %.6fs] Convert ISO message to JSON format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
-		// Send JSON data to Biller
-		serverResp := responseTopupCheck(jsonIso)
+		// Send JSON data to the Biller serving the product and partner
+		biller := billerEnv.biller(route.Biller, jsonIso.ProductCode, jsonIso.PartnerID)
+		serverResp, err := biller.TopupCheck(jsonIso)
+		if err != nil {
+			log.Printf("Failed Topup Check request to Biller. Error: %v\n", err)
+			isoParsed = getIsoError(pcode, billerResponseCode(err), err.Error())
+			break
+		}
 		log.Printf("[Time: %v. Elapsed: %.6fs] Send JSON data to Biller\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 
 		// Convert response from JSON data to ISO8583 format
-		isoParsed = getIsoTopupCheck(serverResp)
+		isoParsed = getIsoTopupCheck(pcode, serverResp)
+		log.Printf("[Time: %v. Elapsed: %.6fs] Convert response from JSON data to ISO8583 format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
+
+	// Process route without built-in conversion
+	default:
+		// Convert ISO message to form data using route mapping
+		jsonIso, err := getJsonRoute(route, msg)
+		if err != nil {
+			log.Printf("Invalid %s request. Error: %v\n", route.Name, err)
+			isoParsed = getIsoError(pcode, "30", err.Error())
+			break
+		}
+		log.Printf("[Time: %v. Elapsed: %.6fs] Convert ISO message to JSON format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
+
+		// Send form data to Biller
+		serverResp, err := responseRoute(route, jsonIso)
+		if err != nil {
+			log.Printf("Failed %s request to Biller. Error: %v\n", route.Name, err)
+			isoParsed = getIsoError(pcode, billerResponseCode(err), err.Error())
+			break
+		}
+		log.Printf("[Time: %v. Elapsed: %.6fs] Send JSON data to Biller\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
+
+		// Convert response from JSON data to ISO8583 format using route mapping
+		isoParsed = getIsoRoute(route, pcode, serverResp)
 		log.Printf("[Time: %v. Elapsed: %.6fs] Convert response from JSON data to ISO8583 format\n", time.Now().Format("15:04:05"), time.Since(start).Seconds())
 	}
 
-	isoMessage, _ := isoParsed.ToString()
-	isoHeader := fmt.Sprintf("%04d", uniseg.GraphemeClusterCount(isoMessage))
+	// Convert response to the channel's version and encoding
+	isoParsed, isoMessage := toChannel(channel, pcode, isoParsed)
+
+	// A response too long for the channel's length header is replaced by RC 96
+	isoResponse, err = channel.Framer.Frame(isoMessage, tpdu)
+	if err != nil {
+		log.Printf("Failed to frame response for channel %v. Error: %v\n", channel.Topic, err)
+		isoParsed, isoMessage = toChannel(channel, pcode, getIsoError(pcode, "96", "response can't be framed for channel"))
+		isoResponse, _ = channel.Framer.Frame(isoMessage, tpdu)
+	}
 
-	response.Header, _ = strconv.Atoi(isoHeader)
+	response.Header = len(isoMessage)
 	response.MTI = isoParsed.Mti.String()
 	response.Hex, _ = iso8583.BitMapArrayToHex(isoParsed.Bitmap)
 	response.Message = isoMessage
 
-	isoResponse = isoHeader + isoMessage
-	log.Printf("\n\nResponse: \n\tHeader: %v\n\tMTI: %v\n\tHex: %v\n\tIso Message: %v\n\tFull Message: %v\n\n",
+	// Logs and stored files only get the masked message
+	masked := maskPolicy.iso(channel.Version.Spec, isoParsed)
+	log.Printf("\n\nResponse: \n\tHeader: %v\n\tMTI: %v\n\tHex: %v\n\tIso Message: %v\n\n",
 		response.Header,
 		response.MTI,
 		response.Hex,
-		response.Message,
-		isoResponse)
+		masked)
 
 	// create file from response
-	filename := "Response_to_" + isoParsed.Elements.GetElements()[3] + "@" + fmt.Sprintf(time.Now().Format("2006-01-02 15:04:05"))
-	file := CreateFile("storage/response/"+filename, isoResponse)
-	log.Println("File created: ", file)
+	fields := isoParsed.Elements.GetElements()
+	archiveMessage("Response", fields[3], fields[11], masked)
 
 	return isoResponse
 
 }
 
+// Return message converted to the channel's ISO8583 version with its MAC and encoding,
+// a message that can't be encoded is replaced by RC 96
+func toChannel(channel Channel, pcode string, isoParsed iso8583.IsoStruct) (iso8583.IsoStruct, string) {
+
+	// Convert message back to the channel's ISO8583 version
+	isoParsed = channel.Version.fromInternal(isoParsed)
+
+	// Add MAC and convert message to the channel's encoding
+	isoParsed, err := channel.MAC.sign(channel.Version.Spec, channel.Encoding, isoParsed)
+	var isoMessage string
+	if err == nil {
+		isoMessage, err = channel.Encoding.encode(channel.Version.Spec, isoParsed)
+	}
+	if err != nil {
+		log.Printf("Failed to encode response for channel %v. Error: %v\n", channel.Topic, err)
+		isoParsed = channel.Version.fromInternal(getIsoError(pcode, "96", "response can't be encoded for channel"))
+		isoParsed, _ = channel.MAC.sign(channel.Version.Spec, channel.Encoding, isoParsed)
+		isoMessage, _ = channel.Encoding.encode(channel.Version.Spec, isoParsed)
+	}
+	return isoParsed, isoMessage
+}
+
 // Return ISO Message by converting data from map[int]string
 func getIso(data map[int]string, mti string) (iso iso8583.IsoStruct) {
+	return buildIso(isoSpec, data, mti)
+}
+
+// Return ISO Message of a spec by converting data from map[int]string
+func buildIso(spec *Spec, data map[int]string, mti string) (iso iso8583.IsoStruct) {
 	log.Println("Converting to ISO8583...")
 
-	isoStruct := iso8583.NewISOStruct("spec1987.yml", true)
-	spec, _ := specFromFile("spec1987.yml")
+	isoStruct := spec.newIso()
 
-	if isoStruct.Mti.String() != "" {
-		log.Printf("Empty generates invalid MTI")
-	}
+	// Apply outbound length policy to field longer than its spec
+	data = outboundPolicy.apply(spec, data)
 
 	// Compare request data length and spec data length, add padding if different
 	for field, data := range data {
@@ -203,214 +361,175 @@ func getIso(data map[int]string, mti string) (iso iso8583.IsoStruct) {
 }
 
 // Return ISO message for PPOB Inquiry JSON response
-func getIsoPPOBInquiry(jsonResponse PPOBInquiryResponse) iso8583.IsoStruct {
+func getIsoPPOBInquiry(pcode string, jsonResponse PPOBInquiryResponse) iso8583.IsoStruct {
 
 	log.Println("Converting PPOB Inquiry JSON Response to ISO8583")
-	log.Printf("PPOB Inquiry Response (JSON): %v\n", jsonResponse)
+	log.Printf("PPOB Inquiry Response (JSON): %s\n", maskPolicy.json(jsonResponse))
 
-	// Assign data to map and add MTI
+	// Assign data to map using model iso tags and add MTI
 	var response map[int]string
+	var err error
 	if jsonResponse.Rc == "00" {
-		response = map[int]string{
-			4:   strconv.Itoa(jsonResponse.Tagihan),
-			5:   strconv.Itoa(jsonResponse.Admin),
-			6:   strconv.Itoa(jsonResponse.TotalTagihan),
-			37:  jsonResponse.Reffid,
-			39:  jsonResponse.Rc,
-			43:  jsonResponse.Nama,
-			48:  jsonResponse.Restime,
-			62:  jsonResponse.Data,
-			120: jsonResponse.Msg,
-			121: jsonResponse.Produk,
-			122: jsonResponse.Nopel,
-		}
+		response, err = marshalIso(jsonResponse)
 	} else {
-		response = map[int]string{
-			39:  jsonResponse.Rc,
-			48:  jsonResponse.Restime,
-			120: jsonResponse.Msg,
-		}
+		response, err = marshalIso(UnsuccessfulChipsakti{Rc: jsonResponse.Rc, Msg: jsonResponse.Msg, Restime: jsonResponse.Restime})
+	}
+	if err != nil {
+		log.Printf("Failed to map PPOBInquiryResponse to ISO8583. Error: %v\n", err)
 	}
 	mti := "0210"
 
 	// Converting request map to isoStruct
 	isoStruct := getIso(response, mti)
 
-	// Adding PAN for PPOB Inquiry Response
-	isoStruct.AddField(3, "380001")
-	isoMessage, _ := isoStruct.ToString()
-
+	// Adding processing code of the request for PPOB Inquiry Response
+	isoStruct.AddField(3, pcode)
 	log.Println("Convert Success")
-	log.Printf("PPOB Inquiry Response (ISO8583): %s\n", isoMessage)
+	log.Printf("PPOB Inquiry Response (ISO8583): %s\n", maskPolicy.iso(isoSpec, isoStruct))
 	return isoStruct
 
 }
 
 // Return ISO message for PPOB Payment JSON response
-func getIsoPPOBPayment(jsonResponse PPOBPaymentResponse) iso8583.IsoStruct {
+func getIsoPPOBPayment(pcode string, jsonResponse PPOBPaymentResponse) iso8583.IsoStruct {
 
 	log.Println("Converting PPOB Payment JSON Response to ISO8583")
-	log.Printf("PPOB Payment Response (JSON): %v\n", jsonResponse)
+	log.Printf("PPOB Payment Response (JSON): %s\n", maskPolicy.json(jsonResponse))
 
-	// Assign data to map and add MTI
-	struk := strings.Join(jsonResponse.Struk, ",")
+	// Assign data to map using model iso tags and add MTI
 	var response map[int]string
+	var err error
 	if jsonResponse.Rc == "00" {
-		response = map[int]string{
-			4:   strconv.Itoa(jsonResponse.Tagihan),
-			5:   strconv.Itoa(jsonResponse.Admin),
-			6:   strconv.Itoa(jsonResponse.TotalTagihan),
-			37:  jsonResponse.Reffid,
-			39:  jsonResponse.Rc,
-			43:  jsonResponse.Nama,
-			48:  jsonResponse.TglLunas,
-			62:  struk,
-			120: jsonResponse.Msg,
-			121: jsonResponse.Produk,
-			122: jsonResponse.Nopel,
-			123: jsonResponse.ReffNo,
-		}
+		response, err = marshalIso(jsonResponse)
 	} else {
-		response = map[int]string{
-			39:  jsonResponse.Rc,
-			48:  jsonResponse.Restime,
-			120: jsonResponse.Msg,
-		}
+		response, err = marshalIso(UnsuccessfulChipsakti{Rc: jsonResponse.Rc, Msg: jsonResponse.Msg, Restime: jsonResponse.Restime})
+	}
+	if err != nil {
+		log.Printf("Failed to map PPOBPaymentResponse to ISO8583. Error: %v\n", err)
 	}
 	mti := "0210"
 
 	// Converting request map to isoStruct
 	isoStruct := getIso(response, mti)
 
-	// Adding PAN for PPOB Payment Response
-	isoStruct.AddField(3, "810001")
-	isoMessage, _ := isoStruct.ToString()
-
+	// Adding processing code of the request for PPOB Payment Response
+	isoStruct.AddField(3, pcode)
 	log.Println("Convert Success")
-	log.Printf("PPOB Payment Response (ISO8583): %s\n", isoMessage)
+	log.Printf("PPOB Payment Response (ISO8583): %s\n", maskPolicy.iso(isoSpec, isoStruct))
 	return isoStruct
 
 }
 
 // Return ISO message for PPOB Status JSON response
-func getIsoPPOBStatus(jsonResponse PPOBStatusResponse) iso8583.IsoStruct {
+func getIsoPPOBStatus(pcode string, jsonResponse PPOBStatusResponse) iso8583.IsoStruct {
 
 	log.Println("Converting PPOB Status JSON Response to ISO8583")
-	log.Printf("PPOB Status Response (JSON): %v\n", jsonResponse)
+	log.Printf("PPOB Status Response (JSON): %s\n", maskPolicy.json(jsonResponse))
 
-	// Assign data to map and add MTI
-	struk := strings.Join(jsonResponse.Struk, ",")
+	// Assign data to map using model iso tags and add MTI
 	var response map[int]string
+	var err error
 	if jsonResponse.Rc == "00" {
-		response = map[int]string{
-			4:   strconv.Itoa(jsonResponse.Tagihan),
-			5:   strconv.Itoa(jsonResponse.Admin),
-			6:   strconv.Itoa(jsonResponse.TotalTagihan),
-			37:  jsonResponse.Reffid,
-			39:  jsonResponse.Rc,
-			43:  jsonResponse.Nama,
-			48:  jsonResponse.TglLunas,
-			62:  struk,
-			120: jsonResponse.Msg,
-			121: jsonResponse.Produk,
-			122: jsonResponse.Nopel,
-			123: jsonResponse.ReffNo,
-			124: jsonResponse.Status,
-		}
+		response, err = marshalIso(jsonResponse)
 	} else {
-		response = map[int]string{
-			39:  jsonResponse.Rc,
-			48:  jsonResponse.Restime,
-			120: jsonResponse.Msg,
-		}
+		response, err = marshalIso(UnsuccessfulChipsakti{Rc: jsonResponse.Rc, Msg: jsonResponse.Msg, Restime: jsonResponse.Restime})
+	}
+	if err != nil {
+		log.Printf("Failed to map PPOBStatusResponse to ISO8583. Error: %v\n", err)
 	}
 	mti := "0210"
 
 	// Converting request map to isoStruct
 	isoStruct := getIso(response, mti)
 
-	// Adding PAN for PPOB Status Response
-	isoStruct.AddField(3, "380002")
-	isoMessage, _ := isoStruct.ToString()
-
+	// Adding processing code of the request for PPOB Status Response
+	isoStruct.AddField(3, pcode)
 	log.Println("Convert Success")
-	log.Printf("PPOB Status Response (ISO8583): %s\n", isoMessage)
+	log.Printf("PPOB Status Response (ISO8583): %s\n", maskPolicy.iso(isoSpec, isoStruct))
 	return isoStruct
 
 }
 
 // Return ISO message for Topup Buy JSON response
-func getIsoTopupBuy(jsonResponse TopupBuyResponse) iso8583.IsoStruct {
+func getIsoTopupBuy(pcode string, jsonResponse TopupBuyResponse) iso8583.IsoStruct {
 
 	log.Println("Converting Topup Buy JSON Response to ISO8583")
-	log.Printf("Topup Buy Response (JSON): %v\n", jsonResponse)
+	log.Printf("Topup Buy Response (JSON): %s\n", maskPolicy.json(jsonResponse))
 
-	// Assign data to map and add MTI
+	// Assign data to map using model iso tags and add MTI
 	var response map[int]string
+	var err error
 	if jsonResponse.Rc == "00" {
-		response = map[int]string{
-			39:  jsonResponse.Rc,
-			48:  jsonResponse.Restime,
-			120: jsonResponse.Msg,
-			121: jsonResponse.SN,
-			122: jsonResponse.Price,
-		}
+		response, err = marshalIso(jsonResponse)
 	} else {
-		response = map[int]string{
-			39:  jsonResponse.Rc,
-			48:  jsonResponse.Restime,
-			120: jsonResponse.Msg,
-		}
+		response, err = marshalIso(UnsuccessfulChipsakti{Rc: jsonResponse.Rc, Msg: jsonResponse.Msg, Restime: jsonResponse.Restime})
+	}
+	if err != nil {
+		log.Printf("Failed to map TopupBuyResponse to ISO8583. Error: %v\n", err)
 	}
 	mti := "0210"
 
 	// Converting request map to isoStruct
 	isoStruct := getIso(response, mti)
 
-	// Adding PAN for Topup Buy Response
-	isoStruct.AddField(3, "810002")
-	isoMessage, _ := isoStruct.ToString()
-
+	// Adding processing code of the request for Topup Buy Response
+	isoStruct.AddField(3, pcode)
 	log.Println("Convert Success")
-	log.Printf("Topup Buy Response (ISO8583): %s\n", isoMessage)
+	log.Printf("Topup Buy Response (ISO8583): %s\n", maskPolicy.iso(isoSpec, isoStruct))
 	return isoStruct
 
 }
 
 // Return ISO message for Topup Check JSON response
-func getIsoTopupCheck(jsonResponse TopupCheckResponse) iso8583.IsoStruct {
+func getIsoTopupCheck(pcode string, jsonResponse TopupCheckResponse) iso8583.IsoStruct {
 
 	log.Println("Converting Topup Check JSON Response to ISO8583")
-	log.Printf("Topup Check Response (JSON): %v\n", jsonResponse)
+	log.Printf("Topup Check Response (JSON): %s\n", maskPolicy.json(jsonResponse))
 
-	// Assign data to map and add MTI
+	// Assign data to map using model iso tags and add MTI
 	var response map[int]string
+	var err error
 	if jsonResponse.Rc == "00" {
-		response = map[int]string{
-			39:  jsonResponse.Rc,
-			48:  jsonResponse.Restime,
-			120: jsonResponse.Msg,
-			121: jsonResponse.SN,
-			122: jsonResponse.Price,
-		}
+		response, err = marshalIso(jsonResponse)
 	} else {
-		response = map[int]string{
-			39:  jsonResponse.Rc,
-			48:  jsonResponse.Restime,
-			120: jsonResponse.Msg,
-		}
+		response, err = marshalIso(UnsuccessfulChipsakti{Rc: jsonResponse.Rc, Msg: jsonResponse.Msg, Restime: jsonResponse.Restime})
+	}
+	if err != nil {
+		log.Printf("Failed to map TopupCheckResponse to ISO8583. Error: %v\n", err)
 	}
 	mti := "0210"
 
 	// Converting request map to isoStruct
 	isoStruct := getIso(response, mti)
 
-	// Adding PAN for Topup Check Response
-	isoStruct.AddField(3, "380003")
-	isoMessage, _ := isoStruct.ToString()
-
+	// Adding processing code of the request for Topup Check Response
+	isoStruct.AddField(3, pcode)
 	log.Println("Convert Success")
-	log.Printf("Topup Check Response (ISO8583): %s\n", isoMessage)
+	log.Printf("Topup Check Response (ISO8583): %s\n", maskPolicy.iso(isoSpec, isoStruct))
+	return isoStruct
+
+}
+
+// Return ISO message for request that can't be sent to `Biller`
+func getIsoError(pcode string, rc string, message string) iso8583.IsoStruct {
+
+	// Assign data to map and add MTI, same fields as an unsuccessful Biller response
+	response := map[int]string{
+		39:  rc,
+		48:  time.Now().Format("2006-01-02 15:04:05"),
+		120: message,
+	}
+	mti := "0210"
+
+	// Converting request map to isoStruct
+	isoStruct := getIso(response, mti)
+
+	// Adding PAN for rejected request, unknown if the request couldn't be parsed
+	if pcode == "" {
+		pcode = "000000"
+	}
+	isoStruct.AddField(3, pcode)
+	log.Printf("Error Response (ISO8583): %s\n", maskPolicy.iso(isoSpec, isoStruct))
 	return isoStruct
 
 }
@@ -424,35 +543,8 @@ func printSortedDE(parsedMessage iso8583.IsoStruct) {
 	}
 	sort.Ints(int64toSort)
 	for _, key := range int64toSort {
-		log.Printf("[%v] : %v\n", int64(key), dataElement[int64(key)])
-	}
-}
-
-// Spec contains a structured description of an iso8583 spec
-// properly defined by a spec file
-type Spec struct {
-	fields map[int]fieldDescription
-}
-
-// readFromFile reads a yaml specfile and loads
-// and iso8583 spec from it
-func (s *Spec) readFromFile(filename string) error {
-	content, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return err
-	}
-	yaml.Unmarshal(content, &s.fields) // expecting content to be valid yaml
-	return nil
-}
-
-// SpecFromFile returns a brand new empty spec
-func specFromFile(filename string) (Spec, error) {
-	s := Spec{}
-	err := s.readFromFile(filename)
-	if err != nil {
-		return s, err
+		log.Printf("[%v] : %v\n", int64(key), maskPolicy.isoValue(key, dataElement[int64(key)]))
 	}
-	return s, nil
 }
 
 // Add pad on left of data,
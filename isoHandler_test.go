@@ -1,6 +1,15 @@
-package main
-
-import "testing"
+package kafkabiller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func TestGetIso(t *testing.T) {
 
@@ -39,9 +48,9 @@ func TestGetIsoPPOBInquiry(t *testing.T) {
 		Restime:      "2021-03-18 08:03:23",
 	}
 
-	isoRequest := getIsoPPOBInquiry(jsonRequest)
+	isoRequest := getIsoPPOBInquiry("380001", jsonRequest)
 
-	expected := "0210bc0000000a21000400000000000001c038000100000000150000000000330000000000480012345       00200HANAFI                                  0192021-03-18 08:03:230042020007approve003WOM0012"
+	expected := "0210bc0000000a21800400000000000001c038000100000000150000000000330000000000480012345       00200HANAFI                                  0192021-03-18 08:03:233600042020007approve003WOM0012"
 	result, _ := isoRequest.ToString()
 
 	if result != expected {
@@ -84,9 +93,9 @@ func TestGetIsoPPOBPayment(t *testing.T) {
 		Restime: "",
 	}
 
-	isoRequest := getIsoPPOBPayment(jsonRequest)
+	isoRequest := getIsoPPOBPayment("810001", jsonRequest)
 
-	expected := "0210bc0000000a21000400000000000001e081000100000087000000000000330000000087330012345       00200HANAFI                                  0192021-03-18 08:03:35191pembayaranWOM,,ID PEL :2,NAMA :HANAFI,REF : 5/4-3-2-1,ANGSURAN KE: 5,TAGIHAN : Rp 870000,BIAYA ADMIN : Rp 3300,TTL TAGIHAN : Rp 873300,,STRUK INI ADALAH BUKTI PEMBAYARAN YANG SAH,TERIMA KASIH007approve003WOM001200554321"
+	expected := "0210bc0000000a21800400000000000001e081000100000087000000000000330000000087330012345       00200HANAFI                                  0192021-03-18 08:03:35360216013pembayaranWOM000009ID PEL :2012NAMA :HANAFI015REF : 5/4-3-2-1014ANGSURAN KE: 5019TAGIHAN : Rp 870000021BIAYA ADMIN : Rp 3300023TTL TAGIHAN : Rp 873300000042STRUK INI ADALAH BUKTI PEMBAYARAN YANG SAH012TERIMA KASIH007approve003WOM001200554321"
 	result, _ := isoRequest.ToString()
 
 	if result != expected {
@@ -130,9 +139,9 @@ func TestGetIsoPPOBStatus(t *testing.T) {
 		Status: "payment Successfull",
 	}
 
-	isoRequest := getIsoPPOBStatus(jsonRequest)
+	isoRequest := getIsoPPOBStatus("380002", jsonRequest)
 
-	expected := "0210bc0000000a21000400000000000001f038000200000001000000000000000000000001000012345       00200HANAFI                                  0192021-03-18 08:03:38230<b>PT. MULTI ACCESS INDONESIA - CHIPSAKTI</b>,,LOKET : ZONATIK,TGL BAYAR : 02/07/2018 / 14:16:44,,STRUK PEMBAYARAN LANGGANANWOM,,IDPEL 2,NAMA : HANAFI,TTL TAGIHAN : Rp 10000,,STRUK INI ADALAH BUKTI PEMBAYARAN YANG SAH,TERIMA KASIH007approve003WOM00120040123019payment Successfull"
+	expected := "0210bc0000000a21800400000000000001f038000200000001000000000000000000000001000012345       00200HANAFI                                  0192021-03-18 08:03:38360257045<b>PT. MULTI ACCESS INDONESIA - CHIPSAKTI</b>000015LOKET : ZONATIK033TGL BAYAR : 02/07/2018 / 14:16:44000029STRUK PEMBAYARAN LANGGANANWOM000007IDPEL 2013NAMA : HANAFI022TTL TAGIHAN : Rp 10000000042STRUK INI ADALAH BUKTI PEMBAYARAN YANG SAH012TERIMA KASIH007approve003WOM00120040123019payment Successfull"
 	result, _ := isoRequest.ToString()
 
 	if result != expected {
@@ -151,10 +160,10 @@ func TestGetIsoTopupBuy(t *testing.T) {
 		Msg:     "PembelianWOMberhasil. Harga Rp. 1000",
 		Restime: "2021-03-18 08:03:42",
 		SN:      "12345678",
-		Price:   "1000",
+		Price:   1000,
 	}
 
-	isoRequest := getIsoTopupBuy(jsonRequest)
+	isoRequest := getIsoTopupBuy("810002", jsonRequest)
 
 	expected := "0210a00000000201000000000000000001c0810002002000192021-03-18 08:03:42036PembelianWOMberhasil. Harga Rp. 1000008123456780041000"
 	result, _ := isoRequest.ToString()
@@ -175,10 +184,10 @@ func TestGetIsoTopupCheck(t *testing.T) {
 		Msg:     "PembelianWOMberhasil. Harga Rp. 1000",
 		Restime: "2021-03-18 08:03:45",
 		SN:      "12345678",
-		Price:   "1000",
+		Price:   1000,
 	}
 
-	isoRequest := getIsoTopupCheck(jsonRequest)
+	isoRequest := getIsoTopupCheck("380003", jsonRequest)
 
 	expected := "0210a00000000201000000000000000001c0380003002000192021-03-18 08:03:45036PembelianWOMberhasil. Harga Rp. 1000008123456780041000"
 	result, _ := isoRequest.ToString()
@@ -189,3 +198,102 @@ func TestGetIsoTopupCheck(t *testing.T) {
 		t.Log("getIsoTopupCheck() success")
 	}
 }
+
+func TestRequestHandlerConcurrent(t *testing.T) {
+	// Inquiry is slow at the Biller, payment answers at once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/inquiry" {
+			time.Sleep(500 * time.Millisecond)
+		}
+		fmt.Fprint(w, `{"rc":"00","msg":"SUKSES"}`)
+	}))
+	defer server.Close()
+
+	defer func(env *BillerEnvironment, routes []Route) { billerEnv, routingTable = env, routes }(billerEnv, routingTable)
+	billerEnv = &BillerEnvironment{Default: "test", billers: map[string]Biller{"test": testBiller(t, "test", "chipsakti", server.URL, time.Second)}}
+	routingTable = []Route{{ProcessingCode: "380001", Handler: "ppobInquiry"}, {ProcessingCode: "810001", Handler: "ppobPayment"}}
+
+	// Responses are archived to storage/response
+	dir, _ := ioutil.TempDir("", "worker")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "storage", "response"), 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	inquiry := "0200b000000000010000000000000000000038000100000087330013020" +
+		"21                     USER01          WOM             2                        KIOS01                   2018-05-15 15:10:052020"
+	payment := "0200b000000008010000000000000000000081000100000087330012345       1262015                     USER01          WOM             2                        KIOS01                   2018-05-15 15:10:05"
+
+	requests := make(chan ConsumedMessage)
+	responses := make(chan string, 2)
+	go requestHandler(requests, responses, 2)
+	defer close(requests)
+
+	start := time.Now()
+	for _, message := range []string{inquiry, payment} {
+		frame, _ := asciiFramer{}.Frame(message, "")
+		requests <- ConsumedMessage{Topic: "worker-test", Value: frame}
+	}
+
+	var pcodes []string
+	for range []string{inquiry, payment} {
+		select {
+		case response := <-responses:
+			message, _, _ := asciiFramer{}.Unframe(response)
+			parsed, err := isoSpec.parse(message)
+			if err != nil {
+				t.Fatalf("requestHandler() failed. Response can't be parsed: %v", err)
+			}
+			pcodes = append(pcodes, parsed.Elements.GetElements()[3])
+		case <-time.After(2 * time.Second):
+			t.Fatalf("requestHandler() failed. Expected: 2 responses. Got: %v", pcodes)
+		}
+		if len(pcodes) == 1 && time.Since(start) > 300*time.Millisecond {
+			t.Errorf("requestHandler() failed. Expected: payment answered while inquiry waits. Got: first response after %v", time.Since(start))
+		}
+	}
+
+	if fmt.Sprint(pcodes) != "[810001 380001]" {
+		t.Errorf("requestHandler() failed. Expected: [810001 380001]. Got: %v", pcodes)
+	} else {
+		t.Log("requestHandler() concurrent success")
+	}
+}
+
+func TestGetResponseRemappedPcode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"rc":"00","msg":"SUKSES","nopel":"2"}`)
+	}))
+	defer server.Close()
+
+	// Inquiry served on a processing code of its own
+	defer func(env *BillerEnvironment, routes []Route) { billerEnv, routingTable = env, routes }(billerEnv, routingTable)
+	billerEnv = &BillerEnvironment{Default: "test", billers: map[string]Biller{"test": testBiller(t, "test", "chipsakti", server.URL, time.Second)}}
+	routingTable = []Route{{ProcessingCode: "380101", Handler: "ppobInquiry"}}
+	field48Layouts["380101"] = field48Layouts["380001"]
+	defer delete(field48Layouts, "380101")
+
+	dir, _ := ioutil.TempDir("", "remap")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "storage", "response"), 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	inquiry := "0200b000000000010000000000000000000038010100000087330013020" +
+		"21                     USER01          WOM             2                        KIOS01                   2018-05-15 15:10:052020"
+	frame, _ := asciiFramer{}.Frame(inquiry, "")
+	response, _, _ := asciiFramer{}.Unframe(getResponse(frame, channelFor("remap-test"), time.Now()))
+
+	result, err := isoSpec.parse(response)
+	if err != nil {
+		t.Fatalf("getResponse() failed. Response can't be parsed: %v", err)
+	}
+	fields := result.Elements.GetElements()
+	if fields[3] != "380101" || fields[39] != "00" {
+		t.Errorf("getResponse() failed. Expected: pcode 380101 rc 00. Got: pcode %v rc %v", fields[3], fields[39])
+	} else {
+		t.Log("getResponse() remapped pcode success")
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package kafkabiller
 
 import (
 	"github.com/mofax/iso8583"
@@ -13,7 +13,10 @@ func TestGetJsonPPOBInquiry(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error parsing iso message. Error: %v", err)
 	}
-	result = getJsonPPOBInquiry(iso)
+	result, err = getJsonPPOBInquiry(iso)
+	if err != nil {
+		t.Errorf("getJsonPPOBInquiry() failed. Error: %v", err)
+	}
 	var expected PPOBInquiryRequest
 
 	expected.TransactionID = "2021"
@@ -23,7 +26,6 @@ func TestGetJsonPPOBInquiry(t *testing.T) {
 	expected.MerchantCode = "KIOS01"
 	expected.RequestTime = "2018-05-15 15:10:05"
 	expected.Periode = "2020"
-	expected.Signature = "41146b35700a4f6c05bd9dfd9da52b63f308050baa61d395e4f78a7d1625d70a"
 
 	if result != expected {
 		t.Errorf("getJsonPPOBInquiry() failed. \nExpected\t: %v. Got\t: %v", expected, result)
@@ -40,7 +42,10 @@ func TestGetsonPPOBPayment(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error parsing iso message. Error: %v", err)
 	}
-	result = getJsonPPOBPayment(iso)
+	result, err = getJsonPPOBPayment(iso)
+	if err != nil {
+		t.Errorf("getJsonPPOBPayment() failed. Error: %v", err)
+	}
 	var expected PPOBPaymentRequest
 
 	expected.TransactionID = "2015"
@@ -51,7 +56,6 @@ func TestGetsonPPOBPayment(t *testing.T) {
 	expected.RequestTime = "2018-05-15 15:10:05"
 	expected.ReffID = "12345"
 	expected.Amount = 873300
-	expected.Signature = "2ba6d0fba94ea4d189af60ed83f286ada47f3d442d232458ab6ea1ff76ef93fb"
 
 	if result != expected {
 		t.Errorf("getJsonPPOBPayment() failed. \nExpected\t: %v. Got\t: %v", expected, result)
@@ -68,7 +72,10 @@ func TestGetJsonPPOBStatus(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error parsing iso message. Error: %v", err)
 	}
-	result = getJsonPPOBStatus(iso)
+	result, err = getJsonPPOBStatus(iso)
+	if err != nil {
+		t.Errorf("getJsonPPOBStatus() failed. Error: %v", err)
+	}
 	var expected PPOBStatusRequest
 
 	expected.TransactionID = "2021"
@@ -79,7 +86,6 @@ func TestGetJsonPPOBStatus(t *testing.T) {
 	expected.RequestTime = "2018-05-15 15:10:05"
 	expected.ReffID = "12345"
 	expected.Amount = 10000
-	expected.Signature = "8aa59cf131fa5022ed2c2cec39faa7f3a2a4990159d0bfb004d3af97b9265254"
 
 	if result != expected {
 		t.Errorf("getJsonPPOBStatus() failed. \nExpected\t: %v. Got\t: %v", expected, result)
@@ -96,7 +102,10 @@ func TestGetJsonTopupBuy(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error parsing iso message. Error: %v", err)
 	}
-	result = getJsonTopupBuy(iso)
+	result, err = getJsonTopupBuy(iso)
+	if err != nil {
+		t.Errorf("getJsonTopupBuy() failed. Error: %v", err)
+	}
 	var expected TopupBuyRequest
 
 	expected.TransactionID = "2021"
@@ -105,7 +114,6 @@ func TestGetJsonTopupBuy(t *testing.T) {
 	expected.CustomerNo = "1"
 	expected.MerchantCode = "KIOS01"
 	expected.RequestTime = "2018-05-15 15:10:05"
-	expected.Signature = "44b514050e89d90ab8e43ca9716670b04d5f65b7ef963112bd87e7a866c6c0f7"
 
 	if result != expected {
 		t.Errorf("getJsonTopupBuy() failed. \nExpected\t: %v. Got\t: %v", expected, result)
@@ -122,7 +130,10 @@ func TestGetJsonTopupCheck(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error parsing iso message. Error: %v", err)
 	}
-	result = getJsonTopupCheck(iso)
+	result, err = getJsonTopupCheck(iso)
+	if err != nil {
+		t.Errorf("getJsonTopupCheck() failed. Error: %v", err)
+	}
 	var expected TopupCheckRequest
 
 	expected.TransactionID = "2021"
@@ -131,7 +142,6 @@ func TestGetJsonTopupCheck(t *testing.T) {
 	expected.CustomerNo = "1"
 	expected.MerchantCode = "KIOS01"
 	expected.RequestTime = "2018-05-15 15:10:05"
-	expected.Signature = "3912621a2a919f95a55986310c7ebc4625ed31fd6d1248fb1716e66dac0979fe"
 
 	if result != expected {
 		t.Errorf("getJsonTopupCheck() failed. \nExpected\t: %v. Got\t: %v", expected, result)
@@ -139,3 +149,20 @@ func TestGetJsonTopupCheck(t *testing.T) {
 		t.Log("getJsonTopupCheck() success")
 	}
 }
+
+func TestGetJsonPPOBInquiryShortField48(t *testing.T) {
+	isoStruct := iso8583.NewISOStruct("spec1987.yml", true)
+	parsedIso := "0200a00000000001000000000000000000003800010352021                     USER01    "
+	iso, err := isoStruct.Parse(parsedIso)
+	if err != nil {
+		t.Errorf("Error parsing iso message. Error: %v", err)
+	}
+	_, err = getJsonPPOBInquiry(iso)
+	expected := "field 48 (380001): partner_id: expected 16 characters at offset 25, found 10"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("getJsonPPOBInquiry() failed. \nExpected\t: %v. Got\t: %v", expected, err)
+	} else {
+		t.Log("getJsonPPOBInquiry() success")
+	}
+}
@@ -1,7 +1,8 @@
-package main
+package kafkabiller
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"io/ioutil"
 	"log"
@@ -11,16 +12,46 @@ import (
 
 // Struct for kafkaConfig.json
 type Config struct {
-	Broker         string   `json:"broker"`
-	ProducerTopic  string   `json:"producer_topic"`
-	ConsumerTopics []string `json:"consumer_topics"`
-	Group          string   `json:"group"`
+	Broker         string                   `json:"broker"`
+	ProducerTopic  string                   `json:"producer_topic"`
+	ConsumerTopics []string                 `json:"consumer_topics"`
+	Group          string                   `json:"group"`
+	Channels       map[string]ChannelConfig `json:"channels"`
+	MACKeyFile     string                   `json:"mac_key_file"`
+	Workers        int                      `json:"workers"`
 }
 
-// Return config for setting up Kafka Producer and Consumer
-func configKafka() (broker string, producerTopic string, consumerTopics []string, group string) {
-	log.Printf("Get config for current request")
+// Requests handled at once when kafkaConfig.json doesn't set workers
+const defaultWorkers = 64
+
+// Struct for channel settings in kafkaConfig.json, keyed by consumer topic
+type ChannelConfig struct {
+	Framing  string     `json:"framing"`
+	Version  string     `json:"version"`
+	Encoding Encoding   `json:"encoding"`
+	MAC      *MACConfig `json:"mac"`
+}
+
+// Channel settings used to process events from a consumer topic
+type Channel struct {
+	Topic    string
+	Framer   Framer
+	Version  *IsoVersion
+	Encoding Encoding
+	MAC      *MACPolicy
+}
+
+// Event consumed from Kafka with the topic it came from
+type ConsumedMessage struct {
+	Topic string
+	Value string
+}
+
+// Channels by consumer topic, loaded at startup
+var channels = make(map[string]Channel)
 
+// Return content of kafkaConfig.json
+func readConfig() Config {
 	file, _ := os.Open("./kafkaConfig.json")
 	defer file.Close()
 
@@ -32,11 +63,83 @@ func configKafka() (broker string, producerTopic string, consumerTopics []string
 	var config Config
 	json.Unmarshal(b, &config)
 
+	return config
+}
+
+// Return config for setting up Kafka Producer and Consumer
+func configKafka() (broker string, producerTopic string, consumerTopics []string, group string) {
+	log.Printf("Get config for current request")
+
+	config := readConfig()
+
 	log.Printf("Kafka Config -> Broker: `%v`, Producer Topic: `%v`, Consumer Topics: `%v`, Group: `%v`",
 		config.Broker, config.ProducerTopic, config.ConsumerTopics, config.Group)
 	return config.Broker, config.ProducerTopic, config.ConsumerTopics, config.Group
 }
 
+// Return number of requests handled at once
+func configWorkers() int {
+	config := readConfig()
+	if config.Workers <= 0 {
+		return defaultWorkers
+	}
+	return config.Workers
+}
+
+// Return channel settings for every consumer topic
+func configChannels() (map[string]Channel, error) {
+	config := readConfig()
+
+	// MAC keys are only needed when a channel uses MAC
+	var macKeys map[string]MACKey
+	for _, topic := range config.ConsumerTopics {
+		if config.Channels[topic].MAC == nil || macKeys != nil {
+			continue
+		}
+		keys, err := macKeysFromFile(config.MACKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: MAC keys: %v", topic, err)
+		}
+		macKeys = keys
+	}
+
+	result := make(map[string]Channel, len(config.ConsumerTopics))
+	for _, topic := range config.ConsumerTopics {
+		framer, err := framerByName(config.Channels[topic].Framing)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %v", topic, err)
+		}
+		version, err := versionByName(config.Channels[topic].Version)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %v", topic, err)
+		}
+		encoding := config.Channels[topic].Encoding
+		if err := encoding.validate(); err != nil {
+			return nil, fmt.Errorf("channel %s: %v", topic, err)
+		}
+		var mac *MACPolicy
+		if macConfig := config.Channels[topic].MAC; macConfig != nil {
+			key, ok := macKeys[macConfig.KeyID]
+			if !ok {
+				return nil, fmt.Errorf("channel %s: unknown MAC key %q", topic, macConfig.KeyID)
+			}
+			mac = &MACPolicy{Key: key, Verify: macConfig.Verify, Generate: macConfig.Generate}
+		}
+		result[topic] = Channel{Topic: topic, Framer: framer, Version: version, Encoding: encoding, MAC: mac}
+		log.Printf("Channel Config -> Topic: `%v`, Framing: `%v`, Version: `%v`, Encoding: `%v`, MAC: `%v`",
+			topic, config.Channels[topic].Framing, version.Name, encoding, mac)
+	}
+	return result, nil
+}
+
+// Return channel settings for topic, unknown topic uses default settings
+func channelFor(topic string) Channel {
+	if channel, ok := channels[topic]; ok {
+		return channel
+	}
+	return Channel{Topic: topic, Framer: asciiFramer{}, Version: isoVersions["1987"]}
+}
+
 func producer(wg *sync.WaitGroup, broker string, topic string, message <-chan string) {
 	log.Println("Producer started!")
 
@@ -57,7 +160,7 @@ func producer(wg *sync.WaitGroup, broker string, topic string, message <-chan st
 				if ev.TopicPartition.Error != nil {
 					log.Printf("Produce failed: %v\n", ev.TopicPartition)
 				} else {
-					log.Printf("Produced message to %v. Message: %s (Header: %s)\n", ev.TopicPartition, ev.Value, ev.Headers)
+					log.Printf("Produced message to %v. Message: %s (Header: %s)\n", ev.TopicPartition, maskPolicy.raw(string(ev.Value)), ev.Headers)
 				}
 			}
 		}
@@ -100,6 +203,8 @@ func consumer(broker string, topics []string, group string) {
 		panic(err)
 	}
 
+	defer c.Close()
+
 	// Subscribe to topics
 	c.SubscribeTopics(topics, nil)
 
@@ -107,14 +212,12 @@ func consumer(broker string, topics []string, group string) {
 		msg, err := c.ReadMessage(-1)
 		if err == nil {
 			log.Println("New Request from Kafka")
-			log.Printf("Message consumed on %s: %s\n", msg.TopicPartition, string(msg.Value))
+			log.Printf("Message consumed on %s: %s\n", msg.TopicPartition, maskPolicy.raw(string(msg.Value)))
 
 			// Send any consumed event to consumerChan
-			consumerChan <- string(msg.Value)
+			consumerChan <- ConsumedMessage{Topic: *msg.TopicPartition.Topic, Value: string(msg.Value)}
 		} else {
-			log.Printf("Consumer error: %v (%v)\n", err, msg)
+			log.Printf("Consumer error: %v\n", err)
 		}
 	}
-
-	c.Close()
 }
@@ -1,4 +1,4 @@
-package main
+package kafkabiller
 
 import "testing"
 
@@ -45,3 +45,16 @@ func TestConfigKafka(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigChannels(t *testing.T) {
+	result, err := configChannels()
+	if err != nil {
+		t.Errorf("configChannels() failed. Error: %v", err)
+	}
+
+	if _, ok := result["goroutine-channel"].Framer.(asciiFramer); !ok {
+		t.Errorf("configChannels() failed. Expected ascii4 framing. Got: %T", result["goroutine-channel"].Framer)
+	} else {
+		t.Log("configChannels() success")
+	}
+}
@@ -0,0 +1,414 @@
+package kafkabiller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Daily quota counters of every Biller, kept in storage/quota.json so a restart doesn't reset them,
+// set up at startup before the Biller environment
+var dailyQuotas *quotaStore
+
+// How long quota counters may stay unsaved after a change
+const quotaSaveDelay = time.Second
+
+// Rate limits and daily quotas in biller.yml, keyed by endpoint path or partner_id. A "default"
+// entry applies to every endpoint or partner without its own, each of them gets its own bucket
+// and counter.
+type BillerLimits struct {
+	Rate              map[string]RateLimit `yaml:"Rate"`
+	PartnerRate       map[string]RateLimit `yaml:"PartnerRate"`
+	MaxWait           time.Duration        `yaml:"MaxWait"`
+	DailyQuota        map[string]int       `yaml:"DailyQuota"`
+	PartnerDailyQuota map[string]int       `yaml:"PartnerDailyQuota"`
+}
+
+// Token bucket settings, Burst calls may be made at once and PerSecond calls are added back every second
+type RateLimit struct {
+	PerSecond float64 `yaml:"PerSecond"`
+	Burst     int     `yaml:"Burst"`
+}
+
+// Daily quota of an endpoint or partner, returned by GET /quotas
+type QuotaStatus struct {
+	Biller    string `json:"biller"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	Partner   string `json:"partner,omitempty"`
+	Date      string `json:"date"`
+	Used      int    `json:"used"`
+	Limit     int    `json:"limit"`
+	Remaining int    `json:"remaining"`
+}
+
+// tokenBucket limits the rate of calls, safe for concurrent use
+type tokenBucket struct {
+	limit RateLimit
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// billerLimiter holds the rate limits of a Biller's endpoints and partners, safe for concurrent use
+type billerLimiter struct {
+	biller string
+	limits BillerLimits
+	quotas *quotaStore
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// quotaStore counts calls per day and saves the counters to a file, safe for concurrent use
+type quotaStore struct {
+	filename string
+
+	mu      sync.Mutex
+	saving  bool
+	content quotaFile
+}
+
+// Content of the quota file
+type quotaFile struct {
+	Date   string         `json:"date"`
+	Counts map[string]int `json:"counts"`
+}
+
+// Check that the limits can be used
+func (l BillerLimits) validate() error {
+	for _, rates := range []map[string]RateLimit{l.Rate, l.PartnerRate} {
+		for key, rate := range rates {
+			if rate.PerSecond <= 0 || rate.Burst <= 0 {
+				return fmt.Errorf("rate %s: PerSecond and Burst must be positive", key)
+			}
+		}
+	}
+	for endpoint := range l.Rate {
+		if endpoint != "default" && !strings.HasPrefix(endpoint, "/") {
+			return fmt.Errorf("rate %s: must be default or an endpoint path", endpoint)
+		}
+	}
+	for endpoint, quota := range l.DailyQuota {
+		if endpoint != "default" && !strings.HasPrefix(endpoint, "/") {
+			return fmt.Errorf("daily quota %s: must be default or an endpoint path", endpoint)
+		}
+		if quota <= 0 {
+			return fmt.Errorf("daily quota %s: must be positive", endpoint)
+		}
+	}
+	for partner, quota := range l.PartnerDailyQuota {
+		if quota <= 0 {
+			return fmt.Errorf("partner daily quota %s: must be positive", partner)
+		}
+	}
+	if l.MaxWait < 0 {
+		return fmt.Errorf("max wait can't be negative")
+	}
+	return nil
+}
+
+// Return limit of a key, falling back to "default"
+func rateLimitOf(limits map[string]RateLimit, key string) (RateLimit, bool) {
+	if limit, ok := limits[key]; ok {
+		return limit, true
+	}
+	limit, ok := limits["default"]
+	return limit, ok
+}
+
+// Return quota of a key, falling back to "default", 0 is no quota
+func quotaOf(quotas map[string]int, key string) int {
+	if quota, ok := quotas[key]; ok {
+		return quota
+	}
+	return quotas["default"]
+}
+
+// Return new token bucket, full
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+}
+
+// reserve takes a token and returns how long to wait before using it, false if that would be
+// longer than maxWait and nothing was taken
+func (b *tokenBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.After(b.last) {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.PerSecond)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+
+	wait := time.Duration((1 - b.tokens) / b.limit.PerSecond * float64(time.Second))
+	if wait > maxWait {
+		return 0, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// cancel gives back a reserved token
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+1)
+}
+
+// Return new limiter of a Biller counting its quotas in quotas
+func newBillerLimiter(biller string, limits BillerLimits, quotas *quotaStore) *billerLimiter {
+	return &billerLimiter{biller: biller, limits: limits, quotas: quotas, buckets: make(map[string]*tokenBucket)}
+}
+
+// Return token bucket of a key, created on its first call
+func (l *billerLimiter) bucket(key string, limit RateLimit) *tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = newTokenBucket(limit)
+		l.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// Return quota counter key of an endpoint or partner
+func (l *billerLimiter) endpointKey(endpoint string) string {
+	return l.biller + " endpoint " + endpoint
+}
+
+func (l *billerLimiter) partnerKey(partnerID string) string {
+	return l.biller + " partner " + partnerID
+}
+
+// acquire counts a call against the daily quotas and waits for the rate limits of the endpoint and
+// partner. release must be called if the call isn't sent after all, it gives back the quotas and
+// rate limit tokens. A call over quota or one that would wait longer than MaxWait is refused with a
+// BillerError.
+func (l *billerLimiter) acquire(endpoint string, partnerID string) (release func(), err error) {
+	var keys []string
+	var quotas []int
+	if quota := quotaOf(l.limits.DailyQuota, endpoint); quota > 0 {
+		keys, quotas = append(keys, l.endpointKey(endpoint)), append(quotas, quota)
+	}
+	if quota := quotaOf(l.limits.PartnerDailyQuota, partnerID); quota > 0 && partnerID != "" {
+		keys, quotas = append(keys, l.partnerKey(partnerID)), append(quotas, quota)
+	}
+	if exceeded, ok := l.quotas.take(time.Now(), keys, quotas); !ok {
+		metrics.inc("biller_rejected", "biller", l.biller, "endpoint", endpoint, "reason", "quota")
+		return nil, &BillerError{Kind: billerQuotaExceeded, Biller: l.biller, Endpoint: endpoint, Err: fmt.Errorf("daily quota of %s used up", exceeded)}
+	}
+	releaseQuotas := func() { l.quotas.release(time.Now(), keys) }
+
+	// Every limit has to let the call through, tokens taken from the others are given back otherwise
+	var buckets []*tokenBucket
+	var wait time.Duration
+	now := time.Now()
+	if limit, ok := rateLimitOf(l.limits.Rate, endpoint); ok {
+		buckets = append(buckets, l.bucket("endpoint "+endpoint, limit))
+	}
+	if limit, ok := rateLimitOf(l.limits.PartnerRate, partnerID); ok && partnerID != "" {
+		buckets = append(buckets, l.bucket("partner "+partnerID, limit))
+	}
+	for i, bucket := range buckets {
+		bucketWait, ok := bucket.reserve(now, l.limits.MaxWait)
+		if !ok {
+			for _, taken := range buckets[:i] {
+				taken.cancel()
+			}
+			releaseQuotas()
+			metrics.inc("biller_rejected", "biller", l.biller, "endpoint", endpoint, "reason", "rate_limit")
+			return nil, &BillerError{Kind: billerRateLimited, Biller: l.biller, Endpoint: endpoint, Err: fmt.Errorf("rate limit, no call possible within %v", l.limits.MaxWait)}
+		}
+		if bucketWait > wait {
+			wait = bucketWait
+		}
+	}
+	if wait > 0 {
+		log.Printf("Call to %s %s waits %v for the rate limit\n", l.biller, endpoint, wait)
+		time.Sleep(wait)
+	}
+	return func() {
+		for _, taken := range buckets {
+			taken.cancel()
+		}
+		releaseQuotas()
+	}, nil
+}
+
+// status returns the daily quotas of the Biller that are configured or have been used
+func (l *billerLimiter) status() []QuotaStatus {
+	date, counts := l.quotas.snapshot(time.Now())
+
+	endpoints := make(map[string]bool)
+	partners := make(map[string]bool)
+	for endpoint := range l.limits.DailyQuota {
+		if endpoint != "default" {
+			endpoints[endpoint] = true
+		}
+	}
+	for partner := range l.limits.PartnerDailyQuota {
+		if partner != "default" {
+			partners[partner] = true
+		}
+	}
+	for key := range counts {
+		switch {
+		case strings.HasPrefix(key, l.endpointKey("")):
+			endpoints[strings.TrimPrefix(key, l.endpointKey(""))] = true
+		case strings.HasPrefix(key, l.partnerKey("")):
+			partners[strings.TrimPrefix(key, l.partnerKey(""))] = true
+		}
+	}
+
+	result := make([]QuotaStatus, 0, len(endpoints)+len(partners))
+	for endpoint := range endpoints {
+		if limit := quotaOf(l.limits.DailyQuota, endpoint); limit > 0 {
+			used := counts[l.endpointKey(endpoint)]
+			result = append(result, QuotaStatus{Biller: l.biller, Endpoint: endpoint, Date: date, Used: used, Limit: limit, Remaining: limit - used})
+		}
+	}
+	for partner := range partners {
+		if limit := quotaOf(l.limits.PartnerDailyQuota, partner); limit > 0 {
+			used := counts[l.partnerKey(partner)]
+			result = append(result, QuotaStatus{Biller: l.biller, Partner: partner, Date: date, Used: used, Limit: limit, Remaining: limit - used})
+		}
+	}
+	// Endpoints first, then partners
+	sort.Slice(result, func(i, j int) bool {
+		if (result[i].Endpoint == "") != (result[j].Endpoint == "") {
+			return result[i].Endpoint != ""
+		}
+		return result[i].Endpoint+result[i].Partner < result[j].Endpoint+result[j].Partner
+	})
+	return result
+}
+
+// Return new quota store without counters, saved to filename
+func newQuotaStore(filename string) *quotaStore {
+	return &quotaStore{filename: filename}
+}
+
+// Return quota store with the counters saved in a file, a missing file has no counters. A file that
+// can't be read is an error so a restart never resets the quotas
+func quotaStoreFromFile(filename string) (*quotaStore, error) {
+	store := newQuotaStore(filename)
+
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &store.content); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return store, nil
+}
+
+// Start a new day if the date changed, must be called with the lock held
+func (s *quotaStore) prepare(now time.Time) {
+	today := now.Format("2006-01-02")
+	if s.content.Date != today || s.content.Counts == nil {
+		s.content = quotaFile{Date: today, Counts: make(map[string]int)}
+	}
+}
+
+// take counts a call against every key, nothing is counted if a key would go over its quota
+func (s *quotaStore) take(now time.Time, keys []string, quotas []int) (exceeded string, ok bool) {
+	if len(keys) == 0 {
+		return "", true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prepare(now)
+
+	for i, key := range keys {
+		if s.content.Counts[key] >= quotas[i] {
+			return key, false
+		}
+	}
+	for _, key := range keys {
+		s.content.Counts[key]++
+	}
+	s.scheduleSave()
+	return "", true
+}
+
+// release takes back a call that wasn't sent
+func (s *quotaStore) release(now time.Time, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prepare(now)
+
+	for _, key := range keys {
+		if s.content.Counts[key] > 0 {
+			s.content.Counts[key]--
+		}
+	}
+	s.scheduleSave()
+}
+
+// snapshot returns the date and a copy of the counters
+func (s *quotaStore) snapshot(now time.Time) (string, map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prepare(now)
+
+	counts := make(map[string]int, len(s.content.Counts))
+	for key, count := range s.content.Counts {
+		counts[key] = count
+	}
+	return s.content.Date, counts
+}
+
+// Save the counters shortly, changes until then are saved together; must be called with the lock held
+func (s *quotaStore) scheduleSave() {
+	if s.saving {
+		return
+	}
+	s.saving = true
+	time.AfterFunc(quotaSaveDelay, func() {
+		if err := s.save(); err != nil {
+			log.Printf("Failed to save quota counters to %s. Error: %v\n", s.filename, err)
+		}
+	})
+}
+
+// save writes the counters to the file, replacing it at once so a crash leaves the old or new counters.
+// The lock is held while writing so an older save can't overwrite a newer one
+func (s *quotaStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saving = false
+	content, err := json.MarshalIndent(s.content, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(s.filename, content)
+}
+
+// Return daily quota of every Biller endpoint and partner
+func getQuotas(w http.ResponseWriter, r *http.Request) {
+	jsonFormatter(w, billerEnv.quotaStatus(), http.StatusOK)
+}
@@ -0,0 +1,204 @@
+package kafkabiller
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(RateLimit{PerSecond: 10, Burst: 2})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if wait, ok := bucket.reserve(now, 0); !ok || wait != 0 {
+			t.Errorf("tokenBucket.reserve() %d failed. Expected: no wait within burst. Got: %v %v", i, wait, ok)
+		}
+	}
+	if _, ok := bucket.reserve(now, 50*time.Millisecond); ok {
+		t.Errorf("tokenBucket.reserve() failed. Expected: refused past MaxWait. Got: ok")
+	}
+	if wait, ok := bucket.reserve(now, 200*time.Millisecond); !ok || wait != 100*time.Millisecond {
+		t.Errorf("tokenBucket.reserve() failed. Expected: wait 100ms. Got: %v %v", wait, ok)
+	}
+
+	// Tokens come back with time
+	if wait, ok := bucket.reserve(now.Add(300*time.Millisecond), 0); !ok || wait != 0 {
+		t.Errorf("tokenBucket.reserve() failed. Expected: refilled bucket. Got: %v %v", wait, ok)
+	} else {
+		t.Log("tokenBucket.reserve() success")
+	}
+}
+
+func TestBillerLimiter(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "quota")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "storage", "quota.json")
+
+	limits := BillerLimits{
+		DailyQuota:        map[string]int{"/buy": 2},
+		PartnerDailyQuota: map[string]int{"default": 1},
+		Rate:              map[string]RateLimit{"/inquiry": {PerSecond: 1, Burst: 1}},
+	}
+	if err := limits.validate(); err != nil {
+		t.Fatalf("BillerLimits.validate() failed. Error: %v", err)
+	}
+	limiter := newBillerLimiter("test", limits, newQuotaStore(filename))
+
+	// Partner quota, then endpoint quota
+	tests := []struct {
+		partner string
+		rc      string
+	}{
+		{"P1", ""}, {"P1", "65"}, {"P2", ""}, {"P3", "65"},
+	}
+	for i, test := range tests {
+		_, err := limiter.acquire("/buy", test.partner)
+		if (err == nil) != (test.rc == "") || (err != nil && billerResponseCode(err) != test.rc) {
+			t.Errorf("billerLimiter.acquire() %d failed. Expected: %q. Got: %v", i, test.rc, err)
+		}
+	}
+
+	// A call that isn't sent gives back its quota
+	limiter.quotas.release(time.Now(), []string{limiter.endpointKey("/buy"), limiter.partnerKey("P2")})
+	if _, err := limiter.acquire("/buy", "P3"); err != nil {
+		t.Errorf("billerLimiter.acquire() failed. Expected: quota released. Got: %v", err)
+	}
+
+	// Rate limit without waiting, a call that isn't sent, e.g. refused by the circuit breaker,
+	// gives back its token
+	release, err := limiter.acquire("/inquiry", "")
+	if err != nil {
+		t.Fatalf("billerLimiter.acquire() failed. Expected: first call within burst. Got: %v", err)
+	}
+	release()
+	if _, err := limiter.acquire("/inquiry", ""); err != nil {
+		t.Errorf("billerLimiter.acquire() failed. Expected: token released. Got: %v", err)
+	}
+	if _, err := limiter.acquire("/inquiry", ""); billerResponseCode(err) != "91" {
+		t.Errorf("billerLimiter.acquire() failed. Expected: rate limited with RC 91. Got: %v", err)
+	}
+
+	status := limiter.status()
+	if len(status) != 4 || status[0].Endpoint != "/buy" || status[0].Used != 2 || status[0].Remaining != 0 {
+		t.Errorf("billerLimiter.status() failed. Expected: /buy used up and 3 partners. Got: %+v", status)
+	} else {
+		t.Log("billerLimiter success")
+	}
+}
+
+func TestQuotaStorePersist(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "quota")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "storage", "quota.json")
+	now := time.Now()
+
+	store := newQuotaStore(filename)
+	store.take(now, []string{"test endpoint /buy"}, []int{10})
+	store.take(now, []string{"test endpoint /buy"}, []int{10})
+	if err := store.save(); err != nil {
+		t.Fatalf("quotaStore.save() failed. Error: %v", err)
+	}
+
+	// Counters survive a restart
+	reloaded, err := quotaStoreFromFile(filename)
+	if err != nil {
+		t.Fatalf("quotaStoreFromFile() failed. Error: %v", err)
+	}
+	_, counts := reloaded.snapshot(now)
+	if counts["test endpoint /buy"] != 2 {
+		t.Errorf("quotaStore.snapshot() failed. Expected: 2 after restart. Got: %v", counts)
+	}
+
+	// and start from zero on the next day
+	date, counts := reloaded.snapshot(now.Add(24 * time.Hour))
+	if counts["test endpoint /buy"] != 0 || date != now.Add(24*time.Hour).Format("2006-01-02") {
+		t.Errorf("quotaStore.snapshot() failed. Expected: new day without counts. Got: %v %v", date, counts)
+	} else {
+		t.Log("quotaStore persist success")
+	}
+}
+
+func TestQuotaStoreFromFileCorrupt(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "quota")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "quota.json")
+
+	if store, err := quotaStoreFromFile(filename); err != nil || store == nil {
+		t.Errorf("quotaStoreFromFile() failed. Expected: empty store without file. Got: %v", err)
+	}
+
+	// Counters that can't be read stop the service instead of starting from zero
+	ioutil.WriteFile(filename, []byte(`{"date": "2026-10-19", "counts": {`), 0644)
+	if _, err := quotaStoreFromFile(filename); err == nil {
+		t.Errorf("quotaStoreFromFile() failed. Expected: error for corrupt file. Got: nil")
+	} else {
+		t.Log("quotaStoreFromFile() corrupt file success")
+	}
+}
+
+func TestQuotaStoreSaveConcurrent(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "quota")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "storage", "quota.json")
+	now := time.Now()
+
+	store := newQuotaStore(filename)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.take(now, []string{"test endpoint /buy"}, []int{100})
+			if err := store.save(); err != nil {
+				t.Errorf("quotaStore.save() failed. Error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	reloaded, err := quotaStoreFromFile(filename)
+	if err != nil {
+		t.Fatalf("quotaStoreFromFile() failed. Error: %v", err)
+	}
+	_, counts := reloaded.snapshot(now)
+	files, _ := ioutil.ReadDir(filepath.Dir(filename))
+	if counts["test endpoint /buy"] != 20 || len(files) != 1 {
+		t.Errorf("quotaStore.save() failed. Expected: 20 calls in one file. Got: %v in %d files", counts, len(files))
+	} else {
+		t.Log("quotaStore concurrent save success")
+	}
+}
+
+func TestBillerLimiterNotSent(t *testing.T) {
+	// Nothing listens on port 1, the call fails to dial
+	conn := &BillerConnection{Name: "quota-not-sent", Type: "chipsakti", BaseURL: "http://127.0.0.1:1", ConnectTimeout: time.Second,
+		ReadTimeout: time.Second, Signature: testSignature(), Limits: BillerLimits{
+			DailyQuota: map[string]int{"/buy": 5},
+			Rate:       map[string]RateLimit{"/buy": {PerSecond: 0.001, Burst: 1}},
+		}}
+	if err := conn.validate("test"); err != nil {
+		t.Fatalf("BillerConnection.validate() failed. Error: %v", err)
+	}
+	conn.connect()
+	biller := &chipsaktiBiller{conn: conn}
+
+	for i := 0; i < 2; i++ {
+		_, err := biller.TopupBuy(TopupBuyRequest{TransactionID: "1", CustomerNo: "081234567890"})
+		var billerErr *BillerError
+		if !errors.As(err, &billerErr) || billerErr.Kind != billerConnectFailed || !billerNotSent(err) {
+			t.Fatalf("chipsaktiBiller.TopupBuy() %d failed. Expected: %v error within rate limit. Got: %v", i, billerConnectFailed, err)
+		}
+	}
+
+	status := conn.limiter.status()
+	if len(status) != 1 || status[0].Used != 0 {
+		t.Errorf("billerLimiter.status() failed. Expected: quota unused after failed dials. Got: %+v", status)
+	} else {
+		t.Log("billerLimiter not sent success")
+	}
+}
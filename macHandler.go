@@ -0,0 +1,227 @@
+package kafkabiller
+
+import (
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/mofax/iso8583"
+)
+
+// Struct for channel MAC settings in kafkaConfig.json
+type MACConfig struct {
+	KeyID    string `json:"key_id"`
+	Verify   bool   `json:"verify"`
+	Generate bool   `json:"generate"`
+}
+
+// Struct for a key in the MAC key file, the key is in the file or in an environment variable
+type MACKey struct {
+	Algorithm string `json:"algorithm"` // "x9.19" (ISO 9797-1 algorithm 3) or "hmac-sha256"
+	Key       string `json:"key"`       // hex encoded
+	KeyEnv    string `json:"key_env"`   // environment variable holding the hex encoded key
+}
+
+// Struct for the MAC key file
+type macKeyFile struct {
+	Keys map[string]MACKey `json:"keys"`
+}
+
+// MAC settings of a channel, nil if the channel doesn't use MAC
+type MACPolicy struct {
+	Key      MACKey
+	Verify   bool
+	Generate bool
+}
+
+// String describes the policy without its key, so it can be logged
+func (p *MACPolicy) String() string {
+	if p == nil {
+		return "none"
+	}
+	return fmt.Sprintf("algorithm=%s verify=%t generate=%t", p.Key.Algorithm, p.Verify, p.Generate)
+}
+
+// MACError happens when an inbound MAC is missing or doesn't match
+type MACError struct {
+	Reason string
+}
+
+func (e *MACError) Error() string {
+	return "MAC verification failed: " + e.Reason
+}
+
+// Return MAC keys by ID from a json file
+func macKeysFromFile(filename string) (map[string]MACKey, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var file macKeyFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for id, key := range file.Keys {
+		if (key.Key == "") == (key.KeyEnv == "") {
+			return nil, fmt.Errorf("%s: key %s: needs either key or key_env", filename, id)
+		}
+		if key.KeyEnv != "" {
+			key.Key = os.Getenv(key.KeyEnv)
+			if key.Key == "" {
+				return nil, fmt.Errorf("%s: key %s: environment variable %s is not set", filename, id, key.KeyEnv)
+			}
+			file.Keys[id] = key
+		}
+		if err := key.validate(); err != nil {
+			return nil, fmt.Errorf("%s: key %s: %v", filename, id, err)
+		}
+	}
+	return file.Keys, nil
+}
+
+// Check that key can be used by its algorithm
+func (k MACKey) validate() error {
+	key, err := hex.DecodeString(k.Key)
+	if err != nil {
+		return fmt.Errorf("key is not hex encoded")
+	}
+
+	switch k.Algorithm {
+	case "x9.19":
+		if len(key) != 16 {
+			return fmt.Errorf("x9.19 needs a 16 byte double-length DES key, found %d bytes", len(key))
+		}
+	case "hmac-sha256":
+		if len(key) < 16 {
+			return fmt.Errorf("hmac-sha256 needs a key of at least 16 bytes, found %d bytes", len(key))
+		}
+	default:
+		return fmt.Errorf("unknown algorithm %q", k.Algorithm)
+	}
+	return nil
+}
+
+// compute returns the MAC of data as 8 uppercase hex characters (first 4 bytes of the MAC)
+func (k MACKey) compute(data string) (string, error) {
+	key, err := hex.DecodeString(k.Key)
+	if err != nil {
+		return "", err
+	}
+
+	var mac []byte
+	switch k.Algorithm {
+	case "x9.19":
+		mac, err = retailMAC(key, []byte(data))
+		if err != nil {
+			return "", err
+		}
+	case "hmac-sha256":
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		mac = h.Sum(nil)
+	default:
+		return "", fmt.Errorf("unknown algorithm %q", k.Algorithm)
+	}
+	return strings.ToUpper(hex.EncodeToString(mac[:4])), nil
+}
+
+// Return ANSI X9.19 / ISO 9797-1 algorithm 3 MAC, data padded with zeros (padding method 1)
+func retailMAC(key []byte, data []byte) ([]byte, error) {
+	k1, err := des.NewCipher(key[:8])
+	if err != nil {
+		return nil, err
+	}
+	k2, err := des.NewCipher(key[8:16])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data)%8 != 0 || len(data) == 0 {
+		data = append(data, make([]byte, 8-len(data)%8)...)
+	}
+
+	// CBC with single DES under K1
+	block := make([]byte, 8)
+	for i := 0; i < len(data); i += 8 {
+		for j := 0; j < 8; j++ {
+			block[j] ^= data[i+j]
+		}
+		k1.Encrypt(block, block)
+	}
+
+	// Output transformation: decrypt under K2, encrypt under K1
+	k2.Decrypt(block, block)
+	k1.Encrypt(block, block)
+	return block, nil
+}
+
+// Return MAC field of a message, 128 if it has a secondary bitmap or 64 otherwise
+func macField(iso iso8583.IsoStruct) int64 {
+	if len(iso.Bitmap) > 64 && iso.Bitmap[0] == 1 {
+		return 128
+	}
+	return 64
+}
+
+// verify checks the MAC of an inbound message. wire is the message in the channel encoding as it
+// was received inside its frame, the MAC covers every byte before the MAC field
+func (p *MACPolicy) verify(spec *Spec, encoding Encoding, wire string, iso iso8583.IsoStruct) error {
+	if p == nil || !p.Verify {
+		return nil
+	}
+
+	field := macField(iso)
+	received, ok := iso.Elements.GetElements()[field]
+	if !ok {
+		return &MACError{fmt.Sprintf("field %d is missing", field)}
+	}
+	encoded, err := encoding.writeField(spec.fields[int(field)].ContentType, received)
+	if err != nil {
+		return &MACError{fmt.Sprintf("field %d: %v", field, err)}
+	}
+	if !strings.HasSuffix(wire, encoded) {
+		return &MACError{fmt.Sprintf("field %d must be the last field", field)}
+	}
+
+	expected, err := p.Key.compute(wire[:len(wire)-len(encoded)])
+	if err != nil {
+		return &MACError{err.Error()}
+	}
+	if !hmac.Equal([]byte(strings.ToUpper(received)), []byte(expected)) {
+		return &MACError{fmt.Sprintf("field %d doesn't match", field)}
+	}
+	return nil
+}
+
+// sign adds the MAC field to an outbound message, computed over the message in the channel encoding
+func (p *MACPolicy) sign(spec *Spec, encoding Encoding, iso iso8583.IsoStruct) (iso8583.IsoStruct, error) {
+	if p == nil || !p.Generate {
+		return iso, nil
+	}
+
+	// MAC covers the encoded message with the MAC bit set, up to the MAC field
+	field := macField(iso)
+	iso.AddField(field, "00000000")
+	wire, err := encoding.encode(spec, iso)
+	if err != nil {
+		return iso, err
+	}
+	placeholder, err := encoding.writeField(spec.fields[int(field)].ContentType, "00000000")
+	if err != nil {
+		return iso, err
+	}
+
+	mac, err := p.Key.compute(wire[:len(wire)-len(placeholder)])
+	if err != nil {
+		return iso, err
+	}
+	iso.AddField(field, mac)
+	return iso, nil
+}
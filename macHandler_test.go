@@ -0,0 +1,120 @@
+package kafkabiller
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestRetailMAC(t *testing.T) {
+	// X9.19 with K1 = K2 is the single DES CBC-MAC of ANSI X9.9
+	key := MACKey{Algorithm: "x9.19", Key: "0123456789ABCDEF0123456789ABCDEF"}
+	expected := "F1D30F68"
+
+	result, err := key.compute("7654321 Now is the time for ")
+	if err != nil || result != expected {
+		t.Errorf("MACKey.compute() failed. Expected: %v. Got: %v (%v)", expected, result, err)
+	} else {
+		t.Log("MACKey.compute() success")
+	}
+}
+
+func TestMACSignVerify(t *testing.T) {
+	os.Setenv("CHIPSAKTI_MAC_KEY", "0123456789ABCDEF0123456789ABCDEF")
+	defer os.Unsetenv("CHIPSAKTI_MAC_KEY")
+	keys, err := macKeysFromFile("macKeys.example.json")
+	if err != nil {
+		t.Fatalf("macKeysFromFile() failed. Error: %v", err)
+	}
+
+	for id, key := range keys {
+		policy := &MACPolicy{Key: key, Verify: true, Generate: true}
+
+		iso := buildIso(isoSpec, map[int]string{3: "380001", 39: "00", 120: "OK"}, "0210")
+		iso, err := policy.sign(isoSpec, Encoding{}, iso)
+		if err != nil {
+			t.Errorf("%v: MACPolicy.sign() failed. Error: %v", id, err)
+			continue
+		}
+		message, _ := iso.ToString()
+
+		parsed, _ := isoSpec.parse(message)
+		if err := policy.verify(isoSpec, Encoding{}, message, parsed); err != nil {
+			t.Errorf("%v: MACPolicy.verify() failed. Error: %v", id, err)
+		}
+
+		// Changing any character before the MAC must be detected
+		tampered := message[:len(message)-10] + "KO" + message[len(message)-8:]
+		parsed, _ = isoSpec.parse(tampered)
+		var macErr *MACError
+		if err := policy.verify(isoSpec, Encoding{}, tampered, parsed); !errors.As(err, &macErr) {
+			t.Errorf("%v: MACPolicy.verify() of tampered message failed. Expected MACError. Got: %v", id, err)
+		}
+	}
+	t.Log("MACPolicy sign and verify success")
+}
+
+func TestMACKeyEnvMissing(t *testing.T) {
+	os.Unsetenv("CHIPSAKTI_MAC_KEY")
+	_, err := macKeysFromFile("macKeys.example.json")
+	expected := "macKeys.example.json: key production: environment variable CHIPSAKTI_MAC_KEY is not set"
+	if err == nil || err.Error() != expected {
+		t.Errorf("macKeysFromFile() failed. Expected: %v. Got: %v", expected, err)
+	} else {
+		t.Log("macKeysFromFile() key_env success")
+	}
+}
+
+func TestMACMissing(t *testing.T) {
+	policy := &MACPolicy{Key: MACKey{Algorithm: "x9.19", Key: "0123456789ABCDEFFEDCBA9876543210"}, Verify: true}
+	// Primary bitmap only, MAC belongs in field 64
+	message := "0200" + "2000000000000000" + "380001"
+	parsed, _ := isoSpec.parse(message)
+	expected := "MAC verification failed: field 64 is missing"
+
+	if err := policy.verify(isoSpec, Encoding{}, message, parsed); err == nil || err.Error() != expected {
+		t.Errorf("MACPolicy.verify() failed. Expected: %v. Got: %v", expected, err)
+	} else {
+		t.Log("MACPolicy.verify() success")
+	}
+}
+
+func TestMACEncodedChannel(t *testing.T) {
+	key := MACKey{Algorithm: "x9.19", Key: "0123456789ABCDEFFEDCBA9876543210"}
+	version, _ := versionByName("1987")
+	channel := Channel{Topic: "bcd", Framer: binaryFramer{}, Version: version, Encoding: Encoding{Binary: "raw", Numeric: "bcd"},
+		MAC: &MACPolicy{Key: key, Verify: true, Generate: true}}
+
+	// Response as the channel receives it, the MAC covers the BCD bytes before the 4 byte MAC
+	response, wire := toChannel(channel, "380001", getIso(map[int]string{3: "380001", 39: "00", 120: "OK"}, "0210"))
+	mac := response.Elements.GetElements()[macField(response)]
+	expected, _ := key.compute(wire[:len(wire)-4])
+	if mac == "" || mac != expected || wire[len(wire)-4:] != string(mustDecodeHex(t, mac)) {
+		t.Fatalf("MACPolicy.sign() failed. Expected: MAC %v of the encoded message at its end. Got: %v", expected, mac)
+	}
+
+	// The channel's request is checked over the same bytes
+	message, err := channel.Encoding.decode(isoSpec, wire)
+	if err != nil {
+		t.Fatalf("Encoding.decode() failed. Error: %v", err)
+	}
+	parsed, _ := isoSpec.parse(message)
+	if err := channel.MAC.verify(isoSpec, channel.Encoding, wire, parsed); err != nil {
+		t.Errorf("MACPolicy.verify() failed. Error: %v", err)
+	}
+	if err := channel.MAC.verify(isoSpec, channel.Encoding, message, parsed); err == nil {
+		t.Errorf("MACPolicy.verify() failed. Expected: error for MAC over the decoded message. Got: nil")
+	} else {
+		t.Log("MACPolicy on BCD channel success")
+	}
+}
+
+// Return bytes of a hex string
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("Invalid hex %q. Error: %v", s, err)
+	}
+	return b
+}
@@ -1,4 +1,4 @@
-package main
+package kafkabiller
 
 import (
 	"log"
@@ -8,12 +8,13 @@ import (
 )
 
 var (
-	billerChan   = make(chan string) // channel for send-receive data from-to `Biller`
-	producerChan = make(chan string) // channel for receive data from channelChan and send data to `Producer (Kafka)`
-	consumerChan = make(chan string) // channel for receive data from `Consumer (Kafka)` and send data to channelChan
+	billerChan   = make(chan string)          // channel for send-receive data from-to `Biller`
+	producerChan = make(chan string)          // channel for receive data from channelChan and send data to `Producer (Kafka)`
+	consumerChan = make(chan ConsumedMessage) // channel for receive data from `Consumer (Kafka)` and send data to channelChan
 )
 
-func main() {
+// Run runs the service until it's stopped, configs are read from the working directory
+func Run() {
 	// Setting up log file
 	// set permission to read/write log file
 	// read/write to existing log file, if there is none it will create new log file
@@ -26,6 +27,83 @@ func main() {
 	// ChannelKafka started
 	log.Println("Service Started!")
 
+	// Load ISO8583 spec and the versions channels may use
+	spec, err := specFromFile("spec1987.yml")
+	if err != nil {
+		log.Fatalf("Failed to load ISO8583 spec. Error: %v\n", err)
+	}
+	isoSpec = spec
+	versions, err := versionsFromFile("versions.yml")
+	if err != nil {
+		log.Fatalf("Failed to load ISO8583 versions. Error: %v\n", err)
+	}
+	isoVersions = versions
+
+	// Load policies applied to every message
+	lengthPolicy, err := lengthPolicyFromFile("lengthPolicy.yml")
+	if err != nil {
+		log.Fatalf("Failed to load outbound length policy. Error: %v\n", err)
+	}
+	outboundPolicy = lengthPolicy
+	masking, err := maskPolicyFromFile("maskPolicy.yml")
+	if err != nil {
+		log.Fatalf("Failed to load masking policy. Error: %v\n", err)
+	}
+	maskPolicy = masking
+	suspect, err := suspectPolicyFromFile("suspectPolicy.yml")
+	if err != nil {
+		log.Fatalf("Failed to load suspect transaction policy. Error: %v\n", err)
+	}
+	suspectPolicy = suspect
+
+	// Load Biller environment, its Billers count daily quotas in the quota store
+	quotas, err := quotaStoreFromFile("storage/quota.json")
+	if err != nil {
+		log.Fatalf("Failed to load quota counters. Error: %v\n", err)
+	}
+	dailyQuotas = quotas
+	env, err := billerEnvironmentFromFile("biller.yml", os.Getenv(billerEnvironmentVariable))
+	if err != nil {
+		log.Fatalf("Failed to load Biller environment. Error: %v\n", err)
+	}
+	billerEnv = env
+	log.Printf("Biller environment: %v\n", billerEnv)
+
+	// Load field 48 layouts, routes are checked against them
+	layouts, err := layoutsFromFile("field48.yml")
+	if err != nil {
+		log.Fatalf("Failed to load field 48 layouts. Error: %v\n", err)
+	}
+	field48Layouts = layouts
+
+	// Load TLV dictionaries, routes are checked against them
+	dictionaries, err := tlvDictionariesFromFile("tlv.yml")
+	if err != nil {
+		log.Fatalf("Failed to load TLV dictionaries. Error: %v\n", err)
+	}
+	tlvDictionaries = dictionaries
+
+	// Load routing table, service can't process any request without it
+	routes, err := routesFromFile("routes.yml", billerEnv)
+	if err != nil {
+		log.Fatalf("Failed to load routing table. Error: %v\n", err)
+	}
+	routingTable = routes
+	log.Printf("Routing table loaded, %d routes\n", len(routingTable))
+
+	// Load channel settings per consumer topic
+	channels, err = configChannels()
+	if err != nil {
+		log.Fatalf("Failed to load channel config. Error: %v\n", err)
+	}
+
+	// Resume checks of suspect transactions left by the last run, they need the Billers and channels
+	suspects, err = suspectRegistryFromFile("storage/suspects.json")
+	if err != nil {
+		log.Fatalf("Failed to load suspect transactions. Error: %v\n", err)
+	}
+	resumeSuspects()
+
 	// Setting up HTTP Listener and Handler
 	// router will handle any request at any endpoint available in server()
 	router := server()
@@ -48,8 +126,10 @@ func main() {
 	// Run Consumer (Kafka)
 	go consumer(broker, consumerTopics, groups)
 
-	// Run Goroutine for request-response data from-to `Biller`
-	go requestHandler()
+	// Run Goroutines for request-response data from-to `Biller`
+	workers := configWorkers()
+	log.Printf("Handling up to %d requests at once\n", workers)
+	go requestHandler(consumerChan, billerChan, workers)
 
 	// loop for checking if there is any new response from `Biller` that has been sent to channelChan
 	for {
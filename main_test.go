@@ -0,0 +1,47 @@
+package kafkabiller
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Load the config files the service loads at startup from the repository copies
+func TestMain(m *testing.M) {
+	var err error
+	fail := func(what string) {
+		if err != nil {
+			log.Fatalf("Failed to load %s. Error: %v\n", what, err)
+		}
+	}
+
+	isoSpec, err = specFromFile("spec1987.yml")
+	fail("ISO8583 spec")
+	isoVersions, err = versionsFromFile("versions.yml")
+	fail("ISO8583 versions")
+	outboundPolicy, err = lengthPolicyFromFile("lengthPolicy.yml")
+	fail("outbound length policy")
+	maskPolicy, err = maskPolicyFromFile("maskPolicy.yml")
+	fail("masking policy")
+	suspectPolicy, err = suspectPolicyFromFile("suspectPolicy.yml")
+	fail("suspect transaction policy")
+	field48Layouts, err = layoutsFromFile("field48.yml")
+	fail("field 48 layouts")
+	tlvDictionaries, err = tlvDictionariesFromFile("tlv.yml")
+	fail("TLV dictionaries")
+
+	// Quotas of the test Billers are counted in a temporary directory
+	dir, err := ioutil.TempDir("", "storage")
+	fail("storage directory")
+	dailyQuotas = newQuotaStore(filepath.Join(dir, "quota.json"))
+	// Mock Biller secret isn't in the repository
+	os.Setenv("CHIPSAKTI_MOCK_SECRET", "unand")
+	billerEnv, err = billerEnvironmentFromFile("biller.yml", "mock")
+	fail("Biller environment")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
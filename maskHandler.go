@@ -0,0 +1,203 @@
+package kafkabiller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"github.com/mofax/iso8583"
+)
+
+// Masking policy for logs and stored files, loaded from maskPolicy.yml at startup
+var maskPolicy *MaskPolicy
+
+// Environment that allows unmasked logs
+const unmaskedEnvironment = "local"
+
+// Struct for maskPolicy.yml
+type MaskPolicy struct {
+	Unmasked   bool                `yaml:"Unmasked"`
+	ISOFields  map[int]MaskRule    `yaml:"ISOFields"`
+	JSONFields map[string]MaskRule `yaml:"JSONFields"`
+}
+
+// How a single value is masked
+type MaskRule struct {
+	Mode string `yaml:"Mode"`
+	Keep int    `yaml:"Keep"`
+}
+
+// Return masking policy from a yaml file
+func maskPolicyFromFile(filename string) (*MaskPolicy, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy MaskPolicy
+	if err := yaml.Unmarshal(content, &policy); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for field, rule := range policy.ISOFields {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("%s: field %d: %v", filename, field, err)
+		}
+	}
+	for name, rule := range policy.JSONFields {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", filename, name, err)
+		}
+	}
+
+	if policy.Unmasked {
+		if os.Getenv("CHIPSAKTI_ENV") != unmaskedEnvironment {
+			log.Printf("Warning: unmasked logs are only allowed when CHIPSAKTI_ENV is %q, masking stays on\n", unmaskedEnvironment)
+			policy.Unmasked = false
+		} else {
+			log.Println("Warning: masking is off, sensitive data is logged in plaintext")
+		}
+	}
+	return &policy, nil
+}
+
+// Check that a rule can be applied
+func (r MaskRule) validate() error {
+	switch r.Mode {
+	case "full", "hash":
+	case "last":
+		if r.Keep <= 0 {
+			return fmt.Errorf("last needs a positive Keep")
+		}
+	default:
+		return fmt.Errorf("invalid mode %q", r.Mode)
+	}
+	return nil
+}
+
+// Return value masked by rule, masked value has the same length unless it's a JSON hash
+func (r MaskRule) apply(value string, keepLength bool) string {
+	if value == "" {
+		return value
+	}
+
+	switch r.Mode {
+	case "last":
+		if len(value) <= r.Keep {
+			return strings.Repeat("*", len(value))
+		}
+		return strings.Repeat("*", len(value)-r.Keep) + value[len(value)-r.Keep:]
+	case "hash":
+		sum := sha256.Sum256([]byte(value))
+		digest := hex.EncodeToString(sum[:])
+		if !keepLength {
+			return "sha256:" + digest[:12]
+		}
+		if len(value) <= len(digest) {
+			return digest[:len(value)]
+		}
+		return digest + strings.Repeat("*", len(value)-len(digest))
+	default:
+		return strings.Repeat("*", len(value))
+	}
+}
+
+// isoValue returns a single ISO field value masked
+func (p *MaskPolicy) isoValue(field int, value string) string {
+	rule, ok := p.ISOFields[field]
+	if p.Unmasked || !ok {
+		return value
+	}
+	return rule.apply(value, true)
+}
+
+// iso returns the message of iso with every sensitive field masked, spec is the spec iso was built with
+func (p *MaskPolicy) iso(spec *Spec, iso iso8583.IsoStruct) string {
+	if p.Unmasked {
+		message, _ := iso.ToString()
+		return message
+	}
+
+	// Masked copy, so the original message is left untouched
+	masked := spec.newIso()
+	masked.Bitmap = make([]int64, len(iso.Bitmap))
+	copy(masked.Bitmap, iso.Bitmap)
+	masked.Mti = iso.Mti
+	for field, value := range iso.Elements.GetElements() {
+		masked.AddField(field, p.isoValue(int(field), value))
+	}
+
+	message, err := masked.ToString()
+	if err != nil {
+		return fmt.Sprintf("<unprintable message: %v>", err)
+	}
+	return message
+}
+
+// jsonValue returns a single JSON field value masked
+func (p *MaskPolicy) jsonValue(name string, value string) string {
+	rule, ok := p.JSONFields[name]
+	if p.Unmasked || !ok {
+		return value
+	}
+	return rule.apply(value, false)
+}
+
+// json returns v as JSON with every sensitive field masked
+func (p *MaskPolicy) json(v interface{}) string {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("<unprintable value: %v>", err)
+	}
+	if p.Unmasked {
+		return string(content)
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(content, &generic); err != nil {
+		return fmt.Sprintf("<unprintable value: %v>", err)
+	}
+	masked, _ := json.Marshal(p.maskJSON("", generic))
+	return string(masked)
+}
+
+// Return JSON value with sensitive fields masked, name is the field holding value
+func (p *MaskPolicy) maskJSON(name string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = p.maskJSON(key, item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = p.maskJSON(name, item)
+		}
+		return result
+	case string:
+		return p.jsonValue(name, v)
+	case float64:
+		if _, ok := p.JSONFields[name]; ok {
+			return p.jsonValue(name, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// raw returns a message that can't be parsed yet, it's only logged when masking is off
+func (p *MaskPolicy) raw(message string) string {
+	if p.Unmasked {
+		return message
+	}
+	return fmt.Sprintf("<%d bytes>", len(message))
+}
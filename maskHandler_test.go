@@ -0,0 +1,105 @@
+package kafkabiller
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaskRule(t *testing.T) {
+	tests := []struct {
+		rule     MaskRule
+		value    string
+		expected string
+	}{
+		{MaskRule{Mode: "full"}, "HANAFI", "******"},
+		{MaskRule{Mode: "last", Keep: 4}, "0812345678", "******5678"},
+		{MaskRule{Mode: "last", Keep: 4}, "123", "***"},
+		{MaskRule{Mode: "hash"}, "abc", "ba7"},
+		{MaskRule{Mode: "full"}, "", ""},
+	}
+
+	for _, test := range tests {
+		if result := test.rule.apply(test.value, true); result != test.expected {
+			t.Errorf("MaskRule.apply(%v, %q) failed. Expected: %q. Got: %q", test.rule, test.value, test.expected, result)
+		}
+	}
+	if result := (MaskRule{Mode: "hash"}).apply("abc", false); result != "sha256:ba7816bf8f01" {
+		t.Errorf("MaskRule.apply() JSON hash failed. Got: %q", result)
+	} else {
+		t.Log("MaskRule.apply() success")
+	}
+}
+
+func TestMaskIso(t *testing.T) {
+	iso := buildIso(isoSpec, map[int]string{3: "380001", 39: "00", 43: "HANAFI", 122: "0812345678"}, "0210")
+	original, _ := iso.ToString()
+
+	masked := maskPolicy.iso(isoSpec, iso)
+	if strings.Contains(masked, "HANAFI") || strings.Contains(masked, "0812345678") {
+		t.Errorf("MaskPolicy.iso() failed. Sensitive value in: %v", masked)
+	}
+	if result, _ := iso.ToString(); result != original {
+		t.Errorf("MaskPolicy.iso() changed the original message. Expected: %v. Got: %v", original, result)
+	}
+
+	// Masked message keeps field lengths and can be parsed
+	parsed, err := isoSpec.parse(masked)
+	elements := parsed.Elements.GetElements()
+	if err != nil || elements[122] != "******5678" || elements[3] != "380001" {
+		t.Errorf("MaskPolicy.iso() failed. Got: %v (%v)", elements, err)
+	} else {
+		t.Log("MaskPolicy.iso() success")
+	}
+}
+
+func TestMaskJSON(t *testing.T) {
+	param := url.Values{}
+	param.Set("customer_no", "0812345678")
+	param.Set("amount", "1500")
+
+	expected := `{"amount":["1500"],"customer_no":["******5678"]}`
+	if result := maskPolicy.json(param); result != expected {
+		t.Errorf("MaskPolicy.json() failed. Expected: %v. Got: %v", expected, result)
+	}
+
+	response := PPOBPaymentResponse{Rc: "00", Nama: "HANAFI", Struk: []string{"NAMA : HANAFI"}}
+	if result := maskPolicy.json(response); strings.Contains(result, "HANAFI") {
+		t.Errorf("MaskPolicy.json() failed. Sensitive value in: %v", result)
+	} else {
+		t.Log("MaskPolicy.json() success")
+	}
+}
+
+func TestMaskPolicyUnmasked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "maskPolicy.yml")
+	content := "Unmasked: true\nISOFields:\n  43: {Mode: full}\n"
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unmasked is ignored outside local development
+	os.Setenv("CHIPSAKTI_ENV", "production")
+	policy, err := maskPolicyFromFile(filename)
+	if err != nil || policy.Unmasked || policy.isoValue(43, "HANAFI") != "******" {
+		t.Errorf("maskPolicyFromFile() failed. Expected masking outside local. Got: %v (%v)", policy, err)
+	}
+
+	os.Setenv("CHIPSAKTI_ENV", "local")
+	defer os.Unsetenv("CHIPSAKTI_ENV")
+	policy, err = maskPolicyFromFile(filename)
+	if err != nil || policy.isoValue(43, "HANAFI") != "HANAFI" {
+		t.Errorf("maskPolicyFromFile() failed. Expected no masking on local. Got: %v (%v)", policy, err)
+	} else {
+		t.Log("maskPolicyFromFile() success")
+	}
+}
@@ -0,0 +1,66 @@
+package kafkabiller
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Counters exposed at GET /metrics
+var metrics = &metricRegistry{counters: make(map[string]int64)}
+
+// metricRegistry holds named counters, safe for concurrent use
+type metricRegistry struct {
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+// inc adds one to the counter with name and labels, labels are given as key, value pairs
+func (m *metricRegistry) inc(name string, labels ...string) {
+	key := metricKey(name, labels...)
+
+	m.mu.Lock()
+	m.counters[key]++
+	m.mu.Unlock()
+}
+
+// get returns current value of the counter with name and labels
+func (m *metricRegistry) get(name string, labels ...string) int64 {
+	key := metricKey(name, labels...)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[key]
+}
+
+// snapshot returns a copy of every counter
+func (m *metricRegistry) snapshot() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[string]int64, len(m.counters))
+	for key, value := range m.counters {
+		result[key] = value
+	}
+	return result
+}
+
+// Return counter key, e.g. outbound_length_policy{action="truncate",field="43"}
+func metricKey(name string, labels ...string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"=\""+labels[i+1]+"\"")
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Return every counter
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	jsonFormatter(w, metrics.snapshot(), http.StatusOK)
+}
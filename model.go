@@ -1,4 +1,4 @@
-package main
+package kafkabiller
 
 type Response struct {
 	ResponseCode        int    `json:"responseCode"`
@@ -24,125 +24,144 @@ type fieldDescription struct {
 }
 
 type PPOBInquiryRequest struct {
-	TransactionID string `json:"transaction_id"`
-	PartnerID     string `json:"partner_id"`
-	ProductCode   string `json:"product_code"`
-	CustomerNo    string `json:"customer_no"`
-	Periode       string `json:"periode"`
-	MerchantCode  string `json:"merchant_code"`
-	RequestTime   string `json:"request_time"`
+	TransactionID string `json:"transaction_id" iso:"48,sub=transaction_id"`
+	PartnerID     string `json:"partner_id" iso:"48,sub=partner_id"`
+	ProductCode   string `json:"product_code" iso:"48,sub=product_code"`
+	CustomerNo    string `json:"customer_no" iso:"48,sub=customer_no"`
+	Periode       string `json:"periode" iso:"48,sub=periode"`
+	MerchantCode  string `json:"merchant_code" iso:"48,sub=merchant_code"`
+	RequestTime   string `json:"request_time" iso:"48,sub=request_time"`
 	Signature     string `json:"signature"`
 }
 
 type PPOBInquiryResponse struct {
-	Rc           string `json:"rc"`
-	Msg          string `json:"msg"`
-	Produk       string `json:"produk"`
-	Nopel        string `json:"nopel"`
-	Nama         string `json:"nama"`
-	Tagihan      int    `json:"tagihan"`
-	Admin        int    `json:"admin"`
-	TotalTagihan int    `json:"total_tagihan"`
-	Reffid       string `json:"reffid"`
-	Data         string `json:"data"`
-	Restime      string `json:"restime"`
+	Rc           string `json:"rc" iso:"39"`
+	Msg          string `json:"msg" iso:"120"`
+	Produk       string `json:"produk" iso:"121"`
+	Nopel        string `json:"nopel" iso:"122"`
+	Nama         string `json:"nama" iso:"43"`
+	Tagihan      Money  `json:"tagihan" iso:"4"`
+	Admin        Money  `json:"admin" iso:"5"`
+	TotalTagihan Money  `json:"total_tagihan" iso:"6"`
+	Reffid       string `json:"reffid" iso:"37"`
+	Data         string `json:"data" iso:"62"`
+	Restime      string `json:"restime" iso:"48"`
 }
 
 type PPOBPaymentRequest struct {
-	TransactionID string `json:"transaction_id"`
-	PartnerID     string `json:"partner_id"`
-	ProductCode   string `json:"product_code"`
-	CustomerNo    string `json:"customer_no"`
-	MerchantCode  string `json:"merchant_code"`
-	ReffID        string `json:"reff_id"`
-	Amount        int    `json:"amount"`
-	RequestTime   string `json:"request_time"`
+	TransactionID string `json:"transaction_id" iso:"48,sub=transaction_id"`
+	PartnerID     string `json:"partner_id" iso:"48,sub=partner_id"`
+	ProductCode   string `json:"product_code" iso:"48,sub=product_code"`
+	CustomerNo    string `json:"customer_no" iso:"48,sub=customer_no"`
+	MerchantCode  string `json:"merchant_code" iso:"48,sub=merchant_code"`
+	ReffID        string `json:"reff_id" iso:"37"`
+	Amount        Money  `json:"amount" iso:"4"`
+	RequestTime   string `json:"request_time" iso:"48,sub=request_time"`
 	Signature     string `json:"signature"`
 }
 
 type PPOBPaymentResponse struct {
-	Rc           string   `json:"rc"`
-	Msg          string   `json:"msg"`
-	Produk       string   `json:"produk"`
-	Nopel        string   `json:"nopel"`
-	Nama         string   `json:"nama"`
-	Tagihan      int      `json:"tagihan"`
-	Admin        int      `json:"admin"`
-	TotalTagihan int      `json:"total_tagihan"`
-	Reffid       string   `json:"reffid"`
-	TglLunas     string   `json:"tgl_lunas"`
-	Struk        []string `json:"struk"`
-	ReffNo       string   `json:"Reff_no"`
+	Rc           string   `json:"rc" iso:"39"`
+	Msg          string   `json:"msg" iso:"120"`
+	Produk       string   `json:"produk" iso:"121"`
+	Nopel        string   `json:"nopel" iso:"122"`
+	Nama         string   `json:"nama" iso:"43"`
+	Tagihan      Money    `json:"tagihan" iso:"4"`
+	Admin        Money    `json:"admin" iso:"5"`
+	TotalTagihan Money    `json:"total_tagihan" iso:"6"`
+	Reffid       string   `json:"reffid" iso:"37"`
+	TglLunas     string   `json:"tgl_lunas" iso:"48"`
+	Struk        []string `json:"struk" iso:"62,struk=63"`
+	ReffNo       string   `json:"Reff_no" iso:"123"`
 	Restime      string   `json:"restime"`
 }
 
 type PPOBStatusRequest struct {
-	TransactionID string `json:"transaction_id"`
-	PartnerID     string `json:"partner_id"`
-	ProductCode   string `json:"product_code"`
-	CustomerNo    string `json:"customer_no"`
-	MerchantCode  string `json:"merchant_code"`
-	ReffID        string `json:"reff_id"`
-	Amount        int    `json:"amount"`
-	RequestTime   string `json:"request_time"`
+	TransactionID string `json:"transaction_id" iso:"48,sub=transaction_id"`
+	PartnerID     string `json:"partner_id" iso:"48,sub=partner_id"`
+	ProductCode   string `json:"product_code" iso:"48,sub=product_code"`
+	CustomerNo    string `json:"customer_no" iso:"48,sub=customer_no"`
+	MerchantCode  string `json:"merchant_code" iso:"48,sub=merchant_code"`
+	ReffID        string `json:"reff_id" iso:"37"`
+	Amount        Money  `json:"amount" iso:"4"`
+	RequestTime   string `json:"request_time" iso:"48,sub=request_time"`
 	Signature     string `json:"signature"`
 }
 
 type PPOBStatusResponse struct {
-	Rc           string   `json:"rc"`
-	Msg          string   `json:"msg"`
-	Produk       string   `json:"produk"`
-	Nopel        string   `json:"nopel"`
-	Nama         string   `json:"nama"`
-	Tagihan      int      `json:"tagihan"`
-	Admin        int      `json:"admin"`
-	TotalTagihan int      `json:"total_tagihan"`
-	Reffid       string   `json:"reffid"`
-	TglLunas     string   `json:"tgl_lunas"`
-	Struk        []string `json:"struk"`
-	ReffNo       string   `json:"Reff_no"`
-	Status       string   `json:"status"`
+	Rc           string   `json:"rc" iso:"39"`
+	Msg          string   `json:"msg" iso:"120"`
+	Produk       string   `json:"produk" iso:"121"`
+	Nopel        string   `json:"nopel" iso:"122"`
+	Nama         string   `json:"nama" iso:"43"`
+	Tagihan      Money    `json:"tagihan" iso:"4"`
+	Admin        Money    `json:"admin" iso:"5"`
+	TotalTagihan Money    `json:"total_tagihan" iso:"6"`
+	Reffid       string   `json:"reffid" iso:"37"`
+	TglLunas     string   `json:"tgl_lunas" iso:"48"`
+	Struk        []string `json:"struk" iso:"62,struk=63"`
+	ReffNo       string   `json:"Reff_no" iso:"123"`
+	Status       string   `json:"status" iso:"124"`
 	Restime      string   `json:"restime"`
 }
 
 type TopupBuyRequest struct {
-	TransactionID string `json:"transaction_id"`
-	PartnerID     string `json:"partner_id"`
-	ProductCode   string `json:"product_code"`
-	CustomerNo    string `json:"customer_no"`
-	MerchantCode  string `json:"merchant_code"`
-	RequestTime   string `json:"request_time"`
+	TransactionID string `json:"transaction_id" iso:"48,sub=transaction_id"`
+	PartnerID     string `json:"partner_id" iso:"48,sub=partner_id"`
+	ProductCode   string `json:"product_code" iso:"48,sub=product_code"`
+	CustomerNo    string `json:"customer_no" iso:"48,sub=customer_no"`
+	MerchantCode  string `json:"merchant_code" iso:"48,sub=merchant_code"`
+	RequestTime   string `json:"request_time" iso:"48,sub=request_time"`
 	Signature     string `json:"signature"`
 }
 
 type TopupBuyResponse struct {
-	Rc      string `json:"rc"`
-	Msg     string `json:"msg"`
-	Restime string `json:"restime"`
-	SN      string `json:"sn"`
-	Price   string `json:"price"`
+	Rc      string `json:"rc" iso:"39"`
+	Msg     string `json:"msg" iso:"120"`
+	Restime string `json:"restime" iso:"48"`
+	SN      string `json:"sn" iso:"121"`
+	Price   Money  `json:"price" iso:"122"`
 }
 
 type TopupCheckRequest struct {
-	TransactionID string `json:"transaction_id"`
-	PartnerID     string `json:"partner_id"`
-	ProductCode   string `json:"product_code"`
-	CustomerNo    string `json:"customer_no"`
-	MerchantCode  string `json:"merchant_code"`
-	RequestTime   string `json:"request_time"`
+	TransactionID string `json:"transaction_id" iso:"48,sub=transaction_id"`
+	PartnerID     string `json:"partner_id" iso:"48,sub=partner_id"`
+	ProductCode   string `json:"product_code" iso:"48,sub=product_code"`
+	CustomerNo    string `json:"customer_no" iso:"48,sub=customer_no"`
+	MerchantCode  string `json:"merchant_code" iso:"48,sub=merchant_code"`
+	RequestTime   string `json:"request_time" iso:"48,sub=request_time"`
 	Signature     string `json:"signature"`
 }
 
 type UnsuccessfulChipsakti struct {
-	Rc      string `json:"rc"`
-	Msg     string `json:"msg"`
-	Restime string `json:"restime"`
+	Rc      string `json:"rc" iso:"39"`
+	Msg     string `json:"msg" iso:"120"`
+	Restime string `json:"restime" iso:"48"`
 }
 
 type TopupCheckResponse struct {
-	Rc      string `json:"rc"`
-	Msg     string `json:"msg"`
-	Restime string `json:"restime"`
-	SN      string `json:"sn"`
-	Price   string `json:"price"`
+	Rc      string `json:"rc" iso:"39"`
+	Msg     string `json:"msg" iso:"120"`
+	Restime string `json:"restime" iso:"48"`
+	SN      string `json:"sn" iso:"121"`
+	Price   Money  `json:"price" iso:"122"`
+}
+
+// Process field 48 layout file
+type subFieldDescription struct {
+	Name      string `yaml:"Name"`
+	Offset    *int   `yaml:"Offset"`
+	Length    int    `yaml:"Length"`
+	Delimiter string `yaml:"Delimiter"`
+	Trim      string `yaml:"Trim"`
+	Pad       string `yaml:"Pad"`
+	PadChar   string `yaml:"PadChar"`
+	Required  bool   `yaml:"Required"`
+}
+
+// Process TLV dictionary file
+type tlvElement struct {
+	Tag    string `yaml:"Tag"`
+	Name   string `yaml:"Name"`
+	MaxLen int    `yaml:"MaxLen"`
 }
@@ -0,0 +1,84 @@
+package kafkabiller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// ISO 4217 numeric code of Indonesian Rupiah, sent in field 49 with every amount
+const currencyIDR = "360"
+
+// Amount fields, formatted as 12 zero-padded digits
+var amountFields = map[int]bool{4: true, 5: true, 6: true}
+
+// Money is an amount in minor units of IDR. IDR has no minor unit in ISO 4217,
+// so one unit is one Rupiah
+type Money int64
+
+// Return amount parsed from digits, anything else is a format error
+func parseMoney(s string) (Money, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty amount")
+	}
+	for _, r := range s {
+		if !isDigit(r) {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+	amount, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return Money(amount), nil
+}
+
+// String returns the amount as plain digits, as sent to Biller
+func (m Money) String() string {
+	return strconv.FormatInt(int64(m), 10)
+}
+
+// isoAmount returns the amount for fields 4/5/6
+func (m Money) isoAmount() (string, error) {
+	if m < 0 {
+		return "", fmt.Errorf("negative amount %d", m)
+	}
+	amount := fmt.Sprintf("%012d", int64(m))
+	if len(amount) > 12 {
+		return "", fmt.Errorf("amount %d doesn't fit in 12 digits", m)
+	}
+	return amount, nil
+}
+
+// UnmarshalJSON accepts a whole number or a string of digits, Biller sends both
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+	amount, err := parseMoney(text)
+	if err != nil {
+		return err
+	}
+	*m = amount
+	return nil
+}
+
+// Return amount of a Biller JSON value
+func moneyFromJSON(value interface{}) (Money, error) {
+	switch v := value.(type) {
+	case float64:
+		if v < 0 || v != float64(int64(v)) {
+			return 0, fmt.Errorf("invalid amount %v", v)
+		}
+		return Money(v), nil
+	case string:
+		return parseMoney(v)
+	default:
+		return 0, fmt.Errorf("invalid amount %v", v)
+	}
+}
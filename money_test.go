@@ -0,0 +1,81 @@
+package kafkabiller
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	valid := map[string]Money{"000000001500": 1500, "0": 0, "873300": 873300}
+	for input, expected := range valid {
+		if result, err := parseMoney(input); err != nil || result != expected {
+			t.Errorf("parseMoney(%q) failed. Expected: %v. Got: %v (%v)", input, expected, result, err)
+		}
+	}
+
+	for _, input := range []string{"", "-1500", "+1500", "1500.00", " 1500", "1,500", "99999999999999999999"} {
+		if _, err := parseMoney(input); err == nil {
+			t.Errorf("parseMoney(%q) failed. Expected error", input)
+		}
+	}
+	t.Log("parseMoney() success")
+}
+
+func TestMoneyIsoAmount(t *testing.T) {
+	if result, err := Money(1500).isoAmount(); err != nil || result != "000000001500" {
+		t.Errorf("Money.isoAmount() failed. Expected: 000000001500. Got: %v (%v)", result, err)
+	}
+	if _, err := Money(1000000000000).isoAmount(); err == nil {
+		t.Errorf("Money.isoAmount() failed. Expected error for 13 digits")
+	}
+	if _, err := Money(-1).isoAmount(); err == nil {
+		t.Errorf("Money.isoAmount() failed. Expected error for negative amount")
+	} else {
+		t.Log("Money.isoAmount() success")
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	var response TopupBuyResponse
+	if err := json.Unmarshal([]byte(`{"rc":"00","price":"1000"}`), &response); err != nil || response.Price != 1000 {
+		t.Errorf("Money.UnmarshalJSON() of string failed. Got: %v (%v)", response.Price, err)
+	}
+
+	var inquiry PPOBInquiryResponse
+	if err := json.Unmarshal([]byte(`{"rc":"00","tagihan":1500}`), &inquiry); err != nil || inquiry.Tagihan != 1500 {
+		t.Errorf("Money.UnmarshalJSON() of number failed. Got: %v (%v)", inquiry.Tagihan, err)
+	}
+	if err := json.Unmarshal([]byte(`{"rc":"00","tagihan":1500.5}`), &inquiry); err == nil {
+		t.Errorf("Money.UnmarshalJSON() failed. Expected error for fraction")
+	} else {
+		t.Log("Money.UnmarshalJSON() success")
+	}
+}
+
+func TestMarshalIsoCurrency(t *testing.T) {
+	result, err := marshalIso(PPOBInquiryResponse{Rc: "00", Tagihan: 1500})
+	if err != nil || result[4] != "000000001500" || result[49] != currencyIDR {
+		t.Errorf("marshalIso() failed. Expected field 4 and currency. Got: %v (%v)", result, err)
+	}
+
+	var request struct {
+		Amount Money `iso:"4"`
+	}
+	fields := map[int64]string{4: "000000001500", 49: "840"}
+	if err := unmarshalIso(fields, &request); err == nil || err.Error() != `field 49: unsupported currency "840"` {
+		t.Errorf("unmarshalIso() failed. Expected unsupported currency. Got: %v", err)
+	} else {
+		t.Log("Currency success")
+	}
+}
+
+func TestGetIsoRouteInvalidAmount(t *testing.T) {
+	jsonResponse := map[string]interface{}{"rc": "00", "nama": "HANAFI", "tagihan": "Rp 1.500"}
+
+	isoResponse := getIsoRoute(testRoute, "380001", jsonResponse)
+	if rc := isoResponse.Elements.GetElements()[39]; rc != "30" {
+		t.Errorf("getIsoRoute() failed. Expected RC 30. Got: %v", rc)
+	} else {
+		t.Log("getIsoRoute() invalid amount success")
+	}
+}
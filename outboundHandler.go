@@ -0,0 +1,148 @@
+package kafkabiller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-yaml/yaml"
+)
+
+// Outbound length policy, loaded from lengthPolicy.yml at startup
+var outboundPolicy LengthPolicy
+
+// Struct for lengthPolicy.yml
+type LengthPolicy struct {
+	Default FieldPolicy         `yaml:"Default"`
+	Fields  map[int]FieldPolicy `yaml:"Fields"`
+}
+
+// What to do with a single field longer than its MaxLen
+type FieldPolicy struct {
+	Action        string `yaml:"Action"`
+	ResponseCode  string `yaml:"ResponseCode"`
+	OverflowField int    `yaml:"OverflowField"`
+}
+
+// Return outbound length policy from a yaml file
+func lengthPolicyFromFile(filename string) (LengthPolicy, error) {
+	var policy LengthPolicy
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return policy, err
+	}
+	if err := yaml.Unmarshal(content, &policy); err != nil {
+		return policy, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if err := policy.Default.validate(); err != nil {
+		return policy, fmt.Errorf("%s: default: %v", filename, err)
+	}
+	for field, fieldPolicy := range policy.Fields {
+		if err := fieldPolicy.validate(); err != nil {
+			return policy, fmt.Errorf("%s: field %d: %v", filename, field, err)
+		}
+		if fieldPolicy.OverflowField == field {
+			return policy, fmt.Errorf("%s: field %d: can't overflow to itself", filename, field)
+		}
+	}
+	return policy, nil
+}
+
+// Check that a field policy can be applied
+func (p FieldPolicy) validate() error {
+	switch p.Action {
+	case "truncate":
+	case "reject":
+		if len(p.ResponseCode) != 2 {
+			return fmt.Errorf("reject needs a 2 digit ResponseCode")
+		}
+	case "overflow":
+		if p.OverflowField < 2 || p.OverflowField > 128 {
+			return fmt.Errorf("overflow needs an OverflowField between 2 and 128")
+		}
+	default:
+		return fmt.Errorf("invalid action %q", p.Action)
+	}
+	return nil
+}
+
+// Return policy for field
+func (p LengthPolicy) forField(field int) FieldPolicy {
+	if fieldPolicy, ok := p.Fields[field]; ok {
+		return fieldPolicy
+	}
+	return p.Default
+}
+
+// Return data with every field within its MaxLen in spec, or the fields of a rejected response
+func (p LengthPolicy) apply(spec *Spec, data map[int]string) map[int]string {
+	result := make(map[int]string, len(data))
+	for field, value := range data {
+		result[field] = value
+	}
+
+	// Fields are checked in order so the lowest field gets an overflow field that two fields share
+	fields := make([]int, 0, len(data))
+	for field := range data {
+		fields = append(fields, field)
+	}
+	sort.Ints(fields)
+
+	for _, field := range fields {
+		value := data[field]
+		maxLen := spec.fields[field].MaxLen
+		if maxLen == 0 || len(value) <= maxLen {
+			continue
+		}
+
+		policy := p.forField(field)
+		metrics.inc("outbound_length_policy", "field", strconv.Itoa(field), "action", policy.Action)
+		log.Printf("Warning: field %d is %d characters, longer than %d. Applying %s\n", field, len(value), maxLen, policy.Action)
+
+		switch policy.Action {
+		case "reject":
+			return map[int]string{
+				39:  policy.ResponseCode,
+				48:  time.Now().Format("2006-01-02 15:04:05"),
+				120: fmt.Sprintf("field %d exceeds max length %d", field, maxLen),
+			}
+
+		case "overflow":
+			kept := truncateBytes(value, maxLen)
+			result[field] = kept
+
+			// Overflow field keeps its own limit, anything beyond it is cut
+			overflow := value[len(kept):]
+			if _, used := result[policy.OverflowField]; used {
+				log.Printf("Warning: overflow field %d is already used, field %d is truncated\n", policy.OverflowField, field)
+				break
+			}
+			if overflowMax := spec.fields[policy.OverflowField].MaxLen; len(overflow) > overflowMax {
+				overflow = truncateBytes(overflow, overflowMax)
+			}
+			result[policy.OverflowField] = overflow
+
+		default:
+			result[field] = truncateBytes(value, maxLen)
+		}
+	}
+
+	return result
+}
+
+// Return s cut to at most n bytes without splitting a multi-byte character
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
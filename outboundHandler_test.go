@@ -0,0 +1,82 @@
+package kafkabiller
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLengthPolicyFromFile(t *testing.T) {
+	policy, err := lengthPolicyFromFile("lengthPolicy.yml")
+	if err != nil {
+		t.Errorf("lengthPolicyFromFile() failed. Error: %v", err)
+	}
+	if policy.forField(4).Action != "reject" || policy.forField(120).Action != "truncate" {
+		t.Errorf("lengthPolicyFromFile() failed. Got: %+v", policy)
+	} else {
+		t.Log("lengthPolicyFromFile() success")
+	}
+}
+
+func TestLengthPolicyApply(t *testing.T) {
+	policy := LengthPolicy{
+		Default: FieldPolicy{Action: "truncate"},
+		Fields: map[int]FieldPolicy{
+			4:  {Action: "reject", ResponseCode: "13"},
+			43: {Action: "overflow", OverflowField: 125},
+		},
+	}
+
+	name := strings.Repeat("N", 40) + "ÉXTRA"
+	before := metrics.get("outbound_length_policy", "field", "43", "action", "overflow")
+	result := policy.apply(isoSpec, map[int]string{39: "00", 43: name, 120: strings.Repeat("m", 1000)})
+
+	if result[43] != strings.Repeat("N", 40) || result[125] != "ÉXTRA" {
+		t.Errorf("apply() failed at overflow. Got: %q, %q", result[43], result[125])
+	}
+	if len(result[120]) != 999 {
+		t.Errorf("apply() failed at truncate. Got length: %v", len(result[120]))
+	}
+	if metrics.get("outbound_length_policy", "field", "43", "action", "overflow") != before+1 {
+		t.Errorf("apply() failed. Expected metric to be incremented")
+	}
+
+	result = policy.apply(isoSpec, map[int]string{4: "1234567890123", 39: "00"})
+	if result[39] != "13" || result[4] != "" {
+		t.Errorf("apply() failed at reject. Got: %v", result)
+	} else {
+		t.Log("apply() success")
+	}
+}
+
+func TestLengthPolicyApplySharedOverflow(t *testing.T) {
+	policy := LengthPolicy{
+		Default: FieldPolicy{Action: "truncate"},
+		Fields: map[int]FieldPolicy{
+			43: {Action: "overflow", OverflowField: 125},
+			44: {Action: "overflow", OverflowField: 125},
+		},
+	}
+	data := map[int]string{
+		39: "00",
+		43: strings.Repeat("N", 40) + "NAME",
+		44: strings.Repeat("A", 25) + "DATA",
+	}
+
+	// Map order is random, the result must not be
+	for i := 0; i < 20; i++ {
+		result := policy.apply(isoSpec, data)
+		if result[125] != "NAME" || result[44] != strings.Repeat("A", 25) {
+			t.Fatalf("apply() failed. Expected: field 43 overflow in 125 and field 44 truncated. Got: %q, %q", result[125], result[44])
+		}
+	}
+	t.Log("apply() shared overflow success")
+}
+
+func TestTruncateBytes(t *testing.T) {
+	// "é" is 2 bytes, cutting in the middle of it drops the whole character
+	if result := truncateBytes("abé", 3); result != "ab" {
+		t.Errorf("truncateBytes() failed. Expected: ab. Got: %v", result)
+	} else {
+		t.Log("truncateBytes() success")
+	}
+}
@@ -0,0 +1,356 @@
+package kafkabiller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"github.com/mofax/iso8583"
+)
+
+// Routing table loaded from routes.yml at startup
+var routingTable []Route
+
+// Built-in conversions that can be referenced by a route Handler
+var routeHandlers = map[string]bool{
+	"ppobInquiry": true,
+	"ppobPayment": true,
+	"ppobStatus":  true,
+	"topupBuy":    true,
+	"topupCheck":  true,
+}
+
+// Placeholder in signature template, e.g. {transaction_id}
+var signaturePlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// Struct for routes.yml
+type Route struct {
+	ProcessingCode string            `yaml:"ProcessingCode"`
+	MTI            string            `yaml:"MTI"`
+	Name           string            `yaml:"Name"`
+	Handler        string            `yaml:"Handler"`
+	Biller         string            `yaml:"Biller"`
+	Mandatory      []int             `yaml:"Mandatory"`
+	Endpoint       string            `yaml:"Endpoint"`
+	Request        map[string]string `yaml:"Request"`
+	Signature      string            `yaml:"Signature"`
+	Response       RouteResponse     `yaml:"Response"`
+}
+
+// ISO field to Biller JSON field mapping of a route
+type RouteResponse struct {
+	Approved map[int]string `yaml:"Approved"`
+	Declined map[int]string `yaml:"Declined"`
+}
+
+// Return routing table from a yaml file, the Billers of routes are checked against env unless it's nil
+func routesFromFile(filename string, env *BillerEnvironment) ([]Route, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []Route
+	if err := yaml.Unmarshal(content, &routes); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		key := route.ProcessingCode + "/" + route.MTI
+		if err := route.validate(env); err != nil {
+			return nil, fmt.Errorf("%s: route %s: %v", filename, key, err)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%s: route %s: defined more than once", filename, key)
+		}
+		seen[key] = true
+	}
+
+	return routes, nil
+}
+
+// Check that a route can be processed, by a Biller of env unless it's nil
+func (r Route) validate(env *BillerEnvironment) error {
+	if len(r.ProcessingCode) != 6 {
+		return fmt.Errorf("processing code must be 6 digits")
+	}
+	if r.MTI != "" && len(r.MTI) != 4 {
+		return fmt.Errorf("MTI must be 4 digits")
+	}
+
+	for _, field := range r.Mandatory {
+		if field < 2 || field > 128 {
+			return fmt.Errorf("mandatory field %d out of range", field)
+		}
+	}
+
+	if env != nil {
+		if _, ok := env.connection(r.Biller); !ok {
+			return fmt.Errorf("unknown Biller %s", r.Biller)
+		}
+	}
+
+	// Built-in conversion doesn't need any mapping
+	if r.Handler != "" {
+		if !routeHandlers[r.Handler] {
+			return fmt.Errorf("unknown handler %s", r.Handler)
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(r.Endpoint, "/") {
+		return fmt.Errorf("endpoint must start with /")
+	}
+	for param, source := range r.Request {
+		field, subField, err := parseRouteSource(source)
+		if err != nil {
+			return fmt.Errorf("request %s: %v", param, err)
+		}
+		if subField != "" {
+			if err := routeSubFieldDefined(r.ProcessingCode, field, subField); err != nil {
+				return fmt.Errorf("request %s: %v", param, err)
+			}
+		}
+	}
+	for _, match := range signaturePlaceholder.FindAllStringSubmatch(r.Signature, -1) {
+		if _, ok := r.Request[match[1]]; !ok && match[1] != signatureSecret {
+			return fmt.Errorf("signature uses %s which is not in request", match[1])
+		}
+	}
+	if len(r.Response.Approved) == 0 || len(r.Response.Declined) == 0 {
+		return fmt.Errorf("response needs both approved and declined mapping")
+	}
+	for _, mapping := range []map[int]string{r.Response.Approved, r.Response.Declined} {
+		for field := range mapping {
+			if field < 2 || field > 128 || field == 3 {
+				return fmt.Errorf("response can't be mapped to field %d", field)
+			}
+		}
+	}
+	return nil
+}
+
+// Return route for processing code and MTI, a route with matching MTI takes precedence
+func findRoute(routes []Route, pcode string, mti string) (Route, bool) {
+	var fallback Route
+	found := false
+	for _, route := range routes {
+		if route.ProcessingCode != pcode {
+			continue
+		}
+		if route.MTI == mti {
+			return route, true
+		}
+		if route.MTI == "" {
+			fallback = route
+			found = true
+		}
+	}
+	return fallback, found
+}
+
+// Return ISO field and sub-field of a request source, e.g. "37", "48.customer_no" or "126.token"
+func parseRouteSource(source string) (field int, subField string, err error) {
+	parts := strings.SplitN(source, ".", 2)
+	field, err = strconv.Atoi(parts[0])
+	if err != nil || field < 2 || field > 128 {
+		return 0, "", fmt.Errorf("invalid source %q", source)
+	}
+	if len(parts) == 2 {
+		if !tlvFields[field] || parts[1] == "" {
+			return 0, "", fmt.Errorf("invalid source %q, only fields 48, 62 and 120-127 have sub-fields", source)
+		}
+		subField = parts[1]
+	}
+	return field, subField, nil
+}
+
+// Check that a sub-field used by a route is defined for its processing code
+func routeSubFieldDefined(pcode string, field int, name string) error {
+	dictionary := getTLVDictionary(pcode, field)
+	if field != 48 || dictionary != nil {
+		if _, ok := findTLVElementByName(dictionary, name); !ok {
+			return &TLVError{Pcode: pcode, Field: field, Reason: fmt.Sprintf("%s is not defined in tlv.yml", name)}
+		}
+		return nil
+	}
+
+	layout, err := getField48Layout(pcode)
+	if err != nil {
+		return err
+	}
+	for _, sub := range layout {
+		if sub.Name == name {
+			return nil
+		}
+	}
+	return &Field48Error{Pcode: pcode, SubField: name, Reason: "not defined in field48.yml"}
+}
+
+// Return sub-fields of a private field, field 48 uses its field48.yml layout unless the
+// processing code has a TLV dictionary for it
+func parseRouteSubFields(pcode string, field int, data string) (map[string]string, error) {
+	if field == 48 && getTLVDictionary(pcode, field) == nil {
+		return parseField48(pcode, data)
+	}
+	return parseTLV(pcode, field, data)
+}
+
+// Return Biller form request for ISO message request using route mapping
+func getJsonRoute(route Route, parsedIso iso8583.IsoStruct) (url.Values, error) {
+
+	log.Printf("Converting %s ISO8583 request to JSON\n", route.Name)
+
+	log.Printf("%s Request (ISO8583): %v\n", route.Name, maskPolicy.iso(isoSpec, parsedIso))
+
+	// Map ISO8583 format to form data
+	emap := parsedIso.Elements.GetElements()
+	subFields := make(map[int]map[string]string)
+	param := url.Values{}
+	for name, source := range route.Request {
+		field, subField, _ := parseRouteSource(source)
+
+		if subField != "" {
+			if _, ok := subFields[field]; !ok {
+				parsed, err := parseRouteSubFields(emap[3], field, emap[int64(field)])
+				if err != nil {
+					return nil, err
+				}
+				subFields[field] = parsed
+			}
+			param.Set(name, subFields[field][subField])
+			continue
+		}
+
+		value := strings.Trim(emap[int64(field)], " ")
+		if _, present := emap[int64(field)]; present && amountFields[field] {
+			amount, err := parseMoney(value)
+			if err != nil {
+				return nil, fmt.Errorf("field %d: %v", field, err)
+			}
+			if currency, ok := emap[49]; ok && currency != currencyIDR {
+				return nil, fmt.Errorf("field 49: unsupported currency %q", currency)
+			}
+			param.Set(name, amount.String())
+			continue
+		}
+		if isoSpec.fields[field].ContentType == "n" {
+			// Numeric field is sent as plain number
+			value = strings.TrimLeft(value, "0")
+			if value == "" && emap[int64(field)] != "" {
+				value = "0"
+			}
+		}
+		param.Set(name, value)
+	}
+
+	log.Println("Convert success")
+	log.Printf("%s Request (JSON): %s\n", route.Name, maskPolicy.json(param))
+	return param, nil
+}
+
+// Return ISO message for Biller JSON response using route mapping
+func getIsoRoute(route Route, pcode string, jsonResponse map[string]interface{}) iso8583.IsoStruct {
+
+	log.Printf("Converting %s JSON Response to ISO8583\n", route.Name)
+	log.Printf("%s Response (JSON): %s\n", route.Name, maskPolicy.json(jsonResponse))
+
+	// Pick mapping by response code
+	mapping := route.Response.Declined
+	if jsonValueString(jsonResponse["rc"]) == "00" {
+		mapping = route.Response.Approved
+	}
+
+	// Assign data to map and add MTI
+	response := make(map[int]string, len(mapping))
+	for field, name := range mapping {
+		value, ok := jsonResponse[name]
+		if !ok || value == nil {
+			continue
+		}
+
+		// Receipt lines use the struk encoding so lines with commas survive
+		if lines, isList := value.([]interface{}); isList && field == strukField {
+			struk := make([]string, len(lines))
+			for i := range lines {
+				struk[i] = jsonValueString(lines[i])
+			}
+			response[strukField], response[strukOverflowField] = encodeStruk(struk)
+			if response[strukOverflowField] == "" {
+				delete(response, strukOverflowField)
+			}
+			continue
+		}
+
+		// Amounts are whole Rupiah, sent with their currency
+		if amountFields[field] {
+			amount, err := moneyFromJSON(value)
+			if err == nil {
+				response[field], err = amount.isoAmount()
+			}
+			if err != nil {
+				log.Printf("Invalid %s response. Error: %s: %v\n", route.Name, name, err)
+				return getIsoError(pcode, "30", fmt.Sprintf("field %d: %v", field, err))
+			}
+			response[49] = currencyIDR
+			continue
+		}
+		response[field] = jsonValueString(value)
+	}
+
+	// Biller fields named like a TLV sub-element go to their private field
+	values := make(map[string]string, len(jsonResponse))
+	for name, value := range jsonResponse {
+		if value != nil {
+			values[name] = jsonValueString(value)
+		}
+	}
+	tlv, err := buildTLVFields(pcode, values)
+	if err != nil {
+		log.Printf("Warning: %s response sub-elements are dropped. Error: %v\n", route.Name, err)
+		metrics.inc("tlv_dropped", "pcode", pcode)
+	}
+	for field, data := range tlv {
+		if _, mapped := response[field]; mapped {
+			log.Printf("Warning: field %d is mapped in route %s, sub-elements are dropped\n", field, route.Name)
+			continue
+		}
+		response[field] = data
+	}
+	mti := "0210"
+
+	// Converting request map to isoStruct
+	isoStruct := getIso(response, mti)
+
+	// Adding PAN for response
+	isoStruct.AddField(3, pcode)
+	log.Println("Convert Success")
+	log.Printf("%s Response (ISO8583): %s\n", route.Name, maskPolicy.iso(isoSpec, isoStruct))
+	return isoStruct
+}
+
+// Return JSON value as ISO field data
+func jsonValueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		lines := make([]string, len(v))
+		for i := range v {
+			lines[i] = jsonValueString(v[i])
+		}
+		return strings.Join(lines, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,142 @@
+package kafkabiller
+
+import (
+	"testing"
+
+	"github.com/mofax/iso8583"
+)
+
+// Route equivalent to the built-in PPOB Inquiry conversion
+var testRoute = Route{
+	ProcessingCode: "380001",
+	Name:           "Test Inquiry",
+	Endpoint:       "/inquiry",
+	Request: map[string]string{
+		"transaction_id": "48.transaction_id",
+		"partner_id":     "48.partner_id",
+		"merchant_code":  "48.merchant_code",
+		"request_time":   "48.request_time",
+		"amount":         "4",
+	},
+	Signature: "$inquiry${transaction_id}${partner_id}${merchant_code}${request_time}${secret}$",
+	Response: RouteResponse{
+		Approved: map[int]string{4: "tagihan", 39: "rc", 43: "nama"},
+		Declined: map[int]string{39: "rc", 120: "msg"},
+	},
+}
+
+func TestRoutesFromFile(t *testing.T) {
+	routes, err := routesFromFile("routes.yml", billerEnv)
+	if err != nil {
+		t.Errorf("routesFromFile() failed. Error: %v", err)
+	}
+
+	route, ok := findRoute(routes, "810001", "0200")
+	if !ok || route.Handler != "ppobPayment" {
+		t.Errorf("findRoute() failed. Expected: ppobPayment. Got: %v", route.Handler)
+	}
+
+	// Built-in routes match any MTI, e.g. a repeated request
+	if route, ok := findRoute(routes, "810001", "0201"); !ok || route.Handler != "ppobPayment" {
+		t.Errorf("findRoute() failed. Expected: ppobPayment for MTI 0201. Got: %v", route.Handler)
+	}
+
+	routes = append([]Route{{ProcessingCode: "810001", MTI: "0400", Name: "Reversal"}}, routes...)
+	if route, _ := findRoute(routes, "810001", "0400"); route.Name != "Reversal" {
+		t.Errorf("findRoute() failed. Expected: route with matching MTI first. Got: %v", route.Name)
+	} else {
+		t.Log("routesFromFile() success")
+	}
+}
+
+func TestRouteValidate(t *testing.T) {
+	route := testRoute
+	route.Signature = "$inquiry${customer_no}${secret}$"
+
+	err := route.validate(billerEnv)
+	expected := "signature uses customer_no which is not in request"
+	if err == nil || err.Error() != expected {
+		t.Errorf("validate() failed. Expected: %v. Got: %v", expected, err)
+	} else {
+		t.Log("validate() success")
+	}
+}
+
+func TestGetJsonRoute(t *testing.T) {
+	isoStruct := iso8583.NewISOStruct("spec1987.yml", true)
+	parsedIso := "0200b000000000010000000000000000000038000100000087330013020" +
+		"21                     USER01          WOM             2                        KIOS01                   2018-05-15 15:10:052020"
+	iso, err := isoStruct.Parse(parsedIso)
+	if err != nil {
+		t.Errorf("Error parsing iso message. Error: %v", err)
+	}
+
+	result, err := getJsonRoute(testRoute, iso)
+	if err != nil {
+		t.Errorf("getJsonRoute() failed. Error: %v", err)
+	}
+
+	expected := map[string]string{
+		"transaction_id": "2021",
+		"partner_id":     "USER01",
+		"amount":         "873300",
+	}
+	for name, value := range expected {
+		if result.Get(name) != value {
+			t.Errorf("getJsonRoute() failed at %v. Expected: %v. Got: %v", name, value, result.Get(name))
+		}
+	}
+}
+
+func TestGetIsoRoute(t *testing.T) {
+	jsonResponse := map[string]interface{}{
+		"rc":      "00",
+		"nama":    "HANAFI",
+		"tagihan": float64(1500),
+		"msg":     "approve",
+	}
+
+	isoResponse := getIsoRoute(testRoute, "380001", jsonResponse)
+
+	expected := "0210b0000000022080000000000000000000380001000000001500002" +
+		"00HANAFI                                  360"
+	result, _ := isoResponse.ToString()
+
+	if result != expected {
+		t.Errorf("getIsoRoute() failed, \nexpected\t: %v, \ngot\t\t\t: %v", expected, result)
+	} else {
+		t.Log("getIsoRoute() success")
+	}
+}
+
+func TestRouteValidateSubField(t *testing.T) {
+	route := testRoute
+	route.Request = map[string]string{"customer_no": "48.customer_number"}
+
+	err := route.validate(billerEnv)
+	expected := "request customer_no: field 48 (380001): customer_number: not defined in field48.yml"
+	if err == nil || err.Error() != expected {
+		t.Errorf("validate() failed. Expected: %v. Got: %v", expected, err)
+	} else {
+		t.Log("validate() sub-field success")
+	}
+}
+
+func TestRouteValidateTLV(t *testing.T) {
+	route := testRoute
+	route.ProcessingCode = "810011"
+	route.Signature = ""
+	route.Request = map[string]string{"token": "126.token"}
+	if err := route.validate(billerEnv); err != nil {
+		t.Errorf("validate() failed. Error: %v", err)
+	}
+
+	route.Request = map[string]string{"token": "126.token_no"}
+	err := route.validate(billerEnv)
+	expected := "request token: field 126 (810011): token_no is not defined in tlv.yml"
+	if err == nil || err.Error() != expected {
+		t.Errorf("validate() failed. Expected: %v. Got: %v", expected, err)
+	} else {
+		t.Log("validate() TLV success")
+	}
+}
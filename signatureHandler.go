@@ -0,0 +1,286 @@
+package kafkabiller
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Placeholder replaced by the secret in a signature template
+const signatureSecret = "secret"
+
+// Signature template per Biller call when a Biller doesn't set its own, {name} is replaced by
+// the request field with that JSON name
+var defaultSignatureTemplates = map[string]string{
+	"inquiry": "$inquiry${transaction_id}${partner_id}${merchant_code}${request_time}${secret}$",
+	"payment": "$payment${transaction_id}${partner_id}${reff_id}${merchant_code}${request_time}${secret}$",
+	"status":  "$status${transaction_id}${partner_id}${reff_id}${merchant_code}${request_time}${secret}$",
+	"buy":     "$buy${transaction_id}${partner_id}${merchant_code}${request_time}${secret}$",
+	"check":   "$check${transaction_id}${partner_id}${merchant_code}${request_time}${secret}$",
+}
+
+// Supported signature algorithms, the result is lowercase hex
+var signatureAlgorithms = map[string]func(secret string, data string) string{
+	"sha256": func(secret string, data string) string {
+		return signatureSHA256(data)
+	},
+	"hmac-sha256": func(secret string, data string) string {
+		return signatureHMAC(sha256.New, secret, data)
+	},
+	"hmac-sha512": func(secret string, data string) string {
+		return signatureHMAC(sha512.New, secret, data)
+	},
+}
+
+// Signature settings of a Biller in biller.yml, a partner scheme overrides the settings it sets
+type SignatureScheme struct {
+	Algorithm string                      `yaml:"Algorithm"`
+	Templates map[string]string           `yaml:"Templates"`
+	Secrets   []*SignatureSecret          `yaml:"Secrets"`
+	Partners  map[string]*SignatureScheme `yaml:"Partners"`
+}
+
+// Secret shared with a Biller, read from an environment variable or a file. From and Until
+// (RFC 3339) bound when it's used so a new secret can overlap the one it replaces.
+type SignatureSecret struct {
+	ID    string `yaml:"ID"`
+	Env   string `yaml:"Env"`
+	File  string `yaml:"File"`
+	From  string `yaml:"From"`
+	Until string `yaml:"Until"`
+
+	value string
+	from  time.Time
+	until time.Time
+}
+
+// Check that the scheme can sign every Biller call, partner schemes get the settings they don't set
+func (s *SignatureScheme) validate() error {
+	if s.Algorithm == "" {
+		s.Algorithm = "sha256"
+	}
+	if _, ok := signatureAlgorithms[s.Algorithm]; !ok {
+		return fmt.Errorf("unknown algorithm %q", s.Algorithm)
+	}
+
+	templates := make(map[string]string, len(defaultSignatureTemplates))
+	for call, template := range defaultSignatureTemplates {
+		templates[call] = template
+	}
+	for call, template := range s.Templates {
+		if _, ok := defaultSignatureTemplates[call]; !ok {
+			return fmt.Errorf("template %s: unknown Biller call", call)
+		}
+		templates[call] = template
+	}
+	s.Templates = templates
+
+	if len(s.Secrets) == 0 {
+		return fmt.Errorf("no secret defined")
+	}
+	for i, secret := range s.Secrets {
+		if err := secret.validate(); err != nil {
+			return fmt.Errorf("secret %d: %v", i+1, err)
+		}
+	}
+
+	for partner, scheme := range s.Partners {
+		if scheme == nil {
+			return fmt.Errorf("partner %s: no settings", partner)
+		}
+		if len(scheme.Partners) > 0 {
+			return fmt.Errorf("partner %s: partners can't be nested", partner)
+		}
+		if scheme.Algorithm == "" {
+			scheme.Algorithm = s.Algorithm
+		}
+		for call, template := range s.Templates {
+			if _, ok := scheme.Templates[call]; !ok {
+				if scheme.Templates == nil {
+					scheme.Templates = make(map[string]string, len(s.Templates))
+				}
+				scheme.Templates[call] = template
+			}
+		}
+		if len(scheme.Secrets) == 0 {
+			scheme.Secrets = s.Secrets
+		}
+		if err := scheme.validate(); err != nil {
+			return fmt.Errorf("partner %s: %v", partner, err)
+		}
+	}
+	return nil
+}
+
+// Check where the secret is read from and when it's used
+func (s *SignatureSecret) validate() error {
+	if s.ID == "" {
+		return fmt.Errorf("secret needs an ID")
+	}
+	if (s.Env == "") == (s.File == "") {
+		return fmt.Errorf("secret %s needs either Env or File", s.ID)
+	}
+
+	var err error
+	if s.From != "" {
+		if s.from, err = time.Parse(time.RFC3339, s.From); err != nil {
+			return fmt.Errorf("secret %s: From: %v", s.ID, err)
+		}
+	}
+	if s.Until != "" {
+		if s.until, err = time.Parse(time.RFC3339, s.Until); err != nil {
+			return fmt.Errorf("secret %s: Until: %v", s.ID, err)
+		}
+	}
+	if !s.from.IsZero() && !s.until.IsZero() && !s.from.Before(s.until) {
+		return fmt.Errorf("secret %s: From must be before Until", s.ID)
+	}
+	return nil
+}
+
+// Read every secret of the scheme and its partners
+func (s *SignatureScheme) load() error {
+	for _, secret := range s.Secrets {
+		if err := secret.load(); err != nil {
+			return err
+		}
+	}
+	for partner, scheme := range s.Partners {
+		if err := scheme.load(); err != nil {
+			return fmt.Errorf("partner %s: %v", partner, err)
+		}
+	}
+	return nil
+}
+
+// Read the secret from its environment variable or file
+func (s *SignatureSecret) load() error {
+	if s.Env != "" {
+		s.value = os.Getenv(s.Env)
+		if s.value == "" {
+			return fmt.Errorf("secret %s: environment variable %s is not set", s.ID, s.Env)
+		}
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(s.File)
+	if err != nil {
+		return fmt.Errorf("secret %s: %v", s.ID, err)
+	}
+	s.value = strings.TrimSpace(string(content))
+	if s.value == "" {
+		return fmt.Errorf("secret %s: %s is empty", s.ID, s.File)
+	}
+	return nil
+}
+
+// Return true if the secret may be used at t
+func (s *SignatureSecret) active(t time.Time) bool {
+	return (s.from.IsZero() || !t.Before(s.from)) && (s.until.IsZero() || t.Before(s.until))
+}
+
+// Return scheme used for a partner
+func (s *SignatureScheme) partner(partnerID string) *SignatureScheme {
+	if scheme, ok := s.Partners[partnerID]; ok {
+		return scheme
+	}
+	return s
+}
+
+// Return every secret that may be used at t, newest first. While two secrets overlap the newest
+// one signs requests.
+func (s *SignatureScheme) secrets(t time.Time) []*SignatureSecret {
+	var result []*SignatureSecret
+	for i := len(s.Secrets) - 1; i >= 0; i-- {
+		if s.Secrets[i].active(t) {
+			result = append(result, s.Secrets[i])
+		}
+	}
+
+	// Secrets listed later win a tie
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].from.After(result[j].from)
+	})
+	return result
+}
+
+// Return signature of a template filled with values, signed with secret
+func (s *SignatureScheme) sign(template string, values map[string]string, secret *SignatureSecret) string {
+	data := signaturePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		if name == signatureSecret {
+			return secret.value
+		}
+		return values[name]
+	})
+	return signatureAlgorithms[s.Algorithm](secret.value, data)
+}
+
+// signTemplate returns signature of a request to the Biller with the partner's scheme and newest secret
+func (c *BillerConnection) signTemplate(template string, partnerID string, values map[string]string) (string, error) {
+	scheme := c.Signature.partner(partnerID)
+	secrets := scheme.secrets(time.Now())
+	if len(secrets) == 0 {
+		return "", fmt.Errorf("no secret is valid now")
+	}
+	signature := scheme.sign(template, values, secrets[0])
+	log.Printf("Signature (%s, secret %s): %s\n", scheme.Algorithm, secrets[0].ID, maskPolicy.jsonValue("signature", signature))
+	return signature, nil
+}
+
+// sign returns signature of a request to a Biller call, fields are filled in by their JSON name
+func (c *BillerConnection) sign(call string, partnerID string, request interface{}) (string, error) {
+	values, err := requestValues(request)
+	if err != nil {
+		return "", &BillerError{Kind: billerRequestFailed, Biller: c.Name, Endpoint: c.Endpoints[call], Err: err}
+	}
+	signature, err := c.signTemplate(c.Signature.partner(partnerID).Templates[call], partnerID, values)
+	if err != nil {
+		return "", &BillerError{Kind: billerRequestFailed, Biller: c.Name, Endpoint: c.Endpoints[call], Err: err}
+	}
+	return signature, nil
+}
+
+// Return fields of a request by JSON name as they are sent to the Biller
+func requestValues(request interface{}) (map[string]string, error) {
+	content, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	return jsonValues(content)
+}
+
+// Return top-level fields of a JSON object as text, numbers keep the digits they were sent with
+func jsonValues(content []byte) (map[string]string, error) {
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(fields))
+	for name, value := range fields {
+		if value != nil {
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+// Return HMAC of data in hex
+func signatureHMAC(h func() hash.Hash, secret string, data string) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(data))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
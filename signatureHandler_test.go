@@ -0,0 +1,108 @@
+package kafkabiller
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// Return connection signing with a scheme, the scheme's secrets are read from files
+func testSignedConnection(t *testing.T, scheme SignatureScheme) *BillerConnection {
+	conn := &BillerConnection{Name: "test", BaseURL: "http://127.0.0.1:1", ConnectTimeout: time.Second, ReadTimeout: time.Second, Signature: scheme}
+	if err := conn.validate("test"); err != nil {
+		t.Fatalf("BillerConnection.validate() failed. Error: %v", err)
+	}
+	if err := conn.connect(); err != nil {
+		t.Fatalf("BillerConnection.connect() failed. Error: %v", err)
+	}
+	return conn
+}
+
+func TestBillerConnectionSign(t *testing.T) {
+	conn := testSignedConnection(t, testSignature())
+
+	// Same signatures as before the secret was moved out of the source
+	tests := []struct {
+		call     string
+		request  interface{}
+		expected string
+	}{
+		{"inquiry", PPOBInquiryRequest{TransactionID: "2021", PartnerID: "USER01", MerchantCode: "KIOS01", RequestTime: "2018-05-15 15:10:05"},
+			"41146b35700a4f6c05bd9dfd9da52b63f308050baa61d395e4f78a7d1625d70a"},
+		{"payment", PPOBPaymentRequest{TransactionID: "2015", PartnerID: "USER01", ReffID: "12345", MerchantCode: "KIOS01", RequestTime: "2018-05-15 15:10:05", Amount: 873300},
+			"2ba6d0fba94ea4d189af60ed83f286ada47f3d442d232458ab6ea1ff76ef93fb"},
+	}
+	for _, test := range tests {
+		result, err := conn.sign(test.call, "USER01", test.request)
+		if err != nil || result != test.expected {
+			t.Errorf("%v: BillerConnection.sign() failed. Expected: %v. Got: %v (%v)", test.call, test.expected, result, err)
+		} else {
+			t.Logf("%v: BillerConnection.sign() success", test.call)
+		}
+	}
+
+	// Custom route template
+	result, _ := conn.signTemplate(testRoute.Signature, "USER01", map[string]string{
+		"transaction_id": "2021", "partner_id": "USER01", "merchant_code": "KIOS01", "request_time": "2018-05-15 15:10:05",
+	})
+	if result != tests[0].expected {
+		t.Errorf("BillerConnection.signTemplate() failed. Expected: %v. Got: %v", tests[0].expected, result)
+	}
+}
+
+func TestSignatureSchemePartner(t *testing.T) {
+	now := time.Now()
+	scheme := testSignature()
+	scheme.Partners = map[string]*SignatureScheme{
+		"P2": {
+			Algorithm: "hmac-sha256",
+			Templates: map[string]string{"check": "{transaction_id}"},
+			Secrets: []*SignatureSecret{
+				{ID: "old", Env: "CHIPSAKTI_TEST_OLD", Until: now.Add(time.Hour).Format(time.RFC3339)},
+				{ID: "new", Env: "CHIPSAKTI_TEST_NEW", From: now.Add(-time.Hour).Format(time.RFC3339)},
+				{ID: "next", Env: "CHIPSAKTI_TEST_NEW", From: now.Add(24 * time.Hour).Format(time.RFC3339)},
+			},
+		},
+	}
+	os.Setenv("CHIPSAKTI_TEST_OLD", "old")
+	os.Setenv("CHIPSAKTI_TEST_NEW", "key")
+	defer os.Unsetenv("CHIPSAKTI_TEST_OLD")
+	defer os.Unsetenv("CHIPSAKTI_TEST_NEW")
+	conn := testSignedConnection(t, scheme)
+
+	// HMAC-SHA256 with key "key" of "1", signed with the newest of the two overlapping secrets
+	expected := "6da91fb91517be1f5cdcf3af91d7d40c717dd638a306157606fb2e584f7ae926"
+	result, err := conn.sign("check", "P2", TopupCheckRequest{TransactionID: "1"})
+	if err != nil || result != expected {
+		t.Errorf("BillerConnection.sign() failed. Expected: %v. Got: %v (%v)", expected, result, err)
+	}
+
+	secrets := conn.Signature.partner("P2").secrets(now)
+	if len(secrets) != 2 || secrets[0].ID != "new" || secrets[1].ID != "old" {
+		t.Errorf("SignatureScheme.secrets() failed. Expected: [new old]. Got: %v", secrets)
+	}
+
+	// Other partners keep the Biller's scheme and partner templates fall back to the Biller's
+	if conn.Signature.partner("P1") != &conn.Signature || conn.Signature.partner("P2").Templates["inquiry"] != defaultSignatureTemplates["inquiry"] {
+		t.Errorf("SignatureScheme.partner() failed. Expected: Biller scheme for P1 and inherited templates for P2")
+	} else {
+		t.Log("SignatureScheme partner success")
+	}
+}
+
+func TestSignatureSchemeValidate(t *testing.T) {
+	invalid := []SignatureScheme{
+		{Algorithm: "md5", Secrets: []*SignatureSecret{{ID: "a", Env: "A"}}},
+		{Templates: map[string]string{"refund": "{secret}"}, Secrets: []*SignatureSecret{{ID: "a", Env: "A"}}},
+		{},
+		{Secrets: []*SignatureSecret{{ID: "a", Env: "A", File: "a.secret"}}},
+		{Secrets: []*SignatureSecret{{ID: "a", Env: "A", From: "2026-11-01T00:00:00Z", Until: "2026-10-01T00:00:00Z"}}},
+		{Secrets: []*SignatureSecret{{ID: "a", Env: "A", From: "yesterday"}}},
+	}
+	for i, scheme := range invalid {
+		if err := scheme.validate(); err == nil {
+			t.Errorf("SignatureScheme.validate() %d failed. Expected: error. Got: nil", i)
+		}
+	}
+	t.Log("SignatureScheme.validate() success")
+}
@@ -0,0 +1,309 @@
+package kafkabiller
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/gorilla/mux"
+)
+
+// Usage of the simulator, run as `biller-simulator` from the service directory
+const simulatorUsage = `Usage: biller-simulator [flags]
+
+Runs a local Biller serving /inquiry, /payment, /status, /buy and /check with the ChipSakti
+contract. Responses per customer_no are scripted in the scenario file, select the "local"
+environment in biller.yml (CHIPSAKTI_BILLER_ENV=local) to send the service's requests to it.
+`
+
+// Struct for simulator.yml
+type SimulatorConfig struct {
+	Default   SimulatorScenario             `yaml:"Default"`
+	Scenarios map[string]*SimulatorScenario `yaml:"Scenarios"`
+}
+
+// Response of the simulator for a customer number
+type SimulatorScenario struct {
+	Calls     []string      `yaml:"Calls"`
+	RC        string        `yaml:"RC"`
+	Msg       string        `yaml:"Msg"`
+	Delay     time.Duration `yaml:"Delay"`
+	Timeout   bool          `yaml:"Timeout"`
+	Malformed bool          `yaml:"Malformed"`
+	Status    int           `yaml:"Status"`
+	Pending   int           `yaml:"Pending"`
+	Tagihan   Money         `yaml:"Tagihan"`
+	Admin     Money         `yaml:"Admin"`
+	Price     Money         `yaml:"Price"`
+}
+
+// simulator answers Biller calls from its scenarios, safe for concurrent use
+type simulator struct {
+	config SimulatorConfig
+
+	// Masking of customer data in the call log
+	mask *MaskPolicy
+
+	// Calls per transaction ID, counted for pending scenarios
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+// Format of restime and tgl_lunas in Biller responses
+const simulatorTimeFormat = "2006-01-02 15:04:05"
+
+// Return simulator config from a yaml file
+func simulatorConfigFromFile(filename string) (SimulatorConfig, error) {
+	var config SimulatorConfig
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return config, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if len(config.Default.Calls) > 0 {
+		return config, fmt.Errorf("%s: default scenario applies to every call", filename)
+	}
+	if err := config.Default.validate(); err != nil {
+		return config, fmt.Errorf("%s: default: %v", filename, err)
+	}
+	for customerNo, scenario := range config.Scenarios {
+		if scenario == nil {
+			return config, fmt.Errorf("%s: %s: no settings", filename, customerNo)
+		}
+		if err := scenario.validate(); err != nil {
+			return config, fmt.Errorf("%s: %s: %v", filename, customerNo, err)
+		}
+	}
+	return config, nil
+}
+
+// Check that the scenario can be played, a missing rc is a success
+func (s *SimulatorScenario) validate() error {
+	for _, call := range s.Calls {
+		if _, ok := defaultSignatureTemplates[call]; !ok {
+			return fmt.Errorf("unknown call %s", call)
+		}
+	}
+	if s.RC == "" {
+		s.RC = "00"
+	}
+	if s.Status != 0 && (s.Status < 100 || s.Status > 599) {
+		return fmt.Errorf("invalid HTTP status %d", s.Status)
+	}
+	if s.Delay < 0 || s.Pending < 0 || s.Tagihan < 0 || s.Admin < 0 || s.Price < 0 {
+		return fmt.Errorf("delay, pending and amounts can't be negative")
+	}
+	if s.Timeout && s.Malformed {
+		return fmt.Errorf("scenario can't both time out and be malformed")
+	}
+	return nil
+}
+
+// RunSimulator runs the simulator until it fails, it only loads its scenario file and masking policy
+func RunSimulator(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("biller-simulator", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	fs.Usage = func() {
+		fmt.Fprint(stdout, simulatorUsage, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	address := fs.String("addr", "localhost:6030", "address to listen on")
+	filename := fs.String("scenarios", "simulator.yml", "scenario file")
+	maskFile := fs.String("mask", "maskPolicy.yml", "masking policy of the call log")
+	verbose := fs.Bool("v", false, "log every call to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := simulatorConfigFromFile(*filename)
+	if err != nil {
+		return err
+	}
+	mask, err := maskPolicyFromFile(*maskFile)
+	if err != nil {
+		return err
+	}
+	if *verbose {
+		log.SetOutput(stdout)
+	} else {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	fmt.Fprintf(stdout, "Biller simulator listening on %s with %d scenarios\n", *address, len(config.Scenarios))
+	return http.ListenAndServe(*address, newSimulator(config, mask).router())
+}
+
+// Return new simulator playing the scenarios of config, customer data is logged masked with mask
+func newSimulator(config SimulatorConfig, mask *MaskPolicy) *simulator {
+	return &simulator{config: config, mask: mask, calls: make(map[string]int)}
+}
+
+// Return HTTP handler with every Biller call at its default path
+func (s *simulator) router() *mux.Router {
+	router := mux.NewRouter()
+	for call := range defaultSignatureTemplates {
+		router.HandleFunc("/"+call, s.handle(call)).Methods("POST")
+	}
+	return router
+}
+
+// Return scenario of a call for a customer number
+func (s *simulator) scenario(call string, customerNo string) SimulatorScenario {
+	scenario, ok := s.config.Scenarios[customerNo]
+	if !ok {
+		return s.config.Default
+	}
+	if len(scenario.Calls) == 0 {
+		return *scenario
+	}
+	for _, scenarioCall := range scenario.Calls {
+		if scenarioCall == call {
+			return *scenario
+		}
+	}
+	return s.config.Default
+}
+
+// Return rc of a pending scenario, 68 for the first Pending calls of the transaction and the
+// scenario's rc after that
+func (s *simulator) pending(transactionID string, scenario SimulatorScenario) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[transactionID]++
+	if s.calls[transactionID] <= scenario.Pending {
+		return "68"
+	}
+	return scenario.RC
+}
+
+// Return handler of a Biller call
+func (s *simulator) handle(call string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, err := simulatorRequest(r)
+		if err != nil {
+			log.Printf("Simulator %s: invalid request. Error: %v\n", call, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		scenario := s.scenario(call, request["customer_no"])
+		rc := scenario.RC
+		if scenario.Pending > 0 {
+			rc = s.pending(request["transaction_id"], scenario)
+		}
+		log.Printf("Simulator %s: customer %s, transaction %s, rc %s\n", call, s.mask.jsonValue("customer_no", request["customer_no"]),
+			request["transaction_id"], rc)
+
+		if scenario.Delay > 0 {
+			time.Sleep(scenario.Delay)
+		}
+
+		// Hold the call until the client gives up
+		if scenario.Timeout {
+			<-r.Context().Done()
+			return
+		}
+
+		if scenario.Status != 0 && scenario.Status != http.StatusOK {
+			w.WriteHeader(scenario.Status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if scenario.Malformed {
+			fmt.Fprint(w, `{"rc":"`+rc+`","msg":`)
+			return
+		}
+		json.NewEncoder(w).Encode(simulatorResponse(call, request, rc, scenario))
+	}
+}
+
+// Return fields of a form or JSON request
+func simulatorRequest(r *http.Request) (map[string]string, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		content, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return jsonValues(content)
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	request := make(map[string]string, len(r.PostForm))
+	for name := range r.PostForm {
+		request[name] = r.PostForm.Get(name)
+	}
+	return request, nil
+}
+
+// Return response of a Biller call in the shape of model.go, a declined call only has rc, msg and restime
+func simulatorResponse(call string, request map[string]string, rc string, scenario SimulatorScenario) interface{} {
+	restime := time.Now().Format(simulatorTimeFormat)
+	msg := scenario.Msg
+	switch {
+	case rc == "68":
+		msg = "TRANSAKSI SEDANG DIPROSES"
+	case msg == "" && rc == "00":
+		msg = "SUKSES"
+	case msg == "":
+		msg = "GAGAL"
+	}
+	if rc != "00" {
+		return UnsuccessfulChipsakti{Rc: rc, Msg: msg, Restime: restime}
+	}
+
+	tagihan, admin, price := scenario.Tagihan, scenario.Admin, scenario.Price
+	if tagihan == 0 {
+		tagihan = 100000
+	}
+	if admin == 0 {
+		admin = 2500
+	}
+	if price == 0 {
+		price = 10150
+	}
+	if amount, err := parseMoney(request["amount"]); err == nil && amount > admin {
+		tagihan = amount - admin
+	}
+	reffid := "SIM" + request["transaction_id"]
+	nama := "PELANGGAN " + request["customer_no"]
+	struk := []string{
+		"STRUK PEMBAYARAN " + request["product_code"],
+		"IDPEL: " + request["customer_no"],
+		"NAMA: " + nama,
+		"TOTAL: " + (tagihan + admin).String(),
+	}
+
+	switch call {
+	case "inquiry":
+		return PPOBInquiryResponse{Rc: rc, Msg: msg, Produk: request["product_code"], Nopel: request["customer_no"], Nama: nama,
+			Tagihan: tagihan, Admin: admin, TotalTagihan: tagihan + admin, Reffid: reffid, Restime: restime}
+	case "payment":
+		return PPOBPaymentResponse{Rc: rc, Msg: msg, Produk: request["product_code"], Nopel: request["customer_no"], Nama: nama,
+			Tagihan: tagihan, Admin: admin, TotalTagihan: tagihan + admin, Reffid: reffid, TglLunas: restime, Struk: struk,
+			ReffNo: request["reff_id"], Restime: restime}
+	case "status":
+		return PPOBStatusResponse{Rc: rc, Msg: msg, Produk: request["product_code"], Nopel: request["customer_no"], Nama: nama,
+			Tagihan: tagihan, Admin: admin, TotalTagihan: tagihan + admin, Reffid: reffid, TglLunas: restime, Struk: struk,
+			ReffNo: request["reff_id"], Status: "SUKSES", Restime: restime}
+	case "buy":
+		return TopupBuyResponse{Rc: rc, Msg: msg, Restime: restime, SN: "SN" + request["transaction_id"], Price: price}
+	default:
+		return TopupCheckResponse{Rc: rc, Msg: msg, Restime: restime, SN: "SN" + request["transaction_id"], Price: price}
+	}
+}
@@ -0,0 +1,90 @@
+package kafkabiller
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulator(t *testing.T) {
+	config, err := simulatorConfigFromFile("simulator.yml")
+	if err != nil {
+		t.Fatalf("simulatorConfigFromFile() failed. Error: %v", err)
+	}
+	server := httptest.NewServer(newSimulator(config, maskPolicy).router())
+	defer server.Close()
+
+	for _, billerType := range []string{"chipsakti", "json"} {
+		biller := testBiller(t, billerType, billerType, server.URL, 200*time.Millisecond)
+
+		inquiry, err := biller.Inquiry(PPOBInquiryRequest{TransactionID: "1", CustomerNo: "081200000000", ProductCode: "PLNPASCA"})
+		if err != nil || inquiry.Rc != "00" || inquiry.Tagihan != 250000 || inquiry.TotalTagihan != 252500 || inquiry.Nopel != "081200000000" {
+			t.Errorf("%v: simulator inquiry failed. Expected: rc 00, tagihan 250000. Got: %+v (%v)", billerType, inquiry, err)
+		}
+
+		payment, err := biller.Payment(PPOBPaymentRequest{TransactionID: "2", CustomerNo: "081200000000", Amount: 102500})
+		if err != nil || payment.Rc != "00" || payment.Tagihan != 100000 || len(payment.Struk) == 0 {
+			t.Errorf("%v: simulator payment failed. Expected: rc 00, tagihan 100000 and struk. Got: %+v (%v)", billerType, payment, err)
+		}
+
+		declined, err := biller.TopupBuy(TopupBuyRequest{TransactionID: "3", CustomerNo: "081200000014"})
+		if err != nil || declined.Rc != "14" || declined.SN != "" {
+			t.Errorf("%v: simulator decline failed. Expected: rc 14 without sn. Got: %+v (%v)", billerType, declined, err)
+		}
+
+		// Scenario limited to payment and buy
+		if inquiry, err := biller.Inquiry(PPOBInquiryRequest{TransactionID: "4", CustomerNo: "081200000005"}); err != nil || inquiry.Rc != "00" {
+			t.Errorf("%v: simulator calls failed. Expected: default scenario for inquiry. Got: %+v (%v)", billerType, inquiry, err)
+		}
+
+		// Failures map to the service's response codes
+		failures := map[string]string{"081200000068": "68", "081200000096": "96", "081200000091": "91"}
+		for customerNo, rc := range failures {
+			_, err := biller.TopupBuy(TopupBuyRequest{TransactionID: "5", CustomerNo: customerNo})
+			if billerResponseCode(err) != rc || err == nil {
+				t.Errorf("%v: simulator %s failed. Expected: rc %s. Got: %v", billerType, customerNo, rc, err)
+			}
+		}
+
+		// Pending on the buy and first check, then success
+		var rcs []string
+		buy, _ := biller.TopupBuy(TopupBuyRequest{TransactionID: "pending-" + billerType, CustomerNo: "081200000777"})
+		rcs = append(rcs, buy.Rc)
+		for i := 0; i < 2; i++ {
+			check, _ := biller.TopupCheck(TopupCheckRequest{TransactionID: "pending-" + billerType, CustomerNo: "081200000777"})
+			rcs = append(rcs, check.Rc)
+		}
+		if rcs[0] != "68" || rcs[1] != "68" || rcs[2] != "00" {
+			t.Errorf("%v: simulator pending failed. Expected: [68 68 00]. Got: %v", billerType, rcs)
+		}
+	}
+	t.Log("simulator success")
+}
+
+func TestSimulatorLogMasked(t *testing.T) {
+	config, err := simulatorConfigFromFile("simulator.yml")
+	if err != nil {
+		t.Fatalf("simulatorConfigFromFile() failed. Error: %v", err)
+	}
+	server := httptest.NewServer(newSimulator(config, maskPolicy).router())
+	defer server.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	biller := testBiller(t, "chipsakti", "chipsakti", server.URL, time.Second)
+	if _, err := biller.Inquiry(PPOBInquiryRequest{TransactionID: "1", CustomerNo: "081234567890"}); err != nil {
+		t.Fatalf("simulator inquiry failed. Error: %v", err)
+	}
+
+	line := logs.String()
+	if strings.Contains(line, "081234567890") || !strings.Contains(line, "customer ********7890") {
+		t.Errorf("simulator log failed. Expected: customer ********7890. Got: %q", line)
+	} else {
+		t.Log("simulator log masked success")
+	}
+}
@@ -0,0 +1,260 @@
+package kafkabiller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/go-yaml/yaml"
+	"github.com/mofax/iso8583"
+)
+
+// ISO8583 spec shared by parsing and building, loaded from spec1987.yml at startup
+var isoSpec *Spec
+
+// Content types and length types allowed in a spec file
+var (
+	specContentTypes = map[string]bool{"a": true, "n": true, "s": true, "an": true, "as": true, "ns": true, "ans": true, "b": true, "z": true, "x+n": true}
+	specLenTypes     = map[string]bool{"fixed": true, "llvar": true, "lllvar": true, "llllvar": true}
+)
+
+// Spec contains a structured description of an iso8583 spec
+// properly defined by a spec file. It must not be modified after it's loaded
+type Spec struct {
+	fields   map[int]fieldDescription
+	template iso8583.IsoStruct // empty message carrying the spec for the iso8583 library
+}
+
+// readFromFile reads a yaml specfile and loads
+// and iso8583 spec from it
+func (s *Spec) readFromFile(filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return s.load(filename, content)
+}
+
+// load loads the field descriptions from the yaml content of specfile filename
+func (s *Spec) load(filename string, content []byte) error {
+	if err := yaml.Unmarshal(content, &s.fields); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// validate checks every field description of the spec
+func (s *Spec) validate() error {
+	if len(s.fields) == 0 {
+		return fmt.Errorf("spec has no fields")
+	}
+
+	if problems := s.problems(); len(problems) > 0 {
+		return fmt.Errorf("invalid spec: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// problems returns every invalid or missing field description, sorted by field
+func (s *Spec) problems() []string {
+	fields := make([]int, 0, len(s.fields))
+	for field := range s.fields {
+		fields = append(fields, field)
+	}
+	sort.Ints(fields)
+
+	var problems []string
+	for _, field := range fields {
+		description := s.fields[field]
+		switch {
+		case field < 0 || field > 128:
+			problems = append(problems, fmt.Sprintf("field %d: out of range", field))
+		case !specContentTypes[description.ContentType]:
+			problems = append(problems, fmt.Sprintf("field %d: invalid ContentType %q", field, description.ContentType))
+		case !specLenTypes[description.LenType]:
+			problems = append(problems, fmt.Sprintf("field %d: invalid LenType %q", field, description.LenType))
+		case description.MaxLen <= 0:
+			problems = append(problems, fmt.Sprintf("field %d: MaxLen must be positive", field))
+		case description.MinLen < 0 || description.MinLen > description.MaxLen:
+			problems = append(problems, fmt.Sprintf("field %d: MinLen must be between 0 and MaxLen", field))
+		}
+	}
+	for field := 2; field <= 128; field++ {
+		if _, ok := s.fields[field]; !ok {
+			problems = append(problems, fmt.Sprintf("field %d: not defined", field))
+		}
+	}
+	return problems
+}
+
+// SpecFromFile returns a brand new spec, validated and ready to parse and build messages
+func specFromFile(filename string) (*Spec, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spec{}
+	if err := s.load(filename, content); err != nil {
+		return nil, err
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if s.template, err = isoTemplate(content); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return s, nil
+}
+
+// isoTemplate returns an empty message of the iso8583 library carrying the spec of content. The
+// library only loads its spec from file and ignores what it can't read, so it gets a private copy
+// of the content validated here instead of the specfile, which may have changed since
+func isoTemplate(content []byte) (template iso8583.IsoStruct, err error) {
+	file, err := ioutil.TempFile("", "spec*.yml")
+	if err != nil {
+		return template, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return template, err
+	}
+
+	// The library panics when it can't read the file
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("iso8583 library can't load the spec: %v", r)
+		}
+	}()
+	return iso8583.NewISOStruct(file.Name(), true), nil
+}
+
+// parse parses an ISO8583 message using the spec
+func (s *Spec) parse(message string) (iso iso8583.IsoStruct, err error) {
+	// The iso8583 library slices the message without checking its length
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed ISO8583 message: %v", r)
+		}
+	}()
+	return s.template.Parse(message)
+}
+
+// newIso returns an empty ISO8583 message using the spec
+func (s *Spec) newIso() iso8583.IsoStruct {
+	// Parsing a message without fields gives a fresh element map sharing the loaded spec
+	iso, _ := s.template.Parse("0000" + "80000000000000000000000000000000")
+	iso.Mti = iso8583.MtiType{}
+	return iso
+}
+
+// FieldProblem describes why a single field doesn't match the spec
+type FieldProblem struct {
+	Field  int
+	Reason string
+}
+
+// ValidationError lists every field of a message that doesn't match the spec
+type ValidationError struct {
+	Problems []FieldProblem
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		problems[i] = fmt.Sprintf("%d (%s)", problem.Field, problem.Reason)
+	}
+	return "invalid fields: " + strings.Join(problems, ", ")
+}
+
+// validateMessage checks every present field against its description and that mandatory fields are present
+func (s *Spec) validateMessage(iso iso8583.IsoStruct, mandatory []int) error {
+	elements := iso.Elements.GetElements()
+	var problems []FieldProblem
+
+	for field, data := range elements {
+		description, ok := s.fields[int(field)]
+		if !ok {
+			problems = append(problems, FieldProblem{int(field), "not defined in spec"})
+			continue
+		}
+		if reason := description.check(data); reason != "" {
+			problems = append(problems, FieldProblem{int(field), reason})
+		}
+	}
+	for _, field := range mandatory {
+		if _, ok := elements[int64(field)]; !ok {
+			problems = append(problems, FieldProblem{field, "mandatory field is missing"})
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Field < problems[j].Field })
+	return &ValidationError{problems}
+}
+
+// check returns why data doesn't match the field description, or empty string if it does
+func (d fieldDescription) check(data string) string {
+	length := len(data)
+	if d.LenType == "fixed" {
+		if length != d.MaxLen {
+			return fmt.Sprintf("expected length %d, found %d", d.MaxLen, length)
+		}
+	} else if length < d.MinLen || length > d.MaxLen {
+		return fmt.Sprintf("expected length %d to %d, found %d", d.MinLen, d.MaxLen, length)
+	}
+
+	if !validContent(d.ContentType, data) {
+		return fmt.Sprintf("expected content type %s", d.ContentType)
+	}
+	return ""
+}
+
+// validContent checks that every character of data is allowed by the content type,
+// space is allowed in every non-numeric type as it's used for padding
+func validContent(contentType string, data string) bool {
+	if contentType == "x+n" {
+		return len(data) > 1 && (data[0] == 'C' || data[0] == 'D') && validContent("n", data[1:])
+	}
+
+	for _, r := range data {
+		var ok bool
+		switch contentType {
+		case "n":
+			ok = r >= '0' && r <= '9'
+		case "b":
+			ok = (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+		case "z":
+			ok = (r >= '0' && r <= '9') || r == '=' || r == 'D' || r == 'd'
+		case "a":
+			ok = isLetter(r) || r == ' '
+		case "s":
+			ok = isSpecial(r) || r == ' '
+		case "an":
+			ok = isLetter(r) || isDigit(r) || r == ' '
+		case "as":
+			ok = isLetter(r) || isSpecial(r) || r == ' '
+		case "ns":
+			ok = isDigit(r) || isSpecial(r) || r == ' '
+		case "ans":
+			ok = unicode.IsPrint(r)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetter(r rune) bool  { return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') }
+func isDigit(r rune) bool   { return r >= '0' && r <= '9' }
+func isSpecial(r rune) bool { return r > ' ' && r < 0x7f && !isLetter(r) && !isDigit(r) }
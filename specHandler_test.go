@@ -0,0 +1,103 @@
+package kafkabiller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpecFromFileInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "spec.yml")
+	content := "3:\n  ContentType: \"n\"\n  Label: Processing code\n  LenType: fixd\n  MaxLen: 6\n"
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = specFromFile(filename)
+	if err == nil || !strings.Contains(err.Error(), `field 3: invalid LenType "fixd"`) {
+		t.Errorf("specFromFile() failed. Expected invalid LenType error. Got: %v", err)
+	} else {
+		t.Log("specFromFile() success")
+	}
+}
+
+func TestIsoTemplate(t *testing.T) {
+	content, err := ioutil.ReadFile("spec1987.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := isoTemplate(content)
+	if err != nil {
+		t.Fatalf("isoTemplate() failed. Error: %v", err)
+	}
+	message := "0200b000000000010000000000000000000038000100000087330013020" +
+		"21                     USER01          WOM             2                        KIOS01                   2018-05-15 15:10:052020"
+	parsed, err := template.Parse(message)
+	if err != nil || parsed.Elements.GetElements()[3] != "380001" {
+		t.Errorf("isoTemplate() failed. Expected: template parsing pcode 380001. Got: %v (%v)", parsed.Elements.GetElements(), err)
+	}
+
+	// A copy that can't be written is an error, not a template without fields
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", filepath.Join(os.TempDir(), "missing", "dir"))
+	if _, err := isoTemplate(content); err == nil {
+		t.Errorf("isoTemplate() failed. Expected: error without a temp directory")
+	} else {
+		t.Log("isoTemplate() success")
+	}
+}
+
+func TestSpecNewIso(t *testing.T) {
+	first := isoSpec.newIso()
+	first.AddMTI("0200")
+	first.AddField(3, "380001")
+
+	second := isoSpec.newIso()
+	if len(second.Elements.GetElements()) != 0 || second.Mti.String() != "" {
+		t.Errorf("newIso() failed. Expected empty message. Got: %v %v", second.Mti.String(), second.Elements.GetElements())
+	} else {
+		t.Log("newIso() success")
+	}
+}
+
+func TestSpecParseMalformed(t *testing.T) {
+	_, err := isoSpec.parse("0200a0000000000100000000000000000000380001130")
+	if err == nil {
+		t.Errorf("parse() failed. Expected error for truncated message")
+	} else {
+		t.Log("parse() success")
+	}
+}
+
+func TestSpecValidateMessage(t *testing.T) {
+	valid := "0200b000000008010000000000000000000081000100000087330012345       1262015                     USER01          WOM             2                        KIOS01                   2018-05-15 15:10:05"
+	iso, err := isoSpec.parse(valid)
+	if err != nil {
+		t.Errorf("Error parsing iso message. Error: %v", err)
+	}
+	if err := isoSpec.validateMessage(iso, []int{3, 4, 37, 48}); err != nil {
+		t.Errorf("validateMessage() failed. Expected valid message. Got: %v", err)
+	}
+
+	// Amount isn't numeric and retrieval reference number is missing
+	invalid := "0200b000000000010000000000000000000081000100000087330X1262015                     USER01          WOM             2                        KIOS01                   2018-05-15 15:10:05"
+	iso, err = isoSpec.parse(invalid)
+	if err != nil {
+		t.Errorf("Error parsing iso message. Error: %v", err)
+	}
+	err = isoSpec.validateMessage(iso, []int{3, 4, 37, 48})
+	expected := "invalid fields: 4 (expected content type n), 37 (mandatory field is missing)"
+	if err == nil || err.Error() != expected {
+		t.Errorf("validateMessage() failed. \nExpected\t: %v. \nGot\t\t: %v", expected, err)
+	} else {
+		t.Log("validateMessage() success")
+	}
+}
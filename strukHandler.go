@@ -0,0 +1,95 @@
+package kafkabiller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Receipt (struk) lines are sent in field 62 and continue in field 63 when they don't fit
+const (
+	strukField         = 62
+	strukOverflowField = 63
+	strukMaxLen        = 999
+)
+
+// Struct for POST /struk/decode request
+type StrukDecodeRequest struct {
+	Field62 string `json:"62"`
+	Field63 string `json:"63"`
+}
+
+// Struct for POST /struk/decode response
+type StrukDecodeResponse struct {
+	Struk []string `json:"struk,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// encodeStruk returns receipt lines as a sequence of 3 digit byte length followed by the line,
+// e.g. ["A,B", ""] is "003A,B000". Lines fill field 62 and continue in field 63, a line is never
+// split across fields so each field can be decoded on its own
+func encodeStruk(lines []string) (field62 string, field63 string) {
+	fields := []string{"", ""}
+	current := 0
+
+	for i, line := range lines {
+		if len(line) > strukMaxLen-3 {
+			log.Printf("Warning: struk line %d is %d characters, longer than %d. Line is truncated\n", i, len(line), strukMaxLen-3)
+			line = truncateBytes(line, strukMaxLen-3)
+		}
+		element := fmt.Sprintf("%03d", len(line)) + line
+
+		if len(fields[current])+len(element) > strukMaxLen {
+			current++
+		}
+		if current == len(fields) {
+			log.Printf("Warning: struk doesn't fit in fields %d and %d, %d of %d lines are dropped\n",
+				strukField, strukOverflowField, len(lines)-i, len(lines))
+			metrics.inc("struk_lines_dropped")
+			break
+		}
+		fields[current] += element
+	}
+
+	return fields[0], fields[1]
+}
+
+// decodeStruk returns receipt lines encoded by encodeStruk. Field 63 continues field 62,
+// so a receipt in both fields is decoded from field 62 followed by field 63
+func decodeStruk(data string) ([]string, error) {
+	lines := make([]string, 0)
+	for pos := 0; pos < len(data); {
+		if pos+3 > len(data) {
+			return nil, fmt.Errorf("struk line %d: expected 3 digit length at offset %d", len(lines), pos)
+		}
+		length, err := strconv.Atoi(data[pos : pos+3])
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("struk line %d: invalid length %q at offset %d", len(lines), data[pos:pos+3], pos)
+		}
+		pos += 3
+		if pos+length > len(data) {
+			return nil, fmt.Errorf("struk line %d: expected %d characters at offset %d, found %d", len(lines), length, pos, len(data)-pos)
+		}
+		lines = append(lines, data[pos:pos+length])
+		pos += length
+	}
+	return lines, nil
+}
+
+// Return receipt lines of fields 62 and 63, so channels can check their own decoder
+func postStrukDecode(w http.ResponseWriter, r *http.Request) {
+	var request StrukDecodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		jsonFormatter(w, StrukDecodeResponse{Error: "invalid request: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	lines, err := decodeStruk(request.Field62 + request.Field63)
+	if err != nil {
+		jsonFormatter(w, StrukDecodeResponse{Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	jsonFormatter(w, StrukDecodeResponse{Struk: lines}, http.StatusOK)
+}
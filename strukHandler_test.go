@@ -0,0 +1,71 @@
+package kafkabiller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStrukRoundTrip(t *testing.T) {
+	lines := []string{"TAGIHAN : Rp 870,000", "", "  TERIMA KASIH  "}
+
+	field62, field63 := encodeStruk(lines)
+	expected := "020TAGIHAN : Rp 870,000000016  TERIMA KASIH  "
+	if field62 != expected || field63 != "" {
+		t.Errorf("encodeStruk() failed. Expected: %q. Got: %q, %q", expected, field62, field63)
+	}
+
+	result, err := decodeStruk(field62 + field63)
+	if err != nil || !reflect.DeepEqual(result, lines) {
+		t.Errorf("decodeStruk() failed. Expected: %q. Got: %q (%v)", lines, result, err)
+	} else {
+		t.Log("Struk round trip success")
+	}
+}
+
+func TestStrukOverflow(t *testing.T) {
+	// 30 lines of 50 characters don't fit in 999 characters
+	lines := make([]string, 30)
+	for i := range lines {
+		lines[i] = strings.Repeat(string(rune('A'+i%26)), 50)
+	}
+
+	field62, field63 := encodeStruk(lines)
+	if len(field62) > strukMaxLen || field63 == "" {
+		t.Errorf("encodeStruk() failed. Expected split across fields 62 and 63. Got lengths: %d, %d", len(field62), len(field63))
+	}
+
+	// Each field holds whole lines
+	first, err := decodeStruk(field62)
+	if err != nil {
+		t.Errorf("decodeStruk() of field 62 failed. Error: %v", err)
+	}
+	result, err := decodeStruk(field62 + field63)
+	if err != nil || !reflect.DeepEqual(result, lines) || len(first) != 18 {
+		t.Errorf("decodeStruk() failed. Expected %d lines, 18 in field 62. Got: %d, %d in field 62 (%v)", len(lines), len(result), len(first), err)
+	} else {
+		t.Log("Struk overflow success")
+	}
+}
+
+func TestDecodeStrukInvalid(t *testing.T) {
+	_, err := decodeStruk("005ABC")
+	expected := "struk line 0: expected 5 characters at offset 3, found 3"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("decodeStruk() failed. Expected: %v. Got: %v", expected, err)
+	} else {
+		t.Log("decodeStruk() success")
+	}
+}
+
+func TestUnmarshalIsoStruk(t *testing.T) {
+	var response PPOBPaymentResponse
+	fields := map[int64]string{62: "003A,B", 63: "003 C "}
+
+	if err := unmarshalIso(fields, &response); err != nil || !reflect.DeepEqual(response.Struk, []string{"A,B", " C "}) {
+		t.Errorf("unmarshalIso() failed. Got: %q (%v)", response.Struk, err)
+	} else {
+		t.Log("unmarshalIso() struk success")
+	}
+}
@@ -0,0 +1,464 @@
+package kafkabiller
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/mofax/iso8583"
+)
+
+// Suspect transaction policy, loaded from suspectPolicy.yml at startup
+var suspectPolicy SuspectPolicy
+
+// Suspect transactions still checked or left for manual settlement, listed at GET /suspects. The
+// service loads them from storage/suspects.json at startup and resumes their checks
+var suspects = newSuspectRegistry("")
+
+// MTI of the advice that carries the final result of a suspect transaction
+const adviceMTI = "0220"
+
+// Field 120 of the response to a suspect transaction
+const suspectMessage = "transaction is suspect, final result follows as advice"
+
+// Fields copied from the request to the advice so the channel can match them
+var adviceMatchFields = []int{7, 11, 37, 41, 42}
+
+// Struct for suspectPolicy.yml
+type SuspectPolicy struct {
+	PendingCodes []string        `yaml:"PendingCodes"`
+	Schedule     []time.Duration `yaml:"Schedule"`
+}
+
+// Transaction with an unknown outcome
+type SuspectTransaction struct {
+	TransactionID  string    `json:"transaction_id"`
+	ProcessingCode string    `json:"processing_code"`
+	Channel        string    `json:"channel"`
+	Reason         string    `json:"reason"`
+	Since          time.Time `json:"since"`
+	Checks         int       `json:"checks"`
+	LastRC         string    `json:"last_rc,omitempty"`
+	State          string    `json:"state"`
+}
+
+// States of a suspect transaction
+const (
+	suspectChecking   = "checking"
+	suspectUnresolved = "unresolved"
+)
+
+// Return result of checking a suspect transaction at the Biller, result is the ISO8583 response
+// for the channel once rc is final
+type suspectCheck func() (rc string, result iso8583.IsoStruct, err error)
+
+// What checking a suspect transaction needs after a restart: the Biller and the request it got,
+// and the channel request the advice answers
+type suspectResume struct {
+	Biller  string              `json:"biller"`
+	Payment *PPOBPaymentRequest `json:"payment,omitempty"`
+	Buy     *TopupBuyRequest    `json:"buy,omitempty"`
+	Request string              `json:"request"`
+	TPDU    string              `json:"tpdu,omitempty"` // hex
+}
+
+// Suspect transaction as saved in the registry file
+type suspectRecord struct {
+	Transaction *SuspectTransaction `json:"transaction"`
+	Resume      suspectResume       `json:"resume"`
+}
+
+// suspectRegistry holds suspect transactions by processing code and transaction ID and saves every
+// change to its file, safe for concurrent use
+type suspectRegistry struct {
+	filename string
+
+	mu           sync.Mutex
+	transactions map[string]*SuspectTransaction
+	resumes      map[string]suspectResume
+}
+
+// Return suspect transaction policy from a yaml file
+func suspectPolicyFromFile(filename string) (SuspectPolicy, error) {
+	var policy SuspectPolicy
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return policy, err
+	}
+	if err := yaml.UnmarshalStrict(content, &policy); err != nil {
+		return policy, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if len(policy.Schedule) == 0 {
+		return policy, fmt.Errorf("%s: schedule needs at least one check", filename)
+	}
+	for i, wait := range policy.Schedule {
+		if wait <= 0 {
+			return policy, fmt.Errorf("%s: schedule %d: wait must be positive", filename, i+1)
+		}
+	}
+	for _, rc := range policy.PendingCodes {
+		if rc == "00" {
+			return policy, fmt.Errorf("%s: approved rc 00 can't be pending", filename)
+		}
+	}
+	return policy, nil
+}
+
+// Return true if the Biller rc means the transaction is still processed
+func (p SuspectPolicy) pending(rc string) bool {
+	for _, code := range p.PendingCodes {
+		if rc == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Return why the outcome of a Biller call is unknown, "" if it's known. Only a call that never
+// reached the Biller has a known outcome when it fails
+func (p SuspectPolicy) reason(err error, rc string) string {
+	if err == nil {
+		if p.pending(rc) {
+			return "pending rc " + rc
+		}
+		return ""
+	}
+	if billerNotSent(err) {
+		return ""
+	}
+
+	var billerErr *BillerError
+	if !errors.As(err, &billerErr) {
+		return "unknown error"
+	}
+	switch billerErr.Kind {
+	case billerUnverified:
+		return "unverified response"
+	case billerBadStatus:
+		return fmt.Sprintf("status %d", billerErr.StatusCode)
+	}
+	return billerErr.Kind
+}
+
+// Return response code for a suspect transaction, 68 while its outcome is unknown. A response that
+// failed verification is rejected with 96 while the transaction is checked
+func suspectResponseCode(err error) string {
+	var billerErr *BillerError
+	if errors.As(err, &billerErr) && billerErr.Kind == billerUnverified {
+		return billerResponseCode(err)
+	}
+	return "68"
+}
+
+// Return empty suspect registry saved to filename, nothing is saved if it's ""
+func newSuspectRegistry(filename string) *suspectRegistry {
+	return &suspectRegistry{
+		filename:     filename,
+		transactions: make(map[string]*SuspectTransaction),
+		resumes:      make(map[string]suspectResume),
+	}
+}
+
+// Return suspect registry with the transactions saved in a file, a missing file is an empty
+// registry. A file that can't be read is an error so no suspect transaction is forgotten
+func suspectRegistryFromFile(filename string) (*suspectRegistry, error) {
+	registry := newSuspectRegistry(filename)
+
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return registry, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []suspectRecord
+	if err := json.Unmarshal(content, &records); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for i, record := range records {
+		if record.Transaction == nil {
+			return nil, fmt.Errorf("%s: record #%d has no transaction", filename, i+1)
+		}
+		key := suspectKey(record.Transaction)
+		registry.transactions[key] = record.Transaction
+		registry.resumes[key] = record.Resume
+	}
+	return registry, nil
+}
+
+// Return registry key of a transaction
+func suspectKey(tx *SuspectTransaction) string {
+	return tx.ProcessingCode + "/" + tx.TransactionID
+}
+
+// add starts tracking a transaction, false if it's already tracked
+func (r *suspectRegistry) add(tx *SuspectTransaction, resume suspectResume) bool {
+	key := suspectKey(tx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.transactions[key]; ok {
+		return false
+	}
+	r.transactions[key] = tx
+	r.resumes[key] = resume
+	r.save()
+	return true
+}
+
+// checked records a check of a transaction
+func (r *suspectRegistry) checked(tx *SuspectTransaction, rc string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tx.Checks++
+	if rc != "" {
+		tx.LastRC = rc
+	}
+	r.save()
+}
+
+// resolve stops tracking a transaction with a final result
+func (r *suspectRegistry) resolve(tx *SuspectTransaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := suspectKey(tx)
+	delete(r.transactions, key)
+	delete(r.resumes, key)
+	r.save()
+}
+
+// giveUp leaves a transaction for manual settlement
+func (r *suspectRegistry) giveUp(tx *SuspectTransaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tx.State = suspectUnresolved
+	r.save()
+}
+
+// checking returns every transaction still checked with what its check needs
+func (r *suspectRegistry) checking() []suspectRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []suspectRecord
+	for key, tx := range r.transactions {
+		if tx.State == suspectChecking {
+			result = append(result, suspectRecord{Transaction: tx, Resume: r.resumes[key]})
+		}
+	}
+	return result
+}
+
+// save writes every transaction to the file, oldest first; must be called with the lock held so
+// saves can't overtake each other
+func (r *suspectRegistry) save() {
+	if r.filename == "" {
+		return
+	}
+
+	records := make([]suspectRecord, 0, len(r.transactions))
+	for key, tx := range r.transactions {
+		records = append(records, suspectRecord{Transaction: tx, Resume: r.resumes[key]})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Transaction.Since.Before(records[j].Transaction.Since)
+	})
+
+	content, err := json.MarshalIndent(records, "", "  ")
+	if err == nil {
+		err = replaceFile(r.filename, content)
+	}
+	if err != nil {
+		log.Printf("Failed to save suspect transactions to %s. Error: %v\n", r.filename, err)
+	}
+}
+
+// list returns a copy of every suspect transaction, oldest first
+func (r *suspectRegistry) list() []SuspectTransaction {
+	r.mu.Lock()
+	result := make([]SuspectTransaction, 0, len(r.transactions))
+	for _, tx := range r.transactions {
+		result = append(result, *tx)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Since.Before(result[j].Since)
+	})
+	return result
+}
+
+// startSuspect tracks a transaction and checks it in the background at the Biller of resume, the
+// final result is produced to Kafka as an advice
+func startSuspect(transactionID string, reason string, channel Channel, tpdu string, request iso8583.IsoStruct, resume suspectResume) {
+	pcode := request.Elements.GetElements()[3]
+	check, err := resume.check(pcode)
+	if err != nil {
+		log.Printf("Suspect transaction %s/%s can't be checked. Error: %v\n", pcode, transactionID, err)
+		return
+	}
+	resume.Request, _ = request.ToString()
+	resume.TPDU = hex.EncodeToString([]byte(tpdu))
+
+	tx := &SuspectTransaction{
+		TransactionID:  transactionID,
+		ProcessingCode: pcode,
+		Channel:        channel.Topic,
+		Reason:         reason,
+		Since:          time.Now(),
+		State:          suspectChecking,
+	}
+	if !suspects.add(tx, resume) {
+		log.Printf("Suspect transaction %s/%s is already checked\n", pcode, transactionID)
+		return
+	}
+	log.Printf("Transaction %s/%s is suspect (%s), checking with Biller\n", pcode, transactionID, reason)
+	metrics.inc("suspect_started", "pcode", pcode, "reason", reason)
+
+	go resolveSuspect(tx, channel, tpdu, request, check, func(advice string) {
+		billerChan <- advice
+	})
+}
+
+// resumeSuspects resumes checking the suspect transactions loaded at startup where their schedule
+// stopped, a transaction that can't be checked is left for manual settlement
+func resumeSuspects() {
+	for _, record := range suspects.checking() {
+		tx := record.Transaction
+		check, err := record.Resume.check(tx.ProcessingCode)
+		var request iso8583.IsoStruct
+		if err == nil {
+			request, err = isoSpec.parse(record.Resume.Request)
+		}
+		var tpdu []byte
+		if err == nil {
+			tpdu, err = hex.DecodeString(record.Resume.TPDU)
+		}
+		if err != nil {
+			log.Printf("Warning: suspect transaction %s/%s can't be checked after restart, settle it manually. Error: %v\n",
+				tx.ProcessingCode, tx.TransactionID, err)
+			suspects.giveUp(tx)
+			continue
+		}
+
+		log.Printf("Resuming checks of suspect transaction %s/%s after %d checks\n", tx.ProcessingCode, tx.TransactionID, tx.Checks)
+		go resolveSuspect(tx, channelFor(tx.Channel), string(tpdu), request, check, func(advice string) {
+			billerChan <- advice
+		})
+	}
+}
+
+// resolveSuspect checks a suspect transaction on the policy schedule until its rc is final, a
+// transaction checked before a restart continues with the wait of its next check
+func resolveSuspect(tx *SuspectTransaction, channel Channel, tpdu string, request iso8583.IsoStruct, check suspectCheck, publish func(string)) {
+	for next := tx.Checks; next < len(suspectPolicy.Schedule); next++ {
+		time.Sleep(suspectPolicy.Schedule[next])
+
+		rc, result, err := check()
+		suspects.checked(tx, rc)
+		if err != nil {
+			log.Printf("Check of suspect transaction %s/%s failed. Error: %v\n", tx.ProcessingCode, tx.TransactionID, err)
+			continue
+		}
+		if suspectPolicy.pending(rc) {
+			log.Printf("Suspect transaction %s/%s is still pending (rc %s)\n", tx.ProcessingCode, tx.TransactionID, rc)
+			continue
+		}
+
+		// Final result goes to the channel as an advice
+		advice, message := toChannel(channel, tx.ProcessingCode, getIsoAdvice(request, result))
+		frame, err := channel.Framer.Frame(message, tpdu)
+		if err != nil {
+			log.Printf("Failed to frame advice of suspect transaction %s/%s. Error: %v\n", tx.ProcessingCode, tx.TransactionID, err)
+			advice, message = toChannel(channel, tx.ProcessingCode, getIsoAdvice(request, getIsoError(tx.ProcessingCode, "96", "advice can't be framed for channel")))
+			frame, _ = channel.Framer.Frame(message, tpdu)
+		}
+		publish(frame)
+		suspects.resolve(tx)
+		metrics.inc("suspect_resolved", "pcode", tx.ProcessingCode, "rc", rc)
+
+		masked := maskPolicy.iso(channel.Version.Spec, advice)
+		log.Printf("Suspect transaction %s/%s resolved with rc %s. Advice: %v\n", tx.ProcessingCode, tx.TransactionID, rc, masked)
+		archiveMessage("Advice", tx.ProcessingCode, tx.TransactionID, masked)
+		return
+	}
+
+	suspects.giveUp(tx)
+	metrics.inc("suspect_unresolved", "pcode", tx.ProcessingCode)
+	log.Printf("Warning: suspect transaction %s/%s is unresolved after %d checks, settle it manually\n",
+		tx.ProcessingCode, tx.TransactionID, len(suspectPolicy.Schedule))
+}
+
+// Return check of the transaction with processing code pcode at the Biller that got it
+func (r suspectResume) check(pcode string) (suspectCheck, error) {
+	biller, ok := billerEnv.billerNamed(r.Biller)
+	if !ok {
+		return nil, fmt.Errorf("unknown Biller %s", r.Biller)
+	}
+	switch {
+	case r.Payment != nil:
+		return ppobPaymentCheck(biller, pcode, *r.Payment), nil
+	case r.Buy != nil:
+		return topupBuyCheck(biller, pcode, *r.Buy), nil
+	}
+	return nil, fmt.Errorf("no Biller request to check")
+}
+
+// Return check of a PPOB Payment with PPOB Status at the Biller that got the payment
+func ppobPaymentCheck(biller Biller, pcode string, payment PPOBPaymentRequest) suspectCheck {
+	status := getJsonPPOBStatusOf(payment)
+	return func() (string, iso8583.IsoStruct, error) {
+		response, err := biller.Status(status)
+		if err != nil {
+			return "", iso8583.IsoStruct{}, err
+		}
+		return response.Rc, getIsoPPOBStatus(pcode, response), nil
+	}
+}
+
+// Return check of a Topup Buy with Topup Check at the Biller that got the buy
+func topupBuyCheck(biller Biller, pcode string, buy TopupBuyRequest) suspectCheck {
+	check := getJsonTopupCheckOf(buy)
+	return func() (string, iso8583.IsoStruct, error) {
+		response, err := biller.TopupCheck(check)
+		if err != nil {
+			return "", iso8583.IsoStruct{}, err
+		}
+		return response.Rc, getIsoTopupCheck(pcode, response), nil
+	}
+}
+
+// Return advice with the final result of a request, it carries the request's processing code and
+// matching fields
+func getIsoAdvice(request iso8583.IsoStruct, result iso8583.IsoStruct) iso8583.IsoStruct {
+	data := make(map[int]string)
+	for field, value := range result.Elements.GetElements() {
+		data[int(field)] = value
+	}
+	requestFields := request.Elements.GetElements()
+	for _, field := range adviceMatchFields {
+		if value, ok := requestFields[int64(field)]; ok {
+			data[field] = value
+		}
+	}
+	data[3] = requestFields[3]
+
+	return getIso(data, adviceMTI)
+}
+
+// Return every suspect transaction
+func getSuspects(w http.ResponseWriter, r *http.Request) {
+	jsonFormatter(w, suspects.list(), http.StatusOK)
+}
@@ -0,0 +1,178 @@
+package kafkabiller
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mofax/iso8583"
+)
+
+func TestSuspectPolicyReason(t *testing.T) {
+	policy, err := suspectPolicyFromFile("suspectPolicy.yml")
+	if err != nil {
+		t.Fatalf("suspectPolicyFromFile() failed. Error: %v", err)
+	}
+
+	tests := []struct {
+		err      error
+		rc       string
+		expected string
+	}{
+		{&BillerError{Kind: billerTimedOut, Endpoint: "/buy", Err: errors.New("deadline exceeded")}, "", "timeout"},
+		{&BillerError{Kind: billerConnectFailed, Endpoint: "/buy", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, "", ""},
+		{&BillerError{Kind: billerConnectFailed, Endpoint: "/buy", Err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}}, "", "connect"},
+		{&BillerError{Kind: billerBadStatus, Endpoint: "/buy", StatusCode: 504}, "", "status 504"},
+		{&BillerError{Kind: billerInvalidJSON, Endpoint: "/buy", Err: errors.New("unexpected EOF")}, "", "invalid"},
+		{&BillerError{Kind: billerMissingRC, Endpoint: "/buy", Err: errors.New("response has no rc")}, "", "missing_rc"},
+		{&BillerError{Kind: billerCircuitOpen, Endpoint: "/buy", Err: errors.New("circuit open")}, "", ""},
+		{&BillerError{Kind: billerQuotaExceeded, Endpoint: "/buy", Err: errors.New("daily quota used up")}, "", ""},
+		{&BillerError{Kind: billerUnverified, Endpoint: "/buy", Err: errors.New("response signature doesn't match")}, "00", "unverified response"},
+		{nil, "68", "pending rc 68"},
+		{nil, "00", ""},
+		{nil, "05", ""},
+	}
+	for _, test := range tests {
+		if result := policy.reason(test.err, test.rc); result != test.expected {
+			t.Errorf("SuspectPolicy.reason(%v, %q) failed. Expected: %q. Got: %q", test.err, test.rc, test.expected, result)
+		}
+	}
+	t.Log("SuspectPolicy.reason() success")
+}
+
+func TestResolveSuspect(t *testing.T) {
+	defer func(policy SuspectPolicy) { suspectPolicy = policy }(suspectPolicy)
+	suspectPolicy = SuspectPolicy{PendingCodes: []string{"68"}, Schedule: []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}}
+
+	// Advice is archived to storage/response
+	dir, _ := ioutil.TempDir("", "suspect")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "storage", "response"), 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	channel := channelFor("suspect-test")
+	request := getIso(map[int]string{3: "810002", 11: "000123", 37: "REF000000001"}, "0200")
+
+	// Pending on the first check, approved on the second
+	checks := 0
+	check := func() (string, iso8583.IsoStruct, error) {
+		checks++
+		if checks == 1 {
+			return "68", iso8583.IsoStruct{}, nil
+		}
+		return "00", getIso(map[int]string{39: "00", 120: "SUKSES", 121: "SN123"}, "0210"), nil
+	}
+
+	tx := &SuspectTransaction{TransactionID: "TX1", ProcessingCode: "810002", State: suspectChecking}
+	suspects.add(tx, suspectResume{})
+	var published []string
+	resolveSuspect(tx, channel, "", request, check, func(advice string) {
+		published = append(published, advice)
+	})
+
+	if len(published) != 1 || checks != 2 {
+		t.Fatalf("resolveSuspect() failed. Expected: 1 advice after 2 checks. Got: %d after %d", len(published), checks)
+	}
+	message, _, _ := channel.Framer.Unframe(published[0])
+	advice, err := isoSpec.parse(message)
+	if err != nil {
+		t.Fatalf("resolveSuspect() failed. Advice can't be parsed: %v", err)
+	}
+	fields := advice.Elements.GetElements()
+	if advice.Mti.String() != adviceMTI || fields[3] != "810002" || fields[11] != "000123" || fields[39] != "00" {
+		t.Errorf("resolveSuspect() failed. Expected: 0220 advice for 810002, STAN 000123, rc 00. Got: %v %v", advice.Mti.String(), fields)
+	}
+	if len(suspects.list()) != 0 {
+		t.Errorf("resolveSuspect() failed. Expected: resolved transaction is removed. Got: %v", suspects.list())
+	} else {
+		t.Log("resolveSuspect() success")
+	}
+
+	// Still pending after the last check
+	tx = &SuspectTransaction{TransactionID: "TX2", ProcessingCode: "810002", State: suspectChecking}
+	suspects.add(tx, suspectResume{})
+	defer suspects.resolve(tx)
+	resolveSuspect(tx, channel, "", request, func() (string, iso8583.IsoStruct, error) {
+		return "68", iso8583.IsoStruct{}, nil
+	}, func(advice string) {
+		t.Errorf("resolveSuspect() failed. Expected: no advice for pending transaction. Got: %q", advice)
+	})
+
+	list := suspects.list()
+	if len(list) != 1 || list[0].State != suspectUnresolved || list[0].Checks != 3 {
+		t.Errorf("resolveSuspect() failed. Expected: unresolved after 3 checks. Got: %+v", list)
+	} else {
+		t.Log("resolveSuspect() of unresolved transaction success")
+	}
+}
+
+func TestSuspectRegistryFromFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "suspect")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "storage", "suspects.json")
+
+	registry, err := suspectRegistryFromFile(filename)
+	if err != nil || len(registry.list()) != 0 {
+		t.Fatalf("suspectRegistryFromFile() failed. Expected: empty registry without file. Got: %v (%v)", registry, err)
+	}
+
+	buy := TopupBuyRequest{TransactionID: "TX3", CustomerNo: "081200000777", ProductCode: "TSEL10"}
+	tx := &SuspectTransaction{TransactionID: "TX3", ProcessingCode: "810002", Reason: "timeout", State: suspectChecking}
+	registry.add(tx, suspectResume{Biller: "chipsakti", Buy: &buy, Request: "0200", TPDU: "6000010002"})
+	registry.checked(tx, "68")
+
+	// Checks go on after a restart where they stopped
+	reloaded, err := suspectRegistryFromFile(filename)
+	if err != nil {
+		t.Fatalf("suspectRegistryFromFile() failed. Error: %v", err)
+	}
+	checking := reloaded.checking()
+	if len(checking) != 1 || checking[0].Transaction.Checks != 1 || checking[0].Transaction.LastRC != "68" ||
+		checking[0].Resume.Buy == nil || checking[0].Resume.Buy.CustomerNo != "081200000777" || checking[0].Resume.TPDU != "6000010002" {
+		t.Fatalf("suspectRegistryFromFile() failed. Expected: TX3 after 1 check with its buy. Got: %+v", checking)
+	}
+	if _, err := checking[0].Resume.check("810002"); err != nil {
+		t.Errorf("suspectResume.check() failed. Error: %v", err)
+	}
+
+	registry.resolve(tx)
+	if reloaded, err := suspectRegistryFromFile(filename); err != nil || len(reloaded.list()) != 0 {
+		t.Errorf("suspectRegistryFromFile() failed. Expected: resolved transaction is removed. Got: %v (%v)", reloaded.list(), err)
+	}
+
+	// A file that can't be read stops the service instead of forgetting transactions
+	ioutil.WriteFile(filename, []byte(`[{"transaction": `), 0644)
+	if _, err := suspectRegistryFromFile(filename); err == nil {
+		t.Errorf("suspectRegistryFromFile() failed. Expected: error for corrupt file. Got: nil")
+	} else {
+		t.Log("suspectRegistryFromFile() success")
+	}
+}
+
+func TestResolveSuspectResumed(t *testing.T) {
+	defer func(policy SuspectPolicy) { suspectPolicy = policy }(suspectPolicy)
+	suspectPolicy = SuspectPolicy{PendingCodes: []string{"68"}, Schedule: []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}}
+
+	// Two of three checks were made before the restart
+	tx := &SuspectTransaction{TransactionID: "TX4", ProcessingCode: "810002", State: suspectChecking, Checks: 2}
+	suspects.add(tx, suspectResume{})
+	defer suspects.resolve(tx)
+
+	checks := 0
+	resolveSuspect(tx, channelFor("suspect-test"), "", getIso(map[int]string{3: "810002"}, "0200"), func() (string, iso8583.IsoStruct, error) {
+		checks++
+		return "68", iso8583.IsoStruct{}, nil
+	}, func(string) {})
+
+	if checks != 1 || tx.State != suspectUnresolved {
+		t.Errorf("resolveSuspect() failed. Expected: 1 check left, then unresolved. Got: %d checks, %v", checks, tx.State)
+	} else {
+		t.Log("resolveSuspect() resumed success")
+	}
+}
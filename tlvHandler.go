@@ -0,0 +1,177 @@
+package kafkabiller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/go-yaml/yaml"
+)
+
+// TLV dictionaries per processing code, loaded from tlv.yml at startup
+var tlvDictionaries map[string]map[int][]tlvElement
+
+// Private fields that can hold TLV sub-elements
+var tlvFields = map[int]bool{48: true, 62: true, 120: true, 121: true, 122: true, 123: true, 124: true, 125: true, 126: true, 127: true}
+
+// TLVError describes why a TLV field can't be read or built
+type TLVError struct {
+	Pcode  string
+	Field  int
+	Tag    string
+	Reason string
+}
+
+func (e *TLVError) Error() string {
+	if e.Tag == "" {
+		return fmt.Sprintf("field %d (%s): %s", e.Field, e.Pcode, e.Reason)
+	}
+	return fmt.Sprintf("field %d (%s): tag %s: %s", e.Field, e.Pcode, e.Tag, e.Reason)
+}
+
+// Return TLV dictionaries from a yaml file
+func tlvDictionariesFromFile(filename string) (map[string]map[int][]tlvElement, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var dictionaries map[string]map[int][]tlvElement
+	if err := yaml.Unmarshal(content, &dictionaries); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	// Names are unique per processing code, they are used without the field number in Biller JSON
+	for pcode, fields := range dictionaries {
+		names := make(map[string]bool)
+		for field, elements := range fields {
+			if !tlvFields[field] {
+				return nil, fmt.Errorf("%s: %s: field %d can't hold TLV sub-elements", filename, pcode, field)
+			}
+			tags := make(map[string]bool)
+			for i, element := range elements {
+				if len(element.Tag) != 2 {
+					return nil, fmt.Errorf("%s: %s: field %d: sub-element #%d: tag must be 2 characters", filename, pcode, field, i+1)
+				}
+				if element.Name == "" {
+					return nil, fmt.Errorf("%s: %s: field %d: tag %s has no name", filename, pcode, field, element.Tag)
+				}
+				if tags[element.Tag] {
+					return nil, fmt.Errorf("%s: %s: field %d: duplicate tag %s", filename, pcode, field, element.Tag)
+				}
+				if names[element.Name] {
+					return nil, fmt.Errorf("%s: %s: duplicate name %s", filename, pcode, element.Name)
+				}
+				if element.MaxLen < 0 || element.MaxLen > 999-5 {
+					return nil, fmt.Errorf("%s: %s: field %d: tag %s: MaxLen must be between 0 and 994", filename, pcode, field, element.Tag)
+				}
+				tags[element.Tag] = true
+				names[element.Name] = true
+			}
+		}
+	}
+
+	return dictionaries, nil
+}
+
+// Return TLV dictionary of a field for processing code, nil if the field has no sub-elements
+func getTLVDictionary(pcode string, field int) []tlvElement {
+	return tlvDictionaries[pcode][field]
+}
+
+// Return sub-elements of a TLV field by name, tags missing from the dictionary are skipped
+func parseTLV(pcode string, field int, data string) (map[string]string, error) {
+	dictionary := getTLVDictionary(pcode, field)
+	if dictionary == nil {
+		return nil, &TLVError{Pcode: pcode, Field: field, Reason: "no TLV dictionary defined for processing code"}
+	}
+
+	result := make(map[string]string, len(dictionary))
+	for pos := 0; pos < len(data); {
+		if pos+5 > len(data) {
+			return nil, &TLVError{pcode, field, "", fmt.Sprintf("expected tag and length at offset %d, found %d characters", pos, len(data)-pos)}
+		}
+		tag := data[pos : pos+2]
+		length, err := strconv.Atoi(data[pos+2 : pos+5])
+		if err != nil || length < 0 {
+			return nil, &TLVError{pcode, field, tag, fmt.Sprintf("invalid length %q", data[pos+2:pos+5])}
+		}
+		pos += 5
+		if pos+length > len(data) {
+			return nil, &TLVError{pcode, field, tag, fmt.Sprintf("expected %d characters at offset %d, found %d", length, pos, len(data)-pos)}
+		}
+		value := data[pos : pos+length]
+		pos += length
+
+		element, ok := findTLVElement(dictionary, tag)
+		if !ok {
+			log.Printf("Warning: field %d (%s) has unknown tag %s, sub-element is skipped\n", field, pcode, tag)
+			continue
+		}
+		result[element.Name] = value
+	}
+
+	return result, nil
+}
+
+// Return TLV field built from sub-elements by name, values without a name in the dictionary
+// are ignored. Sub-elements are written in dictionary order
+func buildTLV(pcode string, field int, values map[string]string) (string, error) {
+	dictionary := getTLVDictionary(pcode, field)
+
+	var data string
+	for _, element := range dictionary {
+		value, ok := values[element.Name]
+		if !ok {
+			continue
+		}
+		if element.MaxLen > 0 && len(value) > element.MaxLen {
+			return "", &TLVError{pcode, field, element.Tag, fmt.Sprintf("%s is longer than %d characters", element.Name, element.MaxLen)}
+		}
+		data += element.Tag + fmt.Sprintf("%03d", len(value)) + value
+	}
+	return data, nil
+}
+
+// Return every TLV field for processing code with sub-elements found in values
+func buildTLVFields(pcode string, values map[string]string) (map[int]string, error) {
+	fields := make([]int, 0, len(tlvDictionaries[pcode]))
+	for field := range tlvDictionaries[pcode] {
+		fields = append(fields, field)
+	}
+	sort.Ints(fields)
+
+	result := make(map[int]string)
+	for _, field := range fields {
+		data, err := buildTLV(pcode, field, values)
+		if err != nil {
+			return nil, err
+		}
+		if data != "" {
+			result[field] = data
+		}
+	}
+	return result, nil
+}
+
+// Return dictionary element with tag
+func findTLVElement(dictionary []tlvElement, tag string) (tlvElement, bool) {
+	for _, element := range dictionary {
+		if element.Tag == tag {
+			return element, true
+		}
+	}
+	return tlvElement{}, false
+}
+
+// Return sub-element of a dictionary by name
+func findTLVElementByName(dictionary []tlvElement, name string) (tlvElement, bool) {
+	for _, element := range dictionary {
+		if element.Name == name {
+			return element, true
+		}
+	}
+	return tlvElement{}, false
+}
@@ -0,0 +1,152 @@
+package kafkabiller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTLVRoundTrip(t *testing.T) {
+	values := map[string]string{"kwh": "32,5", "token": "1234-5678-9012-3456-7890", "nama": "HANAFI"}
+
+	data, err := buildTLV("810011", 126, values)
+	expected := "01024" + "1234-5678-9012-3456-7890" + "02004" + "32,5"
+	if err != nil || data != expected {
+		t.Errorf("buildTLV() failed. Expected: %v. Got: %v (%v)", expected, data, err)
+	}
+
+	// Unknown tag 99 is skipped
+	result, err := parseTLV("810011", 126, data+"99003ABC")
+	delete(values, "nama")
+	if err != nil || !reflect.DeepEqual(result, values) {
+		t.Errorf("parseTLV() failed. Expected: %v. Got: %v (%v)", values, result, err)
+	} else {
+		t.Log("TLV round trip success")
+	}
+}
+
+func TestParseTLVInvalid(t *testing.T) {
+	_, err := parseTLV("810011", 127, "01010202401")
+	expected := "field 127 (810011): tag 01: expected 10 characters at offset 5, found 6"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("parseTLV() failed. Expected: %v. Got: %v", expected, err)
+	} else {
+		t.Log("parseTLV() success")
+	}
+}
+
+func TestBuildTLVFields(t *testing.T) {
+	values := map[string]string{"token": "1234", "periode": "202105", "denda": strings.Repeat("9", 13)}
+
+	if _, err := buildTLVFields("810011", values); err == nil || !strings.Contains(err.Error(), "denda is longer than 12") {
+		t.Errorf("buildTLVFields() failed. Expected MaxLen error. Got: %v", err)
+	}
+
+	delete(values, "denda")
+	result, err := buildTLVFields("810011", values)
+	expected := map[int]string{126: "010041234", 127: "01006202105"}
+	if err != nil || !reflect.DeepEqual(result, expected) {
+		t.Errorf("buildTLVFields() failed. Expected: %v. Got: %v (%v)", expected, result, err)
+	} else {
+		t.Log("buildTLVFields() success")
+	}
+}
+
+func TestGetIsoRouteTLV(t *testing.T) {
+	route := Route{
+		ProcessingCode: "810011",
+		Name:           "Test Prepaid",
+		Response: RouteResponse{
+			Approved: map[int]string{39: "rc"},
+			Declined: map[int]string{39: "rc"},
+		},
+	}
+	jsonResponse := map[string]interface{}{"rc": "00", "token": "1234", "kwh": float64(32.5)}
+
+	isoResponse := getIsoRoute(route, "810011", jsonResponse)
+	elements := isoResponse.Elements.GetElements()
+
+	expected := "01004" + "1234" + "02004" + "32.5"
+	if elements[126] != expected {
+		t.Errorf("getIsoRoute() failed. Expected field 126: %v. Got: %v", expected, elements[126])
+	} else {
+		t.Log("getIsoRoute() TLV success")
+	}
+}
+
+func TestGetResponseRouteTLV(t *testing.T) {
+	var customerNo string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		customerNo = r.FormValue("customer_no")
+		fmt.Fprint(w, `{"rc":"00","msg":"SUKSES","price":20000,"token":"1234-5678-9012-3456-7890","kwh":"32,5","periode":"202105"}`)
+	}))
+	defer server.Close()
+
+	// PLN Prepaid example route of routes.yml, answered with the "810011" dictionary of tlv.yml
+	route := Route{
+		ProcessingCode: "810011",
+		MTI:            "0200",
+		Name:           "PLN Prepaid",
+		Mandatory:      []int{3, 4, 48},
+		Endpoint:       "/pln/prepaid",
+		Request:        map[string]string{"transaction_id": "48.transaction_id", "customer_no": "48.customer_no", "amount": "4"},
+		Response: RouteResponse{
+			Approved: map[int]string{4: "price", 39: "rc", 120: "msg"},
+			Declined: map[int]string{39: "rc", 120: "msg"},
+		},
+	}
+	field48Layouts["810011"] = field48Layouts["810002"]
+	defer delete(field48Layouts, "810011")
+
+	defer func(env *BillerEnvironment, routes []Route) { billerEnv, routingTable = env, routes }(billerEnv, routingTable)
+	biller := testBiller(t, "test", "chipsakti", server.URL, time.Second)
+	billerEnv = &BillerEnvironment{Default: "test", Billers: map[string]*BillerConnection{"test": biller.(*chipsaktiBiller).conn},
+		billers: map[string]Biller{"test": biller}}
+	if err := route.validate(billerEnv); err != nil {
+		t.Fatalf("Route.validate() failed. Error: %v", err)
+	}
+	routingTable = []Route{route}
+
+	dir, _ := ioutil.TempDir("", "tlv")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "storage", "response"), 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	field48, err := buildField48("810011", map[string]string{"transaction_id": "2021", "partner_id": "USER01", "product_code": "PLN20",
+		"customer_no": "530000000001", "merchant_code": "KIOS01", "request_time": "2021-05-15 15:10:05"})
+	if err != nil {
+		t.Fatalf("buildField48() failed. Error: %v", err)
+	}
+	message := getIso(map[int]string{3: "810011", 4: "000000020000", 48: field48}, "0200")
+	request, _ := message.ToString()
+	frame, _ := asciiFramer{}.Frame(request, "")
+	response, _, _ := asciiFramer{}.Unframe(getResponse(frame, channelFor("tlv-test"), time.Now()))
+
+	result, err := isoSpec.parse(response)
+	if err != nil {
+		t.Fatalf("getResponse() failed. Response can't be parsed: %v", err)
+	}
+	fields := result.Elements.GetElements()
+	expected := map[int64]string{3: "810011", 39: "00", 126: "01024" + "1234-5678-9012-3456-7890" + "02004" + "32,5", 127: "01006" + "202105"}
+	for field, value := range expected {
+		if fields[field] != value {
+			t.Errorf("getResponse() failed at field %d. Expected: %v. Got: %v", field, value, fields[field])
+		}
+	}
+	if customerNo != "530000000001" {
+		t.Errorf("getResponse() failed. Expected: customer_no 530000000001 sent to Biller. Got: %v", customerNo)
+	}
+	if !t.Failed() {
+		t.Log("getResponse() TLV route success")
+	}
+}
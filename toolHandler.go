@@ -0,0 +1,564 @@
+package kafkabiller
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"github.com/mofax/iso8583"
+)
+
+// Usage of the toolbox, run as `iso-tool <command>` from the service directory
+const toolUsage = `Usage: iso-tool <command> [flags] [file]
+
+Commands:
+  decode    decode a framed ISO8583 message into labelled fields
+  encode    build a framed ISO8583 message from JSON/YAML {"mti": "0200", "fields": {"3": "380001"}}
+  convert   -to json: ISO8583 request to the Biller request of its route
+            -to iso:  Biller JSON response to the ISO8583 response of -pcode
+  lint      check spec YAML files for duplicate and invalid field definitions
+
+Messages are read from file, or stdin if no file is given. Run a command with -h for its flags.
+`
+
+// Message for the encode command
+type toolMessage struct {
+	MTI    string                 `yaml:"mti"`
+	Fields map[string]interface{} `yaml:"fields"`
+}
+
+// Flags shared by commands that read or write messages
+type toolChannelFlags struct {
+	channel  *string
+	framing  *string
+	version  *string
+	binary   *string
+	numeric  *string
+	charset  *string
+	hex      *bool
+	verbose  *bool
+	resolved Channel
+}
+
+// RunTool runs a toolbox command, it only loads the specs and layouts it needs
+func RunTool(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", toolUsage)
+	}
+
+	switch args[0] {
+	case "decode":
+		return toolDecode(args[1:], stdin, stdout)
+	case "encode":
+		return toolEncode(args[1:], stdin, stdout)
+	case "convert":
+		return toolConvert(args[1:], stdin, stdout)
+	case "lint":
+		return toolLint(args[1:], stdout)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, toolUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], toolUsage)
+	}
+}
+
+// Add channel flags to a command
+func addChannelFlags(fs *flag.FlagSet) *toolChannelFlags {
+	return &toolChannelFlags{
+		channel: fs.String("channel", "", "consumer topic in kafkaConfig.json, overrides the other channel flags"),
+		framing: fs.String("framing", "ascii4", "framing: ascii4, binary2, tpdu or none"),
+		version: fs.String("version", "1987", "ISO8583 version in versions.yml"),
+		binary:  fs.String("binary", "", "binary encoding: hex or raw"),
+		numeric: fs.String("numeric", "", "numeric encoding: ascii or bcd"),
+		charset: fs.String("charset", "", "charset: ascii or ebcdic"),
+		hex:     fs.Bool("hex", false, "read and write the framed message as hex"),
+		verbose: fs.Bool("v", false, "print service logs to stderr"),
+	}
+}
+
+// Resolve channel settings from flags, must be called after parsing
+func (f *toolChannelFlags) resolve() error {
+	if *f.verbose {
+		log.SetOutput(os.Stderr)
+	} else {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	if err := loadToolConfig(); err != nil {
+		return err
+	}
+
+	if *f.channel != "" {
+		configured, err := configChannels()
+		if err != nil {
+			return err
+		}
+		channel, ok := configured[*f.channel]
+		if !ok {
+			return fmt.Errorf("channel %s is not a consumer topic in kafkaConfig.json", *f.channel)
+		}
+		f.resolved = channel
+		return nil
+	}
+
+	framer, err := framerByName(*f.framing)
+	if err != nil {
+		return err
+	}
+	version, err := versionByName(*f.version)
+	if err != nil {
+		return err
+	}
+	encoding := Encoding{Binary: *f.binary, Numeric: *f.numeric, Charset: *f.charset}
+	if err := encoding.validate(); err != nil {
+		return err
+	}
+	f.resolved = Channel{Topic: "tool", Framer: framer, Version: version, Encoding: encoding}
+	return nil
+}
+
+// Load the config files messages are read and built with, Billers and the service's other
+// settings aren't needed
+func loadToolConfig() error {
+	var err error
+	if isoSpec, err = specFromFile("spec1987.yml"); err != nil {
+		return err
+	}
+	if isoVersions, err = versionsFromFile("versions.yml"); err != nil {
+		return err
+	}
+	if outboundPolicy, err = lengthPolicyFromFile("lengthPolicy.yml"); err != nil {
+		return err
+	}
+	if maskPolicy, err = maskPolicyFromFile("maskPolicy.yml"); err != nil {
+		return err
+	}
+	if field48Layouts, err = layoutsFromFile("field48.yml"); err != nil {
+		return err
+	}
+	if tlvDictionaries, err = tlvDictionariesFromFile("tlv.yml"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Return true if framed messages of the channel are printable text
+func (f *toolChannelFlags) isText() bool {
+	switch f.resolved.Framer.(type) {
+	case asciiFramer, noFramer:
+		return f.resolved.Encoding.isLibrary()
+	}
+	return false
+}
+
+// Return framed message read from input
+func (f *toolChannelFlags) readFrame(input []byte) (string, error) {
+	if *f.hex {
+		frame, err := hex.DecodeString(strings.Join(strings.Fields(string(input)), ""))
+		if err != nil {
+			return "", fmt.Errorf("invalid hex input: %v", err)
+		}
+		return string(frame), nil
+	}
+	if f.isText() {
+		return strings.TrimRight(string(input), "\r\n"), nil
+	}
+	return string(input), nil
+}
+
+// Write framed message to output
+func (f *toolChannelFlags) writeFrame(w io.Writer, frame string) {
+	switch {
+	case *f.hex:
+		fmt.Fprintln(w, strings.ToUpper(hex.EncodeToString([]byte(frame))))
+	case f.isText():
+		fmt.Fprintln(w, frame)
+	default:
+		io.WriteString(w, frame)
+	}
+}
+
+// Return message parsed from a framed message of the channel
+func (f *toolChannelFlags) parseFrame(frame string) (iso8583.IsoStruct, string, error) {
+	channel := f.resolved
+	message, tpdu, err := channel.Framer.Unframe(frame)
+	if err != nil {
+		return iso8583.IsoStruct{}, "", err
+	}
+	message, err = channel.Encoding.decode(channel.Version.Spec, message)
+	if err != nil {
+		return iso8583.IsoStruct{}, "", err
+	}
+	iso, err := channel.Version.Spec.parse(message)
+	return iso, tpdu, err
+}
+
+// Return framed message of the channel
+func (f *toolChannelFlags) frame(iso iso8583.IsoStruct) (string, error) {
+	channel := f.resolved
+	message, err := channel.Encoding.encode(channel.Version.Spec, iso)
+	if err != nil {
+		return "", err
+	}
+	return channel.Framer.Frame(message, "")
+}
+
+// Return content of the file argument, or stdin if there is none
+func readToolInput(args []string, stdin io.Reader) ([]byte, error) {
+	switch len(args) {
+	case 0:
+		return ioutil.ReadAll(stdin)
+	case 1:
+		return ioutil.ReadFile(args[0])
+	default:
+		return nil, fmt.Errorf("expected one file, got %d", len(args))
+	}
+}
+
+// decode prints every field of a framed message with its label
+func toolDecode(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	flags := addChannelFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := flags.resolve(); err != nil {
+		return err
+	}
+
+	input, err := readToolInput(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+	frame, err := flags.readFrame(input)
+	if err != nil {
+		return err
+	}
+	iso, tpdu, err := flags.parseFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	spec := flags.resolved.Version.Spec
+	bitmap, _ := iso8583.BitMapArrayToHex(iso.Bitmap)
+	fmt.Fprintf(stdout, "MTI     %s (ISO8583:%s)\n", iso.Mti.String(), flags.resolved.Version.Name)
+	fmt.Fprintf(stdout, "Bitmap  %s\n", bitmap)
+	if tpdu != "" {
+		fmt.Fprintf(stdout, "TPDU    % X\n", tpdu)
+	}
+
+	elements := iso.Elements.GetElements()
+	fields := make([]int, 0, len(elements))
+	for field := range elements {
+		fields = append(fields, int(field))
+	}
+	sort.Ints(fields)
+
+	pcode := elements[3]
+	for _, field := range fields {
+		value := elements[int64(field)]
+		fmt.Fprintf(stdout, "%5d  %-40s [%s]\n", field, spec.fields[field].Label, value)
+		printToolSubFields(stdout, pcode, field, value)
+	}
+
+	if err := spec.validateMessage(iso, nil); err != nil {
+		fmt.Fprintf(stdout, "\nProblems: %v\n", err)
+	}
+	return nil
+}
+
+// Print sub-fields of a private field when the processing code has a layout for it
+func printToolSubFields(w io.Writer, pcode string, field int, value string) {
+	var subFields map[string]string
+	var names []string
+
+	switch {
+	case field == strukField:
+		// Field 62 is only shown as struk when it decodes as one
+		lines, err := decodeStruk(value)
+		if err != nil {
+			return
+		}
+		for i, line := range lines {
+			fmt.Fprintf(w, "%7s  struk line %-29d [%s]\n", "", i+1, line)
+		}
+		return
+
+	case tlvFields[field]:
+		parsed, err := parseRouteSubFields(pcode, field, value)
+		if err != nil {
+			return
+		}
+		subFields = parsed
+		for name := range parsed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		fmt.Fprintf(w, "%7s  .%-39s [%s]\n", "", name, subFields[name])
+	}
+}
+
+// encode builds a framed message from JSON/YAML
+func toolEncode(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("encode", flag.ContinueOnError)
+	flags := addChannelFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := flags.resolve(); err != nil {
+		return err
+	}
+
+	input, err := readToolInput(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+
+	// YAML is a superset of JSON, both are read the same way
+	var message toolMessage
+	if err := yaml.Unmarshal(input, &message); err != nil {
+		return fmt.Errorf("invalid message: %v", err)
+	}
+	if len(message.MTI) != 4 {
+		return fmt.Errorf("invalid message: mti must be 4 digits")
+	}
+	data := make(map[int]string, len(message.Fields))
+	for key, value := range message.Fields {
+		field, err := strconv.Atoi(key)
+		if err != nil || field < 2 || field > 128 {
+			return fmt.Errorf("invalid message: field %q must be between 2 and 128", key)
+		}
+		data[field] = fmt.Sprint(value)
+	}
+
+	iso := buildIso(flags.resolved.Version.Spec, data, message.MTI)
+	frame, err := flags.frame(iso)
+	if err != nil {
+		return err
+	}
+	flags.writeFrame(stdout, frame)
+	return nil
+}
+
+// convert converts between ISO8583 messages and Biller JSON using the routing table
+func toolConvert(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	flags := addChannelFlags(fs)
+	to := fs.String("to", "", "json: ISO8583 request to Biller request, iso: Biller response to ISO8583 response")
+	pcode := fs.String("pcode", "", "processing code of the Biller response, needed by -to iso")
+	mti := fs.String("mti", "0200", "request MTI of the Biller response route")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := flags.resolve(); err != nil {
+		return err
+	}
+
+	// Conversions don't call a Biller, the Biller names of routes aren't checked
+	routes, err := routesFromFile("routes.yml", nil)
+	if err != nil {
+		return err
+	}
+	input, err := readToolInput(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+
+	switch *to {
+	case "json":
+		frame, err := flags.readFrame(input)
+		if err != nil {
+			return err
+		}
+		iso, _, err := flags.parseFrame(frame)
+		if err != nil {
+			return err
+		}
+		iso, err = flags.resolved.Version.toInternal(iso)
+		if err != nil {
+			return err
+		}
+
+		requestPcode := iso.Elements.GetElements()[3]
+		route, ok := findRoute(routes, requestPcode, iso.Mti.String())
+		if !ok {
+			return fmt.Errorf("no route for processing code %s (MTI %s)", requestPcode, iso.Mti.String())
+		}
+		request, err := toolBillerRequest(route, iso)
+		if err != nil {
+			return err
+		}
+		content, _ := json.MarshalIndent(request, "", "  ")
+		fmt.Fprintln(stdout, string(content))
+		return nil
+
+	case "iso":
+		route, ok := findRoute(routes, *pcode, *mti)
+		if !ok {
+			return fmt.Errorf("no route for processing code %q (MTI %s)", *pcode, *mti)
+		}
+		iso, err := toolIsoResponse(route, input)
+		if err != nil {
+			return err
+		}
+		frame, err := flags.frame(flags.resolved.Version.fromInternal(iso))
+		if err != nil {
+			return err
+		}
+		flags.writeFrame(stdout, frame)
+		return nil
+
+	default:
+		return fmt.Errorf("-to must be json or iso")
+	}
+}
+
+// Return Biller request of a route for an ISO8583:1987 request
+func toolBillerRequest(route Route, iso iso8583.IsoStruct) (interface{}, error) {
+	switch route.Handler {
+	case "ppobInquiry":
+		return getJsonPPOBInquiry(iso)
+	case "ppobPayment":
+		return getJsonPPOBPayment(iso)
+	case "ppobStatus":
+		return getJsonPPOBStatus(iso)
+	case "topupBuy":
+		return getJsonTopupBuy(iso)
+	case "topupCheck":
+		return getJsonTopupCheck(iso)
+	default:
+		return getJsonRoute(route, iso)
+	}
+}
+
+// Return ISO8583:1987 response of a route for a Biller JSON response
+func toolIsoResponse(route Route, content []byte) (iso8583.IsoStruct, error) {
+	var err error
+	var iso iso8583.IsoStruct
+
+	switch route.Handler {
+	case "ppobInquiry":
+		var response PPOBInquiryResponse
+		if err = json.Unmarshal(content, &response); err == nil {
+			iso = getIsoPPOBInquiry(route.ProcessingCode, response)
+		}
+	case "ppobPayment":
+		var response PPOBPaymentResponse
+		if err = json.Unmarshal(content, &response); err == nil {
+			iso = getIsoPPOBPayment(route.ProcessingCode, response)
+		}
+	case "ppobStatus":
+		var response PPOBStatusResponse
+		if err = json.Unmarshal(content, &response); err == nil {
+			iso = getIsoPPOBStatus(route.ProcessingCode, response)
+		}
+	case "topupBuy":
+		var response TopupBuyResponse
+		if err = json.Unmarshal(content, &response); err == nil {
+			iso = getIsoTopupBuy(route.ProcessingCode, response)
+		}
+	case "topupCheck":
+		var response TopupCheckResponse
+		if err = json.Unmarshal(content, &response); err == nil {
+			iso = getIsoTopupCheck(route.ProcessingCode, response)
+		}
+	default:
+		var response map[string]interface{}
+		if err = json.Unmarshal(content, &response); err == nil {
+			iso = getIsoRoute(route, route.ProcessingCode, response)
+		}
+	}
+
+	if err != nil {
+		return iso, fmt.Errorf("invalid Biller response: %v", err)
+	}
+	return iso, nil
+}
+
+// lint prints every problem of spec files, it fails if any file has a problem
+func toolLint(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("expected at least one spec file")
+	}
+
+	failed := 0
+	for _, filename := range fs.Args() {
+		problems, err := lintSpecFile(filename)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		for _, problem := range problems {
+			fmt.Fprintf(stdout, "%s: %s\n", filename, problem)
+		}
+		if len(problems) > 0 {
+			failed++
+		} else {
+			fmt.Fprintf(stdout, "%s: ok\n", filename)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d spec files have problems", failed, fs.NArg())
+	}
+	return nil
+}
+
+// Return problems of a spec file, including the ones loading it silently ignores:
+// fields defined more than once and unknown attributes
+func lintSpecFile(filename string) ([]string, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var definitions yaml.MapSlice
+	if err := yaml.Unmarshal(content, &definitions); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	seen := make(map[int]bool)
+	for _, definition := range definitions {
+		field, err := strconv.Atoi(fmt.Sprint(definition.Key))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("key %v: not a field number", definition.Key))
+			continue
+		}
+		if seen[field] {
+			problems = append(problems, fmt.Sprintf("field %d: defined more than once", field))
+		}
+		seen[field] = true
+
+		body, _ := yaml.Marshal(definition.Value)
+		var description fieldDescription
+		if err := yaml.UnmarshalStrict(body, &description); err != nil {
+			problems = append(problems, fmt.Sprintf("field %d: %v", field, err))
+		}
+	}
+
+	s := &Spec{}
+	if err := s.readFromFile(filename); err != nil {
+		return append(problems, err.Error()), nil
+	}
+	if len(s.fields) == 0 {
+		return append(problems, "spec has no fields"), nil
+	}
+	return append(problems, s.problems()...), nil
+}
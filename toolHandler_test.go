@@ -0,0 +1,82 @@
+package kafkabiller
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToolEncodeDecode(t *testing.T) {
+	input := `{"mti": "0200", "fields": {"3": "380001", "4": 1500, "62": "003A,B"}}`
+
+	var encoded bytes.Buffer
+	if err := RunTool([]string{"encode"}, strings.NewReader(input), &encoded); err != nil {
+		t.Fatalf("RunTool(encode) failed. Error: %v", err)
+	}
+	expected := "00630200b0000000000000040000000000000000380001000000001500006003A,B\n"
+	if encoded.String() != expected {
+		t.Errorf("RunTool(encode) failed. Expected: %q. Got: %q", expected, encoded.String())
+	}
+
+	var decoded bytes.Buffer
+	if err := RunTool([]string{"decode"}, &encoded, &decoded); err != nil {
+		t.Fatalf("RunTool(decode) failed. Error: %v", err)
+	}
+	for _, line := range []string{"Processing code", "[000000001500]", "struk line 1", "[A,B]"} {
+		if !strings.Contains(decoded.String(), line) {
+			t.Errorf("RunTool(decode) failed. Expected %q in:\n%v", line, decoded.String())
+		}
+	}
+	t.Log("runTool encode and decode success")
+}
+
+func TestToolConvert(t *testing.T) {
+	input := `{"rc": "05", "msg": "gagal", "restime": "2021-03-18 08:03:23"}`
+
+	var output bytes.Buffer
+	args := []string{"convert", "-to", "iso", "-pcode", "380001", "-hex", "-framing", "none"}
+	if err := RunTool(args, strings.NewReader(input), &output); err != nil {
+		t.Fatalf("RunTool(convert) failed. Error: %v", err)
+	}
+	if !strings.HasPrefix(output.String(), "30323130") {
+		t.Errorf("RunTool(convert) failed. Expected hex of a 0210 response. Got: %v", output.String())
+	} else {
+		t.Log("runTool convert success")
+	}
+}
+
+func TestToolLint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "spec.yml")
+	content := "3:\n  ContentType: \"n\"\n  LenType: fixed\n  MaxLen: 6\n" +
+		"3:\n  ContentType: \"n\"\n  LenType: fixed\n  MaxLen: 6\n" +
+		"4:\n  ContentType: \"n\"\n  Lentype: fixed\n  MaxLen: 12\n"
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	if err := RunTool([]string{"lint", filename}, nil, &output); err == nil {
+		t.Errorf("RunTool(lint) failed. Expected error")
+	}
+	for _, problem := range []string{"field 3: defined more than once", "field 4:", "Lentype", "field 2: not defined"} {
+		if !strings.Contains(output.String(), problem) {
+			t.Errorf("RunTool(lint) failed. Expected %q in:\n%v", problem, output.String())
+		}
+	}
+
+	output.Reset()
+	if err := RunTool([]string{"lint", "spec1987.yml"}, nil, &output); err != nil {
+		t.Errorf("RunTool(lint) failed. Error: %v\n%v", err, output.String())
+	} else {
+		t.Log("runTool lint success")
+	}
+}
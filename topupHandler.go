@@ -0,0 +1,129 @@
+package kafkabiller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+)
+
+// Topup routing strategies
+const (
+	topupFailover  = "failover"  // suppliers are tried in the configured order
+	topupLeastCost = "leastcost" // suppliers are tried from the lowest price up
+)
+
+// Suppliers of a topup product in biller.yml and the order they are tried in
+type TopupRoute struct {
+	Strategy      string          `yaml:"Strategy"`
+	Suppliers     []TopupSupplier `yaml:"Suppliers"`
+	FailoverCodes []string        `yaml:"FailoverCodes"`
+}
+
+// Supplier of a topup product and its price
+type TopupSupplier struct {
+	Biller string `yaml:"Biller"`
+	Price  Money  `yaml:"Price"`
+}
+
+// Check that the route can be used with the Billers of the environment, suppliers are sorted by
+// the route's strategy
+func (r *TopupRoute) validate(billers map[string]*BillerConnection) error {
+	if r.Strategy == "" {
+		r.Strategy = topupFailover
+	}
+	if r.Strategy != topupFailover && r.Strategy != topupLeastCost {
+		return fmt.Errorf("unknown strategy %q", r.Strategy)
+	}
+	if len(r.Suppliers) == 0 {
+		return fmt.Errorf("no supplier defined")
+	}
+
+	seen := make(map[string]bool, len(r.Suppliers))
+	for _, supplier := range r.Suppliers {
+		if _, ok := billers[supplier.Biller]; !ok {
+			return fmt.Errorf("supplier %s: Biller is not defined", supplier.Biller)
+		}
+		if seen[supplier.Biller] {
+			return fmt.Errorf("supplier %s: listed twice", supplier.Biller)
+		}
+		seen[supplier.Biller] = true
+		if r.Strategy == topupLeastCost && supplier.Price <= 0 {
+			return fmt.Errorf("supplier %s: %s needs a price", supplier.Biller, topupLeastCost)
+		}
+	}
+	for _, rc := range r.FailoverCodes {
+		if rc == "00" {
+			return fmt.Errorf("approved rc 00 can't fail over")
+		}
+	}
+
+	// Equal prices keep the configured order
+	if r.Strategy == topupLeastCost {
+		sort.SliceStable(r.Suppliers, func(i, j int) bool {
+			return r.Suppliers[i].Price < r.Suppliers[j].Price
+		})
+	}
+	return nil
+}
+
+// Return true if the next supplier may be tried after a supplier answered with rc
+func (r *TopupRoute) failover(rc string) bool {
+	for _, code := range r.FailoverCodes {
+		if rc == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Return true if the Biller call failed before the request could reach the Biller, only then is it
+// safe to send the same transaction to another supplier
+func billerNotSent(err error) bool {
+	var billerErr *BillerError
+	if !errors.As(err, &billerErr) {
+		return false
+	}
+	switch billerErr.Kind {
+	case billerRequestFailed, billerCircuitOpen, billerBulkheadFull, billerRateLimited, billerQuotaExceeded:
+		return true
+	case billerConnectFailed:
+		var opErr *net.OpError
+		return errors.As(err, &opErr) && opErr.Op == "dial"
+	}
+	return false
+}
+
+// Send Topup Buy to the suppliers of its product until one of them takes it. The Biller that got the
+// last request is returned with its response so a suspect buy is checked there.
+func topupBuy(route *TopupRoute, billers []Biller, request TopupBuyRequest) (Biller, TopupBuyResponse, error) {
+	var biller Biller
+	var response TopupBuyResponse
+	var err error
+
+	for i := range billers {
+		biller = billers[i]
+		response, err = biller.TopupBuy(request)
+		if i == len(billers)-1 {
+			break
+		}
+
+		// A buy that may have been processed is never sent to a second supplier
+		var reason string
+		switch {
+		case suspectPolicy.reason(err, response.Rc) != "":
+			return biller, response, err
+		case err != nil && billerNotSent(err):
+			reason = "unavailable"
+		case err == nil && route.failover(response.Rc):
+			reason = "rc " + response.Rc
+		default:
+			return biller, response, err
+		}
+
+		log.Printf("Topup Buy %s not taken by %s (%s), trying %s\n", request.TransactionID, biller.Name(), reason, billers[i+1].Name())
+		metrics.inc("topup_failover", "product", request.ProductCode, "from", biller.Name(), "to", billers[i+1].Name())
+	}
+	return biller, response, err
+}
@@ -0,0 +1,132 @@
+package kafkabiller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Return test server answering Topup Buy with a fixed body after a delay, calls counts the requests
+func testSupplier(body string, delay time.Duration, calls *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		time.Sleep(delay)
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestTopupRouteLeastCost(t *testing.T) {
+	billers := map[string]*BillerConnection{"a": {}, "b": {}, "c": {}}
+	route := &TopupRoute{Strategy: topupLeastCost, Suppliers: []TopupSupplier{
+		{Biller: "a", Price: 10150}, {Biller: "b", Price: 10100}, {Biller: "c", Price: 10150},
+	}}
+	if err := route.validate(billers); err != nil {
+		t.Fatalf("TopupRoute.validate() failed. Error: %v", err)
+	}
+
+	var order []string
+	for _, supplier := range route.Suppliers {
+		order = append(order, supplier.Biller)
+	}
+	if fmt.Sprint(order) != "[b a c]" {
+		t.Errorf("TopupRoute.validate() failed. Expected: [b a c]. Got: %v", order)
+	} else {
+		t.Log("TopupRoute.validate() success")
+	}
+
+	invalid := []*TopupRoute{
+		{Strategy: "random", Suppliers: []TopupSupplier{{Biller: "a"}}},
+		{Strategy: topupLeastCost, Suppliers: []TopupSupplier{{Biller: "a"}}},
+		{Suppliers: []TopupSupplier{{Biller: "d"}}},
+		{Suppliers: []TopupSupplier{{Biller: "a"}, {Biller: "a"}}},
+		{Suppliers: []TopupSupplier{{Biller: "a"}}, FailoverCodes: []string{"00"}},
+	}
+	for i, route := range invalid {
+		if err := route.validate(billers); err == nil {
+			t.Errorf("TopupRoute.validate() %d failed. Expected: error. Got: nil", i)
+		}
+	}
+}
+
+func TestTopupBuyFailover(t *testing.T) {
+	route := &TopupRoute{FailoverCodes: []string{"14", "91"}}
+	tests := []struct {
+		name     string
+		first    string
+		delay    time.Duration
+		down     bool
+		expected string
+		calls    int
+	}{
+		{"success", `{"rc":"00","msg":"SUKSES"}`, 0, false, "a", 0},
+		{"failover rc", `{"rc":"14","msg":"NOMOR SALAH"}`, 0, false, "b", 1},
+		{"final rc", `{"rc":"13","msg":"GAGAL"}`, 0, false, "a", 0},
+		{"unavailable", "", 0, true, "b", 1},
+		{"timeout", `{"rc":"00"}`, 200 * time.Millisecond, false, "a", 0},
+		{"pending", `{"rc":"68","msg":"PENDING"}`, 0, false, "a", 0},
+	}
+
+	for _, test := range tests {
+		var firstCalls, secondCalls int
+		first := testSupplier(test.first, test.delay, &firstCalls)
+		second := testSupplier(`{"rc":"00","msg":"SUKSES"}`, 0, &secondCalls)
+		firstURL := first.URL
+		if test.down {
+			firstURL = "http://127.0.0.1:1"
+		}
+		billers := []Biller{
+			testBiller(t, "a", "chipsakti", firstURL, 50*time.Millisecond),
+			testBiller(t, "b", "chipsakti", second.URL, 50*time.Millisecond),
+		}
+
+		biller, _, _ := topupBuy(route, billers, TopupBuyRequest{TransactionID: "1", ProductCode: "TSEL10"})
+		if biller.Name() != test.expected || secondCalls != test.calls {
+			t.Errorf("%v: topupBuy() failed. Expected: %v, %d calls to b. Got: %v, %d calls to b", test.name, test.expected, test.calls, biller.Name(), secondCalls)
+		} else {
+			t.Logf("%v: topupBuy() success", test.name)
+		}
+		first.Close()
+		second.Close()
+	}
+}
+
+func TestTopupBillers(t *testing.T) {
+	env := &BillerEnvironment{
+		Name:     "test",
+		Default:  "a",
+		Partners: map[string]string{"P2": "a"},
+		Billers: map[string]*BillerConnection{
+			"a": {BaseURL: "http://127.0.0.1:1", ConnectTimeout: time.Second, ReadTimeout: time.Second, Signature: testSignature()},
+			"b": {BaseURL: "http://127.0.0.1:2", ConnectTimeout: time.Second, ReadTimeout: time.Second, Signature: testSignature()},
+		},
+		TopupRoutes: map[string]*TopupRoute{
+			"TSEL10": {Strategy: topupLeastCost, Suppliers: []TopupSupplier{{Biller: "a", Price: 10150}, {Biller: "b", Price: 10100}}},
+		},
+	}
+	if err := env.validate(); err != nil {
+		t.Fatalf("BillerEnvironment.validate() failed. Error: %v", err)
+	}
+	env.connect()
+
+	tests := []struct {
+		product, partner string
+		expected         string
+	}{
+		{"TSEL10", "P1", "[b a]"},
+		{"TSEL10", "P2", "[a]"},
+		{"XL10", "P1", "[a]"},
+	}
+	for _, test := range tests {
+		_, billers := env.topupBillers("", test.product, test.partner)
+		var names []string
+		for _, biller := range billers {
+			names = append(names, biller.Name())
+		}
+		if fmt.Sprint(names) != test.expected {
+			t.Errorf("BillerEnvironment.topupBillers(%q, %q) failed. Expected: %v. Got: %v", test.product, test.partner, test.expected, names)
+		}
+	}
+	t.Log("BillerEnvironment.topupBillers() success")
+}
@@ -0,0 +1,126 @@
+package kafkabiller
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Checks of Biller responses in biller.yml, nothing is checked when both are empty
+type BillerVerify struct {
+	Signature ResponseSignature `yaml:"Signature"`
+	Echo      map[string]string `yaml:"Echo"`
+}
+
+// Where the Biller puts the signature of its response and what it signs. The signature is made
+// with the Biller's (or partner's) Signature Algorithm and any secret in use.
+type ResponseSignature struct {
+	Header   string `yaml:"Header"`
+	Field    string `yaml:"Field"`
+	Template string `yaml:"Template"`
+}
+
+// Check that the response checks can be made with the Biller's signature settings
+func (v BillerVerify) validate(scheme *SignatureScheme) error {
+	signature := v.Signature
+	if signature.Header != "" && signature.Field != "" {
+		return fmt.Errorf("response signature needs either Header or Field")
+	}
+	if signature.Header == "" && signature.Field == "" {
+		if signature.Template != "" {
+			return fmt.Errorf("response signature template needs Header or Field")
+		}
+	} else {
+		// A signature in the body can't sign the body it's in
+		if signature.Field != "" && signature.Template == "" {
+			return fmt.Errorf("response signature Field needs a Template")
+		}
+
+		// A plain hash of the body proves nothing, the secret has to be part of it
+		schemes := []*SignatureScheme{scheme}
+		for _, partner := range scheme.Partners {
+			schemes = append(schemes, partner)
+		}
+		for _, s := range schemes {
+			if !strings.HasPrefix(s.Algorithm, "hmac-") && !strings.Contains(signature.Template, "{"+signatureSecret+"}") {
+				return fmt.Errorf("response signature with %s needs {%s} in Template", s.Algorithm, signatureSecret)
+			}
+		}
+	}
+
+	for field, requestField := range v.Echo {
+		if field == "" || requestField == "" {
+			return fmt.Errorf("echo needs a response and a request field")
+		}
+	}
+	return nil
+}
+
+// verify checks a Biller response against the request it answers: its signature must be made with
+// a secret in use and an approval must echo the configured request fields
+func (c *BillerConnection) verify(endpoint string, request map[string]string, header http.Header, content []byte) error {
+	if c.Verify.Signature.Header == "" && c.Verify.Signature.Field == "" && len(c.Verify.Echo) == 0 {
+		return nil
+	}
+
+	response, err := jsonValues(content)
+	if err != nil {
+		return &BillerError{Kind: billerInvalidJSON, Biller: c.Name, Endpoint: endpoint, Err: err}
+	}
+
+	if err := c.verifySignature(request["partner_id"], header, content, response); err != nil {
+		metrics.inc("biller_unverified", "biller", c.Name, "endpoint", endpoint, "reason", "signature")
+		return &BillerError{Kind: billerUnverified, Biller: c.Name, Endpoint: endpoint, Err: err}
+	}
+
+	// Only an approval has to match, a decline may come without the transaction's details. The error
+	// only names the fields, it ends up in field 120 and the logs where values would leak customer data
+	if response["rc"] != "00" {
+		return nil
+	}
+	for field, requestField := range c.Verify.Echo {
+		expected, ok := request[requestField]
+		if !ok {
+			continue
+		}
+		if response[field] != expected {
+			metrics.inc("biller_unverified", "biller", c.Name, "endpoint", endpoint, "reason", "echo")
+			return &BillerError{Kind: billerUnverified, Biller: c.Name, Endpoint: endpoint,
+				Err: fmt.Errorf("response %s doesn't match request %s", field, requestField)}
+		}
+	}
+	return nil
+}
+
+// Check the response signature with every secret of the partner's scheme in use
+func (c *BillerConnection) verifySignature(partnerID string, header http.Header, content []byte, response map[string]string) error {
+	signature := c.Verify.Signature
+	var received string
+	switch {
+	case signature.Header != "":
+		received = header.Get(signature.Header)
+	case signature.Field != "":
+		received = response[signature.Field]
+	default:
+		return nil
+	}
+	if received == "" {
+		return fmt.Errorf("response has no signature")
+	}
+
+	scheme := c.Signature.partner(partnerID)
+	for _, secret := range scheme.secrets(time.Now()) {
+		var expected string
+		if signature.Template == "" {
+			expected = signatureAlgorithms[scheme.Algorithm](secret.value, string(content))
+		} else {
+			expected = scheme.sign(signature.Template, response, secret)
+		}
+		if hmac.Equal([]byte(strings.ToLower(received)), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("response signature doesn't match")
+}
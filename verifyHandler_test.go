@@ -0,0 +1,148 @@
+package kafkabiller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBillerVerify(t *testing.T) {
+	body := `{"rc":"00","msg":"SUKSES","nopel":"081234567890","total_tagihan":"873300"}`
+	signature := signatureHMAC(sha256.New, "unand", body)
+
+	tests := []struct {
+		name      string
+		signature string
+		body      string
+		rc        string
+	}{
+		{"valid", signature, body, ""},
+		{"no signature", "", body, "96"},
+		{"wrong signature", signatureHMAC(sha256.New, "other", body), body, "96"},
+		{"wrong customer", signatureHMAC(sha256.New, "unand", `{"rc":"00","nopel":"089999999999","total_tagihan":"873300"}`),
+			`{"rc":"00","nopel":"089999999999","total_tagihan":"873300"}`, "96"},
+		{"wrong amount", signatureHMAC(sha256.New, "unand", `{"rc":"00","nopel":"081234567890","total_tagihan":873301}`),
+			`{"rc":"00","nopel":"081234567890","total_tagihan":873301}`, "96"},
+		{"decline", signatureHMAC(sha256.New, "unand", `{"rc":"14","msg":"GAGAL"}`), `{"rc":"14","msg":"GAGAL"}`, ""},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Signature", test.signature)
+			fmt.Fprint(w, test.body)
+		}))
+
+		scheme := testSignature()
+		scheme.Algorithm = "hmac-sha256"
+		conn := &BillerConnection{Name: "test", BaseURL: server.URL, ConnectTimeout: time.Second, ReadTimeout: time.Second,
+			Signature: scheme, Verify: BillerVerify{
+				Signature: ResponseSignature{Header: "X-Signature"},
+				Echo:      map[string]string{"nopel": "customer_no", "total_tagihan": "amount", "periode": "periode"},
+			}}
+		if err := conn.validate("test"); err != nil {
+			t.Fatalf("BillerConnection.validate() failed. Error: %v", err)
+		}
+		conn.connect()
+
+		_, err := (&chipsaktiBiller{conn: conn}).Payment(PPOBPaymentRequest{TransactionID: "1", CustomerNo: "081234567890", Amount: 873300})
+		if rc := billerResponseCode(err); (err == nil && test.rc != "") || (err != nil && rc != test.rc) {
+			t.Errorf("%v: BillerConnection.verify() failed. Expected: %q. Got: %v", test.name, test.rc, err)
+		} else {
+			t.Logf("%v: BillerConnection.verify() success", test.name)
+		}
+		server.Close()
+	}
+}
+
+func TestBillerVerifyTemplate(t *testing.T) {
+	conn := &BillerConnection{Name: "test", Signature: testSignature(), Verify: BillerVerify{
+		Signature: ResponseSignature{Field: "signature", Template: "{rc}{nopel}{secret}"},
+	}}
+	conn.Signature.validate()
+	conn.Signature.load()
+
+	response := fmt.Sprintf(`{"rc":"00","nopel":"081234567890","signature":"%s"}`, signatureSHA256("00081234567890unand"))
+	if err := conn.verify("/payment", map[string]string{}, http.Header{}, []byte(response)); err != nil {
+		t.Errorf("BillerConnection.verify() failed. Error: %v", err)
+	} else {
+		t.Log("BillerConnection.verify() template success")
+	}
+
+	invalid := []BillerVerify{
+		{Signature: ResponseSignature{Header: "X-Signature", Field: "signature"}},
+		{Signature: ResponseSignature{Field: "signature"}},
+		{Signature: ResponseSignature{Header: "X-Signature"}},
+		{Signature: ResponseSignature{Template: "{rc}"}},
+	}
+	for i, verify := range invalid {
+		if err := verify.validate(&conn.Signature); err == nil {
+			t.Errorf("BillerVerify.validate() %d failed. Expected: error. Got: nil", i)
+		}
+	}
+}
+
+func TestBillerVerifyEchoMasked(t *testing.T) {
+	// Approval echoing another customer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"rc":"00","msg":"SUKSES","nopel":"089999999999"}`)
+	}))
+	defer server.Close()
+
+	conn := &BillerConnection{Name: "test", Type: "chipsakti", BaseURL: server.URL, ConnectTimeout: time.Second, ReadTimeout: time.Second,
+		Signature: testSignature(), Verify: BillerVerify{Echo: map[string]string{"nopel": "customer_no"}}}
+	if err := conn.validate("test"); err != nil {
+		t.Fatalf("BillerConnection.validate() failed. Error: %v", err)
+	}
+	conn.connect()
+
+	defer func(env *BillerEnvironment, routes []Route) { billerEnv, routingTable = env, routes }(billerEnv, routingTable)
+	billerEnv = &BillerEnvironment{Default: "test", billers: map[string]Biller{"test": &chipsaktiBiller{conn: conn}}}
+	routingTable = []Route{{ProcessingCode: "380001", Handler: "ppobInquiry"}}
+
+	dir, _ := ioutil.TempDir("", "verify")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "storage", "response"), 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	field48, err := buildField48("380001", map[string]string{"transaction_id": "2021", "partner_id": "USER01", "product_code": "WOM",
+		"customer_no": "081234567890", "merchant_code": "KIOS01", "request_time": "2018-05-15 15:10:05"})
+	if err != nil {
+		t.Fatalf("buildField48() failed. Error: %v", err)
+	}
+	message := getIso(map[int]string{3: "380001", 4: "000000873300", 48: field48}, "0200")
+	inquiry, _ := message.ToString()
+	request := PPOBInquiryRequest{CustomerNo: "081234567890"}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	frame, _ := asciiFramer{}.Frame(inquiry, "")
+	response, _, _ := asciiFramer{}.Unframe(getResponse(frame, channelFor("verify-test"), time.Now()))
+
+	result, err := isoSpec.parse(response)
+	if err != nil {
+		t.Fatalf("getResponse() failed. Response can't be parsed: %v", err)
+	}
+	fields := result.Elements.GetElements()
+	for _, customerNo := range []string{request.CustomerNo, "089999999999"} {
+		if strings.Contains(fields[120], customerNo) || strings.Contains(logs.String(), customerNo) {
+			t.Errorf("getResponse() failed. Expected: customer number %s is not in field 120 or the log. Got: %q", customerNo, fields[120])
+		}
+	}
+	if fields[39] != "96" || !strings.Contains(fields[120], "response nopel doesn't match request customer_no") {
+		t.Errorf("getResponse() failed. Expected: rc 96 naming the fields. Got: %v %q", fields[39], fields[120])
+	} else {
+		t.Log("BillerConnection.verify() echo masked success")
+	}
+}
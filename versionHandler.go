@@ -0,0 +1,197 @@
+package kafkabiller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"github.com/mofax/iso8583"
+)
+
+// ISO8583 versions by name, loaded from versions.yml at startup
+var isoVersions map[string]*IsoVersion
+
+// Struct for versions.yml
+type IsoVersion struct {
+	Name                string            `yaml:"-"`
+	SpecFile            string            `yaml:"Spec"`
+	MTIVersion          string            `yaml:"MTIVersion"`
+	ResponseCodes       map[string]string `yaml:"ResponseCodes"`
+	DefaultResponseCode string            `yaml:"DefaultResponseCode"`
+	Fields              []int             `yaml:"Fields"`
+	Spec                *Spec             `yaml:"-"`
+
+	fields      map[int]bool      // Fields accepted in a request
+	actionCodes map[string]string // action code in field 39 to ISO8583:1987 response code
+}
+
+// Return ISO8583 versions from a yaml file, with their spec loaded
+func versionsFromFile(filename string) (map[string]*IsoVersion, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions map[string]*IsoVersion
+	if err := yaml.Unmarshal(content, &versions); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if _, ok := versions["1987"]; !ok {
+		return nil, fmt.Errorf("%s: version 1987 is used internally and must be defined", filename)
+	}
+
+	for name, version := range versions {
+		version.Name = name
+		if len(version.MTIVersion) != 1 || !isDigit(rune(version.MTIVersion[0])) {
+			return nil, fmt.Errorf("%s: %s: MTIVersion must be a single digit", filename, name)
+		}
+		version.Spec, err = specFromFile(version.SpecFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", filename, name, err)
+		}
+
+		version.fields = make(map[int]bool, len(version.Fields))
+		for _, field := range version.Fields {
+			if field < 2 || field > 128 {
+				return nil, fmt.Errorf("%s: %s: field %d out of range", filename, name, field)
+			}
+			version.fields[field] = true
+		}
+		version.actionCodes = make(map[string]string, len(version.ResponseCodes))
+		for rc, actionCode := range version.ResponseCodes {
+			if other, ok := version.actionCodes[actionCode]; ok {
+				return nil, fmt.Errorf("%s: %s: action code %s translates back to both %s and %s", filename, name, actionCode, rc, other)
+			}
+			version.actionCodes[actionCode] = rc
+		}
+	}
+	return versions, nil
+}
+
+// Return version by name, empty name is ISO8583:1987
+func versionByName(name string) (*IsoVersion, error) {
+	if name == "" {
+		name = "1987"
+	}
+	version, ok := isoVersions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown ISO8583 version %q", name)
+	}
+	return version, nil
+}
+
+// Return true if the version is the one used internally
+func (v *IsoVersion) isInternal() bool {
+	return v.MTIVersion == "0"
+}
+
+// toInternal converts a parsed message of this version to an ISO8583:1987 request. Only the
+// version's Fields are accepted, each of them converted to its ISO8583:1987 format
+func (v *IsoVersion) toInternal(iso iso8583.IsoStruct) (iso8583.IsoStruct, error) {
+	mti := iso.Mti.String()
+	if !strings.HasPrefix(mti, v.MTIVersion) {
+		return iso, fmt.Errorf("MTI %s is not an ISO8583:%s MTI", mti, v.Name)
+	}
+	if v.isInternal() {
+		return iso, nil
+	}
+
+	elements := iso.Elements.GetElements()
+	internal := isoSpec.newIso()
+	for field, value := range elements {
+		value, err := v.fieldToInternal(int(field), value, elements[3])
+		if err != nil {
+			return iso, err
+		}
+		internal.AddField(field, value)
+	}
+	if err := internal.AddMTI("0" + mti[1:]); err != nil {
+		return iso, err
+	}
+	return internal, nil
+}
+
+// Return value of a field of this version in its ISO8583:1987 format
+func (v *IsoVersion) fieldToInternal(field int, value string, pcode string) (string, error) {
+	if !v.fields[field] {
+		return "", fmt.Errorf("field %d is not supported on ISO8583:%s channels", field, v.Name)
+	}
+
+	switch field {
+	// Date and time YYMMDDhhmmss, ISO8583:1987 only has the time
+	case 12:
+		if len(value) == 12 {
+			value = value[6:]
+		}
+
+	// Action code back to the response code it was translated from
+	case 39:
+		rc, ok := v.actionCodes[value]
+		if !ok {
+			return "", fmt.Errorf("field 39: action code %s has no ISO8583:1987 response code", value)
+		}
+		value = rc
+
+	// Field 48 of the version's own layout is rebuilt with the ISO8583:1987 layout, without a
+	// version layout the channel sends the ISO8583:1987 layout
+	case 48:
+		if layout, ok := field48Layouts[v.Name+"/"+pcode]; ok {
+			values, err := parseSubFields(pcode, layout, value)
+			if err != nil {
+				return "", err
+			}
+			if value, err = buildField48(pcode, values); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// Variable field of the version may be fixed in ISO8583:1987, e.g. field 43
+	internal := isoSpec.fields[field]
+	if len(value) > internal.MaxLen {
+		return "", fmt.Errorf("field %d is %d characters, ISO8583:1987 allows %d", field, len(value), internal.MaxLen)
+	}
+	if internal.LenType == "fixed" && len(value) < internal.MaxLen {
+		if internal.ContentType == "n" {
+			value = leftPad(value, internal.MaxLen, "0")
+		} else {
+			value = rightPad(value, internal.MaxLen, " ")
+		}
+	}
+	return value, nil
+}
+
+// fromInternal converts an ISO8583:1987 response to a message of this version
+func (v *IsoVersion) fromInternal(iso iso8583.IsoStruct) iso8583.IsoStruct {
+	if v.isInternal() {
+		return iso
+	}
+
+	data := make(map[int]string)
+	for field, value := range iso.Elements.GetElements() {
+		// Padding of a fixed ISO8583:1987 field isn't needed when the version has it variable
+		if isoSpec.fields[int(field)].LenType == "fixed" && v.Spec.fields[int(field)].LenType != "fixed" &&
+			isoSpec.fields[int(field)].ContentType != "n" {
+			value = strings.TrimRight(value, " ")
+		}
+		data[int(field)] = value
+	}
+	if rc, ok := data[39]; ok {
+		data[39] = v.responseCode(rc)
+	}
+	mti := v.MTIVersion + iso.Mti.String()[1:]
+
+	log.Printf("Converting response to ISO8583:%s, MTI %s\n", v.Name, mti)
+	return buildIso(v.Spec, data, mti)
+}
+
+// Return the version's response code for an ISO8583:1987 response code
+func (v *IsoVersion) responseCode(rc string) string {
+	if translated, ok := v.ResponseCodes[rc]; ok {
+		return translated
+	}
+	log.Printf("No ISO8583:%s translation for response code %s, using %s\n", v.Name, rc, v.DefaultResponseCode)
+	return v.DefaultResponseCode
+}
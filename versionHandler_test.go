@@ -0,0 +1,149 @@
+package kafkabiller
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersionsFromFile(t *testing.T) {
+	versions, err := versionsFromFile("versions.yml")
+	if err != nil {
+		t.Errorf("versionsFromFile() failed. Error: %v", err)
+	}
+
+	for _, name := range []string{"1987", "1993", "2003"} {
+		if versions[name] == nil || versions[name].Spec == nil {
+			t.Errorf("versionsFromFile() failed. Expected version %v with spec", name)
+		}
+	}
+	if versions["2003"].responseCode("00") != "000" {
+		t.Errorf("versionsFromFile() failed. Expected 2003 to translate 00 to 000. Got: %v", versions["2003"].responseCode("00"))
+	} else {
+		t.Log("versionsFromFile() success")
+	}
+}
+
+func TestVersion1993(t *testing.T) {
+	version, _ := versionByName("1993")
+
+	request := "1200a00000000001000000000000000000003800011302021                     USER01          WOM             2                        KIOS01                   2018-05-15 15:10:052020"
+	iso, err := version.Spec.parse(request)
+	if err != nil {
+		t.Errorf("Error parsing iso message. Error: %v", err)
+	}
+	iso, err = version.toInternal(iso)
+	if err != nil || iso.Mti.String() != "0200" {
+		t.Errorf("toInternal() failed. Expected MTI 0200. Got: %v (%v)", iso.Mti.String(), err)
+	}
+
+	response := version.fromInternal(getIso(map[int]string{3: "380001", 39: "30", 43: "HANAFI"}, "0210"))
+	expected := "1210a000000002200000000000000000000038000190406HANAFI"
+	result, _ := response.ToString()
+
+	if result != expected {
+		t.Errorf("fromInternal() failed, \nexpected\t: %v, \ngot\t\t\t: %v", expected, result)
+	} else {
+		t.Log("fromInternal() success")
+	}
+}
+
+func TestVersionWrongMTI(t *testing.T) {
+	version, _ := versionByName("1993")
+	iso := getIso(map[int]string{3: "380001"}, "0200")
+
+	if _, err := version.toInternal(iso); err == nil {
+		t.Errorf("toInternal() failed. Expected error for ISO8583:1987 MTI")
+	} else {
+		t.Log("toInternal() success")
+	}
+}
+
+func TestVersion1993RoundTrip(t *testing.T) {
+	version, _ := versionByName("1993")
+	field48 := "2021                     USER01          WOM             2                        KIOS01                   2018-05-15 15:10:05"
+
+	// Request as a 1993 channel sends it
+	request := buildIso(version.Spec, map[int]string{
+		3: "380001", 12: "210318080323", 41: "TERM01", 43: "KIOS HANAFI", 48: field48,
+	}, "1200")
+	message, _ := request.ToString()
+	parsed, err := version.Spec.parse(message)
+	if err != nil {
+		t.Fatalf("Error parsing iso message. Error: %v", err)
+	}
+
+	internal, err := version.toInternal(parsed)
+	if err != nil {
+		t.Fatalf("toInternal() failed. Error: %v", err)
+	}
+	elements := internal.Elements.GetElements()
+	expected := map[int64]string{
+		3: "380001", 12: "080323", 41: "TERM01          ", 43: "KIOS HANAFI" + strings.Repeat(" ", 29), 48: field48,
+	}
+	for field, value := range expected {
+		if elements[field] != value {
+			t.Errorf("toInternal() failed at field %d. Expected: %q. Got: %q", field, value, elements[field])
+		}
+	}
+	if err := isoSpec.validateMessage(internal, []int{3, 48}); err != nil || internal.Mti.String() != "0200" {
+		t.Errorf("toInternal() failed. Expected: valid 0200 request. Got: %v (%v)", internal.Mti.String(), err)
+	}
+	if inquiry, err := getJsonPPOBInquiry(internal); err != nil || inquiry.CustomerNo != "2" {
+		t.Errorf("getJsonPPOBInquiry() failed. Expected: customer 2. Got: %+v (%v)", inquiry, err)
+	}
+
+	// Response back to the channel and read again as the channel's next request would be
+	response := version.fromInternal(getIso(map[int]string{3: "380001", 39: "14", 43: "HANAFI"}, "0210"))
+	message, _ = response.ToString()
+	parsed, _ = version.Spec.parse(message)
+	if parsed.Elements.GetElements()[39] != "111" {
+		t.Errorf("fromInternal() failed. Expected: action code 111. Got: %v", parsed.Elements.GetElements()[39])
+	}
+	internal, err = version.toInternal(parsed)
+	if err != nil || internal.Elements.GetElements()[39] != "14" || internal.Mti.String() != "0210" {
+		t.Errorf("toInternal() failed. Expected: 0210 with rc 14. Got: %v %v (%v)", internal.Mti.String(), internal.Elements.GetElements()[39], err)
+	} else {
+		t.Log("ISO8583:1993 round trip success")
+	}
+}
+
+func TestVersion1993Rejected(t *testing.T) {
+	version, _ := versionByName("1993")
+
+	tests := []struct {
+		data     map[int]string
+		expected string
+	}{
+		{map[int]string{3: "380001", 55: "\x9f\x02"}, "field 55 is not supported on ISO8583:1993 channels"},
+		{map[int]string{3: "380001", 39: "999"}, "field 39: action code 999 has no ISO8583:1987 response code"},
+		{map[int]string{3: "380001", 43: strings.Repeat("N", 41)}, "field 43 is 41 characters, ISO8583:1987 allows 40"},
+	}
+	for _, test := range tests {
+		iso := buildIso(version.Spec, test.data, "1200")
+		if _, err := version.toInternal(iso); err == nil || err.Error() != test.expected {
+			t.Errorf("toInternal() failed. Expected: %v. Got: %v", test.expected, err)
+		}
+	}
+	t.Log("toInternal() rejected fields success")
+}
+
+func TestVersion1993Field48Layout(t *testing.T) {
+	version, _ := versionByName("1993")
+	field48Layouts["1993/380001"] = []subFieldDescription{
+		{Name: "transaction_id", Delimiter: "|"}, {Name: "partner_id", Delimiter: "|"}, {Name: "product_code", Delimiter: "|"},
+		{Name: "customer_no", Delimiter: "|"}, {Name: "merchant_code", Delimiter: "|"}, {Name: "request_time"},
+	}
+	defer delete(field48Layouts, "1993/380001")
+
+	iso := buildIso(version.Spec, map[int]string{3: "380001", 48: "2021|USER01|WOM|2|KIOS01|2018-05-15 15:10:05"}, "1200")
+	internal, err := version.toInternal(iso)
+	if err != nil {
+		t.Fatalf("toInternal() failed. Error: %v", err)
+	}
+	values, err := parseField48("380001", internal.Elements.GetElements()[48])
+	if err != nil || values["customer_no"] != "2" || values["request_time"] != "2018-05-15 15:10:05" {
+		t.Errorf("toInternal() failed. Expected: field 48 in the 380001 layout. Got: %v (%v)", values, err)
+	} else {
+		t.Log("toInternal() field 48 layout success")
+	}
+}